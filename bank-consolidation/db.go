@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/config"
+	"bank-consolidation/internal/services"
 	"bank-consolidation/models"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"strconv"
 	"time"
 
 	"gorm.io/driver/mysql"
@@ -14,8 +19,8 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-func initDB(dsn string) *gorm.DB {
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+func initDB(cfg config.Config) *gorm.DB {
+	db, err := gorm.Open(mysql.Open(cfg.MySQLDSN()), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
 	if err != nil {
@@ -33,11 +38,19 @@ func initDB(dsn string) *gorm.DB {
 	if err := migrate(db); err != nil {
 		log.Fatalf("migrate: %v", err)
 	}
-	if os.Getenv("SEED_DEV") == "1" {
+	if cfg.SeedDev {
 		if err := seedDevData(db); err != nil {
 			log.Fatalf("seed: %v", err)
 		}
 	}
+	adminPassword := os.Getenv("ADMIN_PASSWORD")
+	password := adminPassword
+	if password == "" && cfg.SeedDev {
+		password = "admin123"
+	}
+	if err := ensureAdminUser(db, password, adminPassword); err != nil {
+		log.Fatalf("admin user: %v", err)
+	}
 	return db
 }
 
@@ -51,19 +64,82 @@ func migrate(db *gorm.DB) error {
 		&models.InvoiceDetail{},
 		&models.BankEntry{},
 		&models.BankEntryInvoice{},
+		&models.BankImportProfile{},
+		&models.BankEntryInvoiceEvent{},
+		&models.PurchaseInvoiceHeader{},
+		&models.PurchaseInvoiceDetail{},
+		&models.PurchaseInvoiceTax{},
+		&models.BankEntryPurchaseInvoice{},
+		&models.CreditNote{},
+		&models.Account{},
+		&models.JournalEntry{},
+		&models.JournalLine{},
+		&models.BankEntryCategory{},
+		&models.TransactionSchema{},
+		&models.TransactionRow{},
+		&models.ExchangeRate{},
+		&models.BankAccount{},
+		&models.User{},
+		&models.APIKey{},
+		&models.AuditLog{},
+		&models.AccountingPeriod{},
 	)
 	if err != nil {
 		return err
 	}
 
+	// Imports dedupe on the bank account's external ids, not the bank's
+	if db.Migrator().HasIndex(&models.BankEntry{}, "idx_bank_entries_bankcode_external_id") {
+		if err := db.Migrator().DropIndex(&models.BankEntry{}, "idx_bank_entries_bankcode_external_id"); err != nil {
+			return err
+		}
+	}
+
+	// Control accounts used by automatic journal postings
+	accounts := services.DefaultAccounts()
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&accounts).Error; err != nil {
+		return err
+	}
+
+	// Category accounts are checked on create; older categories were not
+	if err := services.BackfillCategoryAccounts(db); err != nil {
+		return err
+	}
+
+	// Credit notes reduced receivables without a journal before
+	if err := services.BackfillCreditNoteJournals(db); err != nil {
+		return err
+	}
+
+	// Entries created before bank accounts existed only carried a bank code
+	if err := services.BackfillBankAccounts(db); err != nil {
+		return err
+	}
+
+	// Links created before multi-currency support were IDR on both sides
+	if err := db.Exec("UPDATE bank_entry_invoices SET bank_amount = matched_amount, base_amount = matched_amount, rate = 1 WHERE bank_amount = 0 AND matched_amount <> 0").Error; err != nil {
+		return err
+	}
+
+	// Company codes of bank entries and their postings follow the bank account
+	if err := db.Exec("UPDATE bank_entries be JOIN bank_accounts ba ON ba.id = be.bank_account_id SET be.company_code = ba.company_code WHERE be.company_code = '' AND ba.company_code <> ''").Error; err != nil {
+		return err
+	}
+	if err := db.Exec(`UPDATE journal_entries je JOIN bank_entries be ON be.id = je.source_id
+		SET je.company_code = be.company_code
+		WHERE je.company_code = '' AND je.source IN (?, ?)`, models.JournalSourceBankReceipt, models.JournalSourceBankPayment).Error; err != nil {
+		return err
+	}
+
 	// Create Views and complex Indexes
 	stmts := []string{
 		`CREATE OR REPLACE VIEW v_invoice_summary AS
-			SELECT id AS header_id, invoice_no, invoice_date, customer_id, customer_name, status, total_amount, total_tax, company_code
-			FROM invoice_headers
-			WHERE deleted_at IS NULL`,
+			SELECT ih.id AS header_id, ih.invoice_no, ih.invoice_date, ih.due_date, ih.customer_id, ih.customer_name, ih.status, ih.currency, ih.total_amount, ih.total_tax, ih.company_code,
+				COALESCE((SELECT SUM(cn.amount) FROM credit_notes cn WHERE cn.invoice_header_id = ih.id AND cn.deleted_at IS NULL), 0) AS credited_amount
+			FROM invoice_headers ih
+			WHERE ih.deleted_at IS NULL`,
 		`CREATE OR REPLACE VIEW v_transaction_category_summary AS
-			SELECT tc.transaction_id, t.import_source, t.validation_status, tc.category_id, c.type AS category_type, c.name AS category_name
+			SELECT tc.transaction_id, t.import_source, t.validation_status, t.company_code, tc.category_id, c.type AS category_type, c.name AS category_name
 			FROM transaction_categories tc
 			JOIN transactions t ON t.id = tc.transaction_id
 			JOIN categories c ON c.id = tc.category_id`,
@@ -79,6 +155,7 @@ func migrate(db *gorm.DB) error {
 		`CREATE INDEX idx_bank_entries_bankcode_branch_date ON bank_entries (bank_code, branch, transaction_date)`,
 		`CREATE INDEX idx_bank_entries_amount_type ON bank_entries (amount_type)`,
 		`CREATE INDEX idx_bank_entries_branch ON bank_entries (branch)`,
+		`CREATE INDEX idx_bank_entries_account_date ON bank_entries (bank_account_id, transaction_date)`,
 		`CREATE INDEX idx_invoice_headers_status_date ON invoice_headers (status, invoice_date)`,
 		`CREATE INDEX idx_invoice_headers_company_code_date ON invoice_headers (company_code, invoice_date)`,
 		`CREATE INDEX idx_invoice_headers_customer_id_date ON invoice_headers (customer_id, invoice_date)`,
@@ -102,12 +179,73 @@ func migrate(db *gorm.DB) error {
 	return nil
 }
 
+// ensureAdminUser makes sure an admin can sign in. Without any active admin
+// the user "admin" is created with password when it does not exist yet. An
+// existing "admin" that was disabled or deleted is only recovered when
+// recoverPassword (ADMIN_PASSWORD) is set: it is reactivated as admin with
+// that password, and the recovery is logged and audited.
+func ensureAdminUser(db *gorm.DB, password, recoverPassword string) error {
+	var n int64
+	if err := db.Model(&models.User{}).Where("role = ? AND is_active = ?", models.RoleAdmin, true).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	var u models.User
+	err := db.Unscoped().First(&u, "username = ?", "admin").Error
+	if err == nil {
+		if recoverPassword == "" {
+			log.Print("no active admin user: user admin is disabled or deleted, set ADMIN_PASSWORD to recover it")
+			return nil
+		}
+		hash, err := auth.HashPassword(recoverPassword)
+		if err != nil {
+			return err
+		}
+		return db.Transaction(func(tx *gorm.DB) error {
+			before := u
+			if err := tx.Unscoped().Model(&u).Updates(map[string]any{"role": models.RoleAdmin, "is_active": true, "deleted_at": nil, "password_hash": hash}).Error; err != nil {
+				return err
+			}
+			var after models.User
+			if err := tx.First(&after, "id = ?", u.ID).Error; err != nil {
+				return err
+			}
+			log.Printf("WARNING: no active admin user, recovered user admin (id %d) with ADMIN_PASSWORD", u.ID)
+			return services.RecordAudit(tx, "system", models.AuditEntityUser, strconv.FormatUint(uint64(u.ID), 10), "", models.AuditActionUpdate, before, struct {
+				models.User
+				PasswordChanged bool `json:"passwordChanged"`
+			}{after, true})
+		})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if password == "" {
+		log.Print("no admin user yet: set ADMIN_PASSWORD to create one")
+		return nil
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return db.Create(&models.User{Username: "admin", DisplayName: "Administrator", PasswordHash: hash, Role: models.RoleAdmin, IsActive: true}).Error
+}
+
 func seedDevData(db *gorm.DB) error {
 	var cnt int64
 	if err := db.Model(&models.Category{}).Count(&cnt).Error; err != nil {
 		return err
 	}
 	if cnt == 0 {
+		accounts := []models.Account{
+			{Code: "401", Name: "Penjualan", Type: models.AccountTypeRevenue, IsActive: true},
+			{Code: "501", Name: "Pembelian", Type: models.AccountTypeExpense, IsActive: true},
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&accounts).Error; err != nil {
+			return err
+		}
 		cats := []models.Category{
 			{ID: "CAT-IN-001", Type: "money_in", Name: "Penjualan", DefaultAccount: "401", BusinessRules: "{}", TaxRules: "{}", BudgetRef: "BUD-2025"},
 			{ID: "CAT-OUT-001", Type: "money_out", Name: "Pembelian", DefaultAccount: "501", BusinessRules: "{}", TaxRules: "{}", BudgetRef: "BUD-2025"},
@@ -266,6 +404,10 @@ func seedDevData(db *gorm.DB) error {
 		return err
 	}
 	if cnt == 0 {
+		acc := models.BankAccount{ID: "BRI-IDR-0001", BankCode: "BRI", AccountNumber: "0001-01-000001-30-1", AccountName: "PT Contoh Operasional", Branch: "0000", Currency: "IDR", CompanyCode: "COMP-01", IsActive: true}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&acc).Error; err != nil {
+			return err
+		}
 		be := []models.BankEntry{
 			{ID: "BE-001", TransactionDate: parseTime("2025-11-29 00:00:00"), Description: "BI-FAST CR TANGGAL :28/11 TRANSFER   DR 002  DAHNIAR   ", Branch: "0000", Amount: 34244370.00, AmountType: "CR", Balance: 342889691.38, BankCode: "BRI"},
 			{ID: "BE-002", TransactionDate: parseTime("2025-11-29 00:00:00"), Description: "BI-FAST CR TRANSFER   DR 002 HAJAR NURUL A'IN    ", Branch: "0000", Amount: 1978000.00, AmountType: "CR", Balance: 344867691.38, BankCode: "BRI"},
@@ -273,6 +415,11 @@ func seedDevData(db *gorm.DB) error {
 			{ID: "BE-004", TransactionDate: parseTime("2025-11-29 00:00:00"), Description: "TRSF E-BANKING CR 2911/FTSCY/WS95271 2961790.00  nota sinar anugrah 27 nov 2025  BUDI SANTOSO ", Branch: "0000", Amount: 2961790.00, AmountType: "CR", Balance: 348354631.38, BankCode: "BRI"},
 			{ID: "BE-005", TransactionDate: parseTime("2025-11-29 00:00:00"), Description: "SWITCHING CR TRF 3 SRI ASTUTI  002  Web BRILink  ", Branch: "0998", Amount: 191000.00, AmountType: "CR", Balance: 348545631.38, BankCode: "BRI"},
 		}
+		for i := range be {
+			if err := services.BookToAccount(&be[i], acc); err != nil {
+				return err
+			}
+		}
 		if err := db.Create(&be).Error; err != nil {
 			return err
 		}
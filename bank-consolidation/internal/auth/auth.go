@@ -0,0 +1,143 @@
+// Package auth authenticates API callers: UI users with signed JWT bearer
+// tokens and integration jobs with API keys.
+package auth
+
+import (
+	"bank-consolidation/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// APIKeyPrefix starts every API key so that the middleware can tell keys
+// from JWTs in the same Authorization header.
+const APIKeyPrefix = "bck_"
+
+const (
+	KindUser   = "user"
+	KindAPIKey = "api_key"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Kind string `json:"kind"`
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Companies are the company codes the principal may access; nil means
+	// all of them.
+	Companies []string `json:"companies"`
+}
+
+// Actor names the principal in history records and journals.
+func (p Principal) Actor() string {
+	if p.Kind == KindAPIKey {
+		return "apikey:" + p.Name
+	}
+	return p.Name
+}
+
+var roleRank = map[string]int{
+	models.RoleViewer:     1,
+	models.RoleStaff:      2,
+	models.RoleSupervisor: 3,
+	models.RoleAdmin:      4,
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// Has reports whether the principal holds role or a higher one.
+func (p Principal) Has(role string) bool {
+	return roleRank[p.Role] >= roleRank[role] && roleRank[p.Role] > 0
+}
+
+// ParseCompanies reads a stored company list: comma-separated codes, or "*"
+// for all companies (nil).
+func ParseCompanies(s string) []string {
+	list := []string{}
+	for _, code := range strings.Split(s, ",") {
+		code = strings.TrimSpace(code)
+		if code == "*" {
+			return nil
+		}
+		if code != "" {
+			list = append(list, code)
+		}
+	}
+	return list
+}
+
+// FormatCompanies is the stored form of a company list, "*" for nil.
+func FormatCompanies(list []string) string {
+	if list == nil {
+		return "*"
+	}
+	return strings.Join(list, ",")
+}
+
+// AllCompanies reports whether the principal may access every company.
+func (p Principal) AllCompanies() bool {
+	return p.Companies == nil
+}
+
+// CanAccessCompany reports whether the principal may access data of code.
+func (p Principal) CanAccessCompany(code string) bool {
+	if p.AllCompanies() {
+		return true
+	}
+	for _, c := range p.Companies {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromRequest returns the principal the middleware stored on r.
+func FromRequest(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(ctxKey{}).(Principal)
+	return p, ok
+}
+
+// HashPassword returns the bcrypt hash stored for a password.
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(b), err
+}
+
+// CheckPassword reports whether password matches hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewAPIKey returns a random key and the hash to store for it.
+func NewAPIKey() (key, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey is the SHA-256 hex digest under which a key is stored. Keys
+// are random, so an unsalted fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return hex.EncodeToString(sum[:])
+}
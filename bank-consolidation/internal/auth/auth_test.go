@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCompanies(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"*", nil},
+		{"A, *", nil},
+		{"", []string{}},
+		{" , ", []string{}},
+		{"A", []string{"A"}},
+		{" A ,B,,C ", []string{"A", "B", "C"}},
+	}
+	for _, tt := range tests {
+		if got := ParseCompanies(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCompanies(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCompanies(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{nil, "*"},
+		{[]string{}, ""},
+		{[]string{"A", "B"}, "A,B"},
+	}
+	for _, tt := range tests {
+		if got := FormatCompanies(tt.in); got != tt.want {
+			t.Errorf("FormatCompanies(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPrincipalCompanies(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		code   string
+		all    bool
+		want   bool
+	}{
+		{"all companies", "*", "X", true, true},
+		{"listed company", "A,B", "B", false, true},
+		{"unlisted company", "A,B", "C", false, false},
+		{"empty list grants nothing", "", "A", false, false},
+	}
+	for _, tt := range tests {
+		p := Principal{Companies: ParseCompanies(tt.stored)}
+		if p.AllCompanies() != tt.all || p.CanAccessCompany(tt.code) != tt.want {
+			t.Errorf("%s: AllCompanies = %v, CanAccessCompany(%q) = %v; want %v, %v", tt.name, p.AllCompanies(), tt.code, p.CanAccessCompany(tt.code), tt.all, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseToken(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+	claims := Claims{Subject: 7, Username: "admin", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	valid, err := SignToken(claims, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(c Claims) string {
+		tok, err := SignToken(c, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	parts := strings.Split(valid, ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	tests := []struct {
+		name    string
+		token   string
+		secret  []byte
+		now     time.Time
+		want    Claims
+		wantErr error
+	}{
+		{"valid", valid, secret, now, claims, nil},
+		{"expired", valid, secret, now.Add(time.Hour), Claims{}, ErrExpiredToken},
+		{"wrong secret", valid, []byte("other"), now, Claims{}, ErrInvalidToken},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":1,"exp":9999999999}`)) + "." + parts[2], secret, now, Claims{}, ErrInvalidToken},
+		{"other algorithm", noneHeader + "." + parts[1] + "." + parts[2], secret, now, Claims{}, ErrInvalidToken},
+		{"missing subject", sign(Claims{ExpiresAt: now.Add(time.Hour).Unix()}), secret, now, Claims{}, ErrInvalidToken},
+		{"two parts", parts[0] + "." + parts[1], secret, now, Claims{}, ErrInvalidToken},
+		{"bad base64 header", "!!." + parts[1] + "." + parts[2], secret, now, Claims{}, ErrInvalidToken},
+		{"empty", "", secret, now, Claims{}, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseToken(tt.token, tt.secret, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("claims = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
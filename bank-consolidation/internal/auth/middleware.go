@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Required rejects requests without a valid credential with 401 and stores
+// the authenticated principal in the request context otherwise. It accepts
+// "Authorization: Bearer <jwt|api key>" or an "X-API-Key" header. Session
+// tokens are only honoured while their user is still active.
+func Required(db *gorm.DB, secret []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cred := strings.TrimSpace(c.GetHeader("X-API-Key"))
+		if cred == "" {
+			h := c.GetHeader("Authorization")
+			if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+				cred = strings.TrimSpace(h[7:])
+			}
+		}
+		if cred == "" {
+			unauthorized(c, "authentication required")
+			return
+		}
+
+		var p Principal
+		var err error
+		if strings.HasPrefix(cred, APIKeyPrefix) {
+			p, err = apiKeyPrincipal(db, cred)
+		} else {
+			p, err = userPrincipal(db, cred, secret)
+		}
+		if err != nil {
+			if errors.Is(err, errUnauthorized) || errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrExpiredToken) {
+				unauthorized(c, err.Error())
+				return
+			}
+			log.Printf("auth: %v", err)
+			http.Error(c.Writer, "authentication failed", http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+		c.Set("principal", p)
+		c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), p))
+		c.Next()
+	}
+}
+
+var errUnauthorized = errors.New("invalid credentials")
+
+func unauthorized(c *gin.Context, msg string) {
+	c.Header("WWW-Authenticate", `Bearer realm="bank-consolidation"`)
+	http.Error(c.Writer, msg, http.StatusUnauthorized)
+	c.Abort()
+}
+
+func userPrincipal(db *gorm.DB, token string, secret []byte) (Principal, error) {
+	claims, err := ParseToken(token, secret, time.Now())
+	if err != nil {
+		return Principal{}, err
+	}
+	var u models.User
+	if err := db.First(&u, "id = ?", claims.Subject).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Principal{}, errUnauthorized
+		}
+		return Principal{}, err
+	}
+	if !u.IsActive {
+		return Principal{}, errUnauthorized
+	}
+	p := Principal{Kind: KindUser, ID: u.ID, Name: u.Username, Role: u.Role, Companies: ParseCompanies(u.Companies)}
+	if p.Role == models.RoleAdmin {
+		p.Companies = nil
+	}
+	return p, nil
+}
+
+func apiKeyPrincipal(db *gorm.DB, key string) (Principal, error) {
+	var k models.APIKey
+	if err := db.First(&k, "key_hash = ?", HashAPIKey(key)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Principal{}, errUnauthorized
+		}
+		return Principal{}, err
+	}
+	now := time.Now()
+	if k.RevokedAt != nil || (k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)) {
+		return Principal{}, errUnauthorized
+	}
+	// last use is informational; refresh it at most once a minute
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > time.Minute {
+		if err := db.Model(&models.APIKey{}).Where("id = ?", k.ID).Update("last_used_at", now).Error; err != nil {
+			log.Printf("auth: api key %d last use: %v", k.ID, err)
+		}
+	}
+	p := Principal{Kind: KindAPIKey, ID: k.ID, Name: k.Name, Role: k.Role, Companies: ParseCompanies(k.Companies)}
+	if p.Role == models.RoleAdmin {
+		p.Companies = nil
+	}
+	return p, nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Require lets a request through only when its principal holds role or a
+// higher one. It must run after Required.
+func Require(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Allowed(c.Request, role) {
+			Deny(c.Writer, c.Request, role)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Allowed reports whether the caller of r holds role or a higher one.
+func Allowed(r *http.Request, role string) bool {
+	p, ok := FromRequest(r)
+	return ok && p.Has(role)
+}
+
+// AllowedCompany reports whether the caller of r may access data of the
+// company code.
+func AllowedCompany(r *http.Request, code string) bool {
+	p, ok := FromRequest(r)
+	return ok && p.CanAccessCompany(code)
+}
+
+// Companies returns the company codes the caller of r may access, nil for
+// all of them.
+func Companies(r *http.Request) []string {
+	p, ok := FromRequest(r)
+	if !ok {
+		return []string{}
+	}
+	return p.Companies
+}
+
+// DenyCompany logs the refusal and answers 403 with a JSON body naming the
+// company the caller has no access to.
+func DenyCompany(w http.ResponseWriter, r *http.Request, code string) {
+	p, _ := FromRequest(r)
+	log.Printf("auth: denied %s %s to %s %q (no access to company %q)", r.Method, r.URL.Path, p.Kind, p.Name, code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":       "forbidden",
+		"message":     "no access to company " + code,
+		"companyCode": code,
+	})
+}
+
+// Deny logs the refusal and answers 403 with a JSON body naming the role
+// that would have been needed.
+func Deny(w http.ResponseWriter, r *http.Request, required string) {
+	p, _ := FromRequest(r)
+	log.Printf("auth: denied %s %s to %s %q (role %q, requires %q)", r.Method, r.URL.Path, p.Kind, p.Name, p.Role, required)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":        "forbidden",
+		"message":      "this action requires the " + required + " role",
+		"requiredRole": required,
+		"role":         p.Role,
+	})
+}
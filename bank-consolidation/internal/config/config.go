@@ -3,6 +3,7 @@ package config
 import (
     "fmt"
     "os"
+    "time"
 )
 
 type Config struct {
@@ -12,6 +13,12 @@ type Config struct {
     DBPort string
     DBName string
     Addr   string
+
+    // JWTSecret signs session tokens; TokenTTL is how long they stay valid.
+    JWTSecret string
+    TokenTTL  time.Duration
+    // SeedDev enables the sample data generators and seeds a dev user.
+    SeedDev bool
 }
 
 func getenv(k, def string) string {
@@ -22,6 +29,10 @@ func getenv(k, def string) string {
 }
 
 func New() Config {
+    ttl, err := time.ParseDuration(getenv("TOKEN_TTL", "12h"))
+    if err != nil || ttl <= 0 {
+        ttl = 12 * time.Hour
+    }
     return Config{
         DBUser: getenv("DB_USER", "root"),
         DBPass: getenv("DB_PASS", ""),
@@ -29,6 +40,10 @@ func New() Config {
         DBPort: getenv("DB_PORT", "3306"),
         DBName: getenv("DB_NAME", "bank_consolidation"),
         Addr:   getenv("ADDR", ":8080"),
+
+        JWTSecret: os.Getenv("JWT_SECRET"),
+        TokenTTL:  ttl,
+        SeedDev:   os.Getenv("SEED_DEV") == "1",
     }
 }
 
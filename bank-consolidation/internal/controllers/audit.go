@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditController struct{ DB *gorm.DB }
+
+// audit records a change made by the caller in the audit log.
+func audit(tx *gorm.DB, r *http.Request, entity, id, company, action string, before, after any) error {
+	return services.RecordAudit(tx, actorFrom(r), entity, id, company, action, before, after)
+}
+
+// auditSave records an upsert by the caller: a create when before is nil and
+// an update otherwise.
+func auditSave(tx *gorm.DB, r *http.Request, entity, id, company string, before, after any) error {
+	action := models.AuditActionUpdate
+	if before == nil {
+		action = models.AuditActionCreate
+	}
+	return audit(tx, r, entity, id, company, action, before, after)
+}
+
+// List returns audit records of the caller's companies, newest first.
+// Filters: entity and id, actor, action, and from/to (YYYY-MM-DD,
+// inclusive). Records of entities without a company are only visible to
+// callers with access to all companies.
+func (c AuditController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	db := c.DB.Model(&models.AuditLog{}).Scopes(companyScope(r, "company_code"))
+	if v := q.Get("entity"); v != "" {
+		db = db.Where("entity_type = ?", v)
+	}
+	if v := q.Get("id"); v != "" {
+		if q.Get("entity") == "" {
+			http.Error(w, "id requires entity", http.StatusBadRequest)
+			return
+		}
+		db = db.Where("entity_id = ?", v)
+	}
+	if v := q.Get("actor"); v != "" {
+		db = db.Where("actor = ?", v)
+	}
+	if v := q.Get("action"); v != "" {
+		db = db.Where("action = ?", v)
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "invalid from, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		db = db.Where("created_at >= ?", t)
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "invalid to, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		db = db.Where("created_at < ?", t.AddDate(0, 0, 1))
+	}
+
+	lim := 100
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
+			lim = n
+		}
+	}
+
+	var list []models.AuditLog
+	if err := db.Order("created_at DESC, id DESC").Limit(lim).Find(&list).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.AuditLog{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
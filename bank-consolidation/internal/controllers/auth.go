@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuthController struct {
+	DB       *gorm.DB
+	Secret   []byte
+	TokenTTL time.Duration
+}
+
+type loginPayload struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Login exchanges a username and password for a session token.
+func (c AuthController) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var p loginPayload
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.Username = strings.TrimSpace(p.Username)
+	if p.Username == "" || p.Password == "" {
+		http.Error(w, "username and password are required", http.StatusBadRequest)
+		return
+	}
+
+	var u models.User
+	err := c.DB.First(&u, "username = ?", p.Username).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the same answer for unknown users, wrong passwords and disabled users
+	if err != nil || !u.IsActive || !auth.CheckPassword(u.PasswordHash, p.Password) {
+		http.Error(w, "invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	exp := now.Add(c.TokenTTL)
+	token, err := auth.SignToken(auth.Claims{Subject: u.ID, Username: u.Username, IssuedAt: now.Unix(), ExpiresAt: exp.Unix()}, c.Secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := c.DB.Model(&u).Update("last_login_at", now).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"token":     token,
+		"tokenType": "Bearer",
+		"expiresAt": exp.UTC().Format(time.RFC3339),
+		"user":      u,
+	})
+}
+
+// Me returns the authenticated caller.
+func (c AuthController) Me(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := auth.FromRequest(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p)
+}
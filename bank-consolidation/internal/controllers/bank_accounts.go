@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BankAccountController struct{ DB *gorm.DB }
+
+// CreateOrList upserts (POST) or lists (GET) bank accounts of the caller's
+// companies. The bank, currency and company of an account are fixed once
+// entries are booked to it; only migrated accounts without a company may
+// still be given one, which their entries and postings then follow. GET
+// filters: bankCode, companyCode, currency and active.
+func (c BankAccountController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var body models.BankAccount
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.ID = strings.TrimSpace(body.ID)
+		body.BankCode = strings.TrimSpace(body.BankCode)
+		body.AccountNumber = strings.TrimSpace(body.AccountNumber)
+		body.CompanyCode = strings.TrimSpace(body.CompanyCode)
+		if body.ID == "" || body.BankCode == "" || body.AccountNumber == "" || body.CompanyCode == "" {
+			http.Error(w, "id, bankCode, accountNumber and companyCode are required", http.StatusBadRequest)
+			return
+		}
+		cur, err := services.NormalizeCurrency(body.Currency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.Currency = cur
+		body.GLAccount = strings.TrimSpace(body.GLAccount)
+		if body.GLAccount != "" {
+			if err := services.CheckAccount(c.DB, body.GLAccount); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !checkCompany(w, r, body.CompanyCode) {
+			return
+		}
+		var current models.BankAccount
+		err = c.DB.First(&current, "id = ?", body.ID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == nil && current.CompanyCode != "" && !checkCompany(w, r, current.CompanyCode) {
+			return
+		}
+		adopted := err == nil && current.CompanyCode == "" && body.CompanyCode != ""
+
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			if current.ID != "" && (current.BankCode != body.BankCode || current.Currency != body.Currency || (current.CompanyCode != body.CompanyCode && !adopted)) {
+				var n int64
+				if err := tx.Unscoped().Model(&models.BankEntry{}).Where("bank_account_id = ?", body.ID).Count(&n).Error; err != nil {
+					return err
+				}
+				if n > 0 {
+					return services.ValidationError{Msg: "bank account " + body.ID + " has entries, its bankCode, currency and companyCode cannot change"}
+				}
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"bank_code", "account_number", "account_name", "branch", "currency", "company_code", "gl_account", "opening_balance", "opening_date", "is_active", "updated_at"}),
+			}).Create(&body).Error; err != nil {
+				return err
+			}
+			var before any
+			if current.ID != "" {
+				before = current
+			}
+			var saved models.BankAccount
+			if err := tx.First(&saved, "id = ?", body.ID).Error; err != nil {
+				return err
+			}
+			if err := auditSave(tx, r, models.AuditEntityBankAccount, body.ID, body.CompanyCode, before, saved); err != nil {
+				return err
+			}
+			if !adopted {
+				return nil
+			}
+			if err := tx.Unscoped().Model(&models.BankEntry{}).Where("bank_account_id = ?", body.ID).Update("company_code", body.CompanyCode).Error; err != nil {
+				return err
+			}
+			return tx.Model(&models.JournalEntry{}).
+				Where("source IN ? AND source_id IN (?)", []string{models.JournalSourceBankReceipt, models.JournalSourceBankPayment},
+					tx.Unscoped().Model(&models.BankEntry{}).Select("id").Where("bank_account_id = ?", body.ID)).
+				Update("company_code", body.CompanyCode).Error
+		})
+		if err != nil {
+			var verr services.ValidationError
+			if errors.As(err, &verr) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(body)
+	case http.MethodGet:
+		q := r.URL.Query()
+		db := c.DB.Model(&models.BankAccount{}).Scopes(companyScope(r, "company_code"))
+		if v := q.Get("bankCode"); v != "" {
+			db = db.Where("bank_code = ?", v)
+		}
+		if v := q.Get("companyCode"); v != "" {
+			db = db.Where("company_code = ?", v)
+		}
+		if v := q.Get("currency"); v != "" {
+			db = db.Where("currency = ?", strings.ToUpper(v))
+		}
+		if v := q.Get("active"); v != "" {
+			db = db.Where("is_active = ?", v == "1" || strings.EqualFold(v, "true"))
+		}
+
+		var list []models.BankAccount
+		if err := db.Order("bank_code, account_number").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []models.BankAccount{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// GetByID returns an account with its entry count and book and statement
+// balances.
+func (c BankAccountController) GetByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/bank-accounts/")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var acc models.BankAccount
+	if err := c.DB.Scopes(companyScope(r, "company_code")).First(&acc, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum, err := services.SummarizeBankAccount(c.DB, acc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(sum)
+}
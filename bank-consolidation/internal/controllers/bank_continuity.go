@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Continuity walks a bank account's entries for a month in booking order and
+// reports balance breaks, out-of-order rows and duplicated balance steps.
+func (c BankEntryController) Continuity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	acc, ok := c.bankAccount(w, r, q.Get("bankAccountId"), q.Get("bankCode"))
+	if !ok {
+		return
+	}
+	month := q.Get("month")
+	mt, err := time.Parse("2006-01", month)
+	if err != nil {
+		http.Error(w, "invalid month, expected YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	start := mt
+	next := mt.AddDate(0, 1, 0)
+
+	var entries []models.BankEntry
+	if err := c.DB.Where("bank_account_id = ? AND transaction_date >= ? AND transaction_date < ?", acc.ID, start, next).
+		Order("transaction_date ASC, id ASC").
+		Find(&entries).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The last entry of the previous period anchors the first balance, or
+	// the account's opening balance when there is none
+	var anchor *models.BankEntry
+	var prev models.BankEntry
+	err = c.DB.Where("bank_account_id = ? AND transaction_date < ?", acc.ID, start).
+		Order("transaction_date DESC, id DESC").
+		First(&prev).Error
+	switch {
+	case err == nil:
+		anchor = &prev
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case acc.OpeningDate != nil && acc.OpeningDate.Before(next):
+		anchor = &models.BankEntry{ID: "opening-balance", TransactionDate: *acc.OpeningDate, Balance: acc.OpeningBalance}
+	}
+
+	rep := services.CheckContinuity(anchor, entries)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"bankAccountId":  acc.ID,
+		"bankCode":       acc.BankCode,
+		"month":          month,
+		"checked":        rep.Checked,
+		"ok":             rep.OK,
+		"openingBalance": rep.OpeningBalance,
+		"closingBalance": rep.ClosingBalance,
+		"issues":         rep.Issues,
+	})
+}
@@ -1,13 +1,16 @@
 package controllers
 
 import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
 	"bank-consolidation/models"
+	"bytes"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	mrand "math/rand"
 	"net/http"
 	"strconv"
@@ -20,17 +23,60 @@ import (
 
 type BankEntryController struct{ DB *gorm.DB }
 
+// Per-entry reconciliation stats joined onto bank_entries for list and detail
+// views, in the entry's own currency
+const (
+	bankEntryStatsSelect = "bank_entries.*, COALESCE(st.attached_count,0) AS attached_count, COALESCE(st.matched_total,0) AS matched_total, bank_entries.amount - COALESCE(st.matched_total,0) AS unallocated_amount"
+	bankEntryStatsJoin   = "LEFT JOIN (SELECT bank_entry_id, COUNT(1) AS attached_count, COALESCE(SUM(matched_amount),0) AS matched_total FROM (SELECT bank_entry_id, bank_amount AS matched_amount FROM bank_entry_invoices UNION ALL SELECT bank_entry_id, matched_amount FROM bank_entry_purchase_invoices) links GROUP BY bank_entry_id) st ON st.bank_entry_id = bank_entries.id"
+)
+
 func genID(prefix string) string {
 	var b [4]byte
 	_, _ = rand.Read(b[:])
 	return fmt.Sprintf("%s-%d-%x", prefix, time.Now().UnixNano(), b)
 }
 
-func computeFingerprint(dt time.Time, desc, branch string, amount float64, amtType, bankCode string) string {
-	dtStr := dt.Format("2006-01-02 15:04:05")
-	base := strings.ToLower(strings.TrimSpace(dtStr)) + "|" + strings.ToLower(strings.TrimSpace(desc)) + "|" + strings.TrimSpace(branch) + "|" + fmt.Sprintf("%.2f", amount) + "|" + strings.TrimSpace(amtType) + "|" + strings.TrimSpace(bankCode)
-	h := sha256.Sum256([]byte(base))
-	return hex.EncodeToString(h[:])
+// bankAccount resolves the account an entry is booked to and answers 400
+// when there is none and 403 when it belongs to a company the caller has no
+// access to.
+func (c BankEntryController) bankAccount(w http.ResponseWriter, r *http.Request, id, bankCode string) (models.BankAccount, bool) {
+	acc, err := services.ResolveBankAccount(c.DB, id, bankCode)
+	if err != nil {
+		var verr services.ValidationError
+		if errors.As(err, &verr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return acc, false
+	}
+	return acc, checkCompany(w, r, acc.CompanyCode)
+}
+
+// conflictError is a write refused because of the current state of the
+// data, answered with 409.
+type conflictError string
+
+func (e conflictError) Error() string { return string(e) }
+
+// writeEntryError maps errors from the transaction of a bank entry write
+// onto status codes; validationStatus is the one for a ValidationError.
+func writeEntryError(w http.ResponseWriter, err error, validationStatus int) {
+	var perr periodLockedError
+	var cerr conflictError
+	var verr services.ValidationError
+	switch {
+	case errors.As(err, &perr):
+		writePeriodLocked(w, perr.period, perr.msg)
+	case errors.As(err, &cerr):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.As(err, &verr):
+		http.Error(w, err.Error(), validationStatus)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func parseDate(s string) (time.Time, error) {
@@ -61,14 +107,18 @@ func (c BankEntryController) CreateOrList(w http.ResponseWriter, r *http.Request
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if body.Description == "" || body.Branch == "" || strings.TrimSpace(body.BankCode) == "" {
-			http.Error(w, "description, branch, bankCode are required", http.StatusBadRequest)
+		if body.Description == "" || body.Branch == "" {
+			http.Error(w, "description, branch are required", http.StatusBadRequest)
 			return
 		}
 		if body.AmountType != "CR" && body.AmountType != "DB" {
 			http.Error(w, "amountType must be CR or DB", http.StatusBadRequest)
 			return
 		}
+		acc, ok := c.bankAccount(w, r, body.BankAccountID, body.BankCode)
+		if !ok {
+			return
+		}
 		// body.TransactionDate is already time.Time due to custom UnmarshalJSON in model
 		// but wait, the model's UnmarshalJSON parses it.
 		// Let's assume the model is correct.
@@ -79,25 +129,62 @@ func (c BankEntryController) CreateOrList(w http.ResponseWriter, r *http.Request
 		if strings.TrimSpace(body.ID) == "" {
 			body.ID = genID("BE")
 		}
-		body.Fingerprint = computeFingerprint(body.TransactionDate, body.Description, body.Branch, body.Amount, body.AmountType, body.BankCode)
+		if err := services.BookToAccount(&body, acc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		override, ok := checkPeriods(w, r, c.DB, body.CompanyCode, body.TransactionDate)
+		if !ok {
+			return
+		}
 
-		if err := c.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&body).Error; err != nil {
+		rs, err := services.LoadRuleSet(c.DB)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hits := []services.RuleHit{}
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&body)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			if err := audit(tx, r, models.AuditEntityBankEntry, body.ID, body.CompanyCode, models.AuditActionCreate, nil, body); err != nil {
+				return err
+			}
+			if err := override.record(tx, r, models.AuditEntityBankEntry, models.AuditActionCreate, body.ID); err != nil {
+				return err
+			}
+			matched, err := services.CategorizeBankEntry(tx, rs, body)
+			if matched != nil {
+				hits = matched
+			}
+			return err
+		})
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": body.ID})
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "id": body.ID, "categories": hits})
 	case http.MethodGet:
 		q := r.URL.Query()
-		db := c.DB.Model(&models.BankEntry{})
-
-		if v := q.Get("bankCode"); strings.TrimSpace(v) == "" {
-			http.Error(w, "bankCode is required", http.StatusBadRequest)
+		db := c.DB.Model(&models.BankEntry{}).Scopes(companyScope(r, "bank_entries.company_code"))
+
+		// entries are listed per account, or for all accounts of a bank
+		switch acc, code := strings.TrimSpace(q.Get("bankAccountId")), strings.TrimSpace(q.Get("bankCode")); {
+		case acc != "":
+			db = db.Where("bank_account_id = ?", acc)
+			if code != "" {
+				db = db.Where("bank_code = ?", code)
+			}
+		case code != "":
+			db = db.Where("bank_code = ?", code)
+		default:
+			http.Error(w, "bankAccountId or bankCode is required", http.StatusBadRequest)
 			return
-		} else {
-			db = db.Where("bank_code = ?", v)
 		}
 		if v := q.Get("branch"); v != "" {
 			db = db.Where("branch = ?", v)
@@ -108,6 +195,10 @@ func (c BankEntryController) CreateOrList(w http.ResponseWriter, r *http.Request
 		if v := q.Get("desc"); v != "" {
 			db = db.Where("description LIKE ?", "%"+v+"%")
 		}
+		if v := strings.TrimSpace(q.Get("reference")); v != "" {
+			// exact match on structured remittance references (camt EndToEndId / CdtrRefInf)
+			db = db.Where("(end_to_end_id = ? OR creditor_reference = ? OR FIND_IN_SET(?, REPLACE(creditor_reference, ';', ',')) > 0)", v, v, v)
+		}
 		if v := q.Get("startDate"); v != "" {
 			if dt, err := parseDate(v); err == nil {
 				db = db.Where("transaction_date >= ?", dt)
@@ -141,8 +232,8 @@ func (c BankEntryController) CreateOrList(w http.ResponseWriter, r *http.Request
 			return
 		}
 
-		db = db.Select("bank_entries.*, COALESCE(st.attached_count,0) AS attached_count, COALESCE(st.matched_total,0) AS matched_total").
-			Joins("LEFT JOIN (SELECT bank_entry_id, COUNT(1) AS attached_count, COALESCE(SUM(matched_amount),0) AS matched_total FROM bank_entry_invoices GROUP BY bank_entry_id) st ON st.bank_entry_id = bank_entries.id").
+		db = db.Select(bankEntryStatsSelect).
+			Joins(bankEntryStatsJoin).
 			Order("transaction_date DESC")
 
 		lim := 50
@@ -186,6 +277,26 @@ func (c BankEntryController) CreateOrList(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// entryMatchStats are the reconciliation stats of one bank entry.
+type entryMatchStats struct {
+	AttachedCount int64
+	MatchedTotal  float64
+}
+
+// matchStats counts the invoices and purchase invoices linked to a bank
+// entry and sums what is matched to them, in the entry's currency. Links
+// are only written with the entry locked FOR UPDATE, so inside a
+// transaction holding that lock the stats stay true until it commits.
+func matchStats(tx *gorm.DB, id string) (entryMatchStats, error) {
+	var stats entryMatchStats
+	err := tx.Model(&models.BankEntry{}).
+		Select("COALESCE(st.attached_count,0) AS attached_count, COALESCE(st.matched_total,0) AS matched_total").
+		Joins(bankEntryStatsJoin).
+		Where("bank_entries.id = ?", id).
+		Scan(&stats).Error
+	return stats, err
+}
+
 func (c BankEntryController) GetByID(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -198,9 +309,10 @@ func (c BankEntryController) GetByID(w http.ResponseWriter, r *http.Request) {
 	}
 	var m models.BankEntry
 	err := c.DB.Model(&models.BankEntry{}).
-		Select("bank_entries.*, COALESCE(st.attached_count,0) AS attached_count, COALESCE(st.matched_total,0) AS matched_total").
-		Joins("LEFT JOIN (SELECT bank_entry_id, COUNT(1) AS attached_count, COALESCE(SUM(matched_amount),0) AS matched_total FROM bank_entry_invoices GROUP BY bank_entry_id) st ON st.bank_entry_id = bank_entries.id").
+		Select(bankEntryStatsSelect).
+		Joins(bankEntryStatsJoin).
 		Where("bank_entries.id = ?", id).
+		Scopes(companyScope(r, "bank_entries.company_code")).
 		First(&m).Error
 
 	if err != nil {
@@ -225,39 +337,118 @@ func (c BankEntryController) Update(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	var body models.BankEntry
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(bytes.NewReader(b))
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(&body); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// the remittance fields usually come from an import, an update that
+	// leaves them out keeps them
+	var remittance struct {
+		EndToEndID          *string `json:"endToEndId"`
+		CreditorReference   *string `json:"creditorReference"`
+		CounterpartyName    *string `json:"counterpartyName"`
+		CounterpartyAccount *string `json:"counterpartyAccount"`
+		RemittanceInfo      *string `json:"remittanceInfo"`
+	}
+	if err := json.Unmarshal(b, &remittance); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if body.AmountType != "CR" && body.AmountType != "DB" {
 		http.Error(w, "amountType must be CR or DB", http.StatusBadRequest)
 		return
 	}
-	if strings.TrimSpace(body.BankCode) == "" {
-		http.Error(w, "bankCode is required", http.StatusBadRequest)
+	acc, ok := c.bankAccount(w, r, body.BankAccountID, body.BankCode)
+	if !ok {
+		return
+	}
+	// the account decides bank code, currency, company and fingerprint
+	if err := services.BookToAccount(&body, acc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	fp := computeFingerprint(body.TransactionDate, body.Description, body.Branch, body.Amount, body.AmountType, body.BankCode)
-	body.Fingerprint = fp
-
 	// We only update specific fields
-	err := c.DB.Model(&models.BankEntry{}).Where("id = ?", id).Updates(map[string]interface{}{
+	fields := map[string]interface{}{
 		"transaction_date": body.TransactionDate,
 		"description":      body.Description,
 		"branch":           body.Branch,
 		"amount":           body.Amount,
 		"amount_type":      body.AmountType,
 		"balance":          body.Balance,
+		"bank_account_id":  body.BankAccountID,
 		"bank_code":        body.BankCode,
-		"fingerprint":      fp,
-	}).Error
+		"currency":         body.Currency,
+		"company_code":     body.CompanyCode,
+		"fingerprint":      body.Fingerprint,
+	}
+	for col, v := range map[string]*string{
+		"end_to_end_id":        remittance.EndToEndID,
+		"creditor_reference":   remittance.CreditorReference,
+		"counterparty_name":    remittance.CounterpartyName,
+		"counterparty_account": remittance.CounterpartyAccount,
+		"remittance_info":      remittance.RemittanceInfo,
+	} {
+		if v != nil {
+			fields[col] = strings.TrimSpace(*v)
+		}
+	}
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.BankEntry
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(companyScope(r, "company_code")).First(&current, "id = ?", id).Error; err != nil {
+			return err
+		}
+		// both the period the entry leaves and the one it moves to must be open
+		override, err := lockPeriods(tx, r, current.CompanyCode, current.TransactionDate)
+		if err != nil {
+			return err
+		}
+		moved, err := lockPeriods(tx, r, body.CompanyCode, body.TransactionDate)
+		if err != nil {
+			return err
+		}
+		override = override.and(moved)
+		// the invoices matched to the entry must still fit it afterwards
+		stats, err := matchStats(tx, id)
+		if err != nil {
+			return err
+		}
+		if stats.AttachedCount > 0 {
+			if body.Currency != current.Currency || body.CompanyCode != current.CompanyCode {
+				return conflictError("bank entry has reconciled invoices, detach them before changing its currency or company")
+			}
+			if body.AmountType != current.AmountType {
+				return conflictError("bank entry has reconciled invoices, detach them before changing its amount type")
+			}
+			if body.Amount < stats.MatchedTotal-0.005 {
+				return conflictError(fmt.Sprintf("amount %.2f is below the %.2f already matched to invoices, detach them first", body.Amount, stats.MatchedTotal))
+			}
+		}
 
+		if err := tx.Model(&models.BankEntry{}).Where("id = ?", id).Updates(fields).Error; err != nil {
+			return err
+		}
+		if err := services.PostBankEntry(tx, id, actorFrom(r)); err != nil {
+			return err
+		}
+		var updated models.BankEntry
+		if err := tx.First(&updated, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := audit(tx, r, models.AuditEntityBankEntry, id, updated.CompanyCode, models.AuditActionUpdate, current, updated); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityBankEntry, models.AuditActionUpdate, id)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeEntryError(w, err, http.StatusBadRequest)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -274,8 +465,33 @@ func (c BankEntryController) Delete(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if err := c.DB.Delete(&models.BankEntry{}, "id = ?", id).Error; err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.BankEntry
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(companyScope(r, "company_code")).First(&current, "id = ?", id).Error; err != nil {
+			return err
+		}
+		// links would keep invoices paid and the journal posted
+		stats, err := matchStats(tx, id)
+		if err != nil {
+			return err
+		}
+		if stats.AttachedCount > 0 {
+			return conflictError("bank entry has reconciled invoices, detach them before deleting it")
+		}
+		override, err := lockPeriods(tx, r, current.CompanyCode, current.TransactionDate)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.BankEntry{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := audit(tx, r, models.AuditEntityBankEntry, id, current.CompanyCode, models.AuditActionDelete, current, nil); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityBankEntry, models.AuditActionDelete, id)
+	})
+	if err != nil {
+		writeEntryError(w, err, http.StatusBadRequest)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -302,9 +518,13 @@ func (c BankEntryController) BulkCreate(w http.ResponseWriter, r *http.Request)
 	// Filter valid entries and prepare them
 	var validList []models.BankEntry
 	skipped := 0
+	accounts := map[string]models.BankAccount{}
+	gate := newPeriodGate(c.DB, r)
+	overridden := map[string][]string{}
+	var overrides []models.AccountingPeriod
 
 	for _, body := range list {
-		if strings.TrimSpace(body.Description) == "" || strings.TrimSpace(body.Branch) == "" || strings.TrimSpace(body.BankCode) == "" {
+		if strings.TrimSpace(body.Description) == "" || strings.TrimSpace(body.Branch) == "" {
 			skipped++
 			continue
 		}
@@ -316,10 +536,44 @@ func (c BankEntryController) BulkCreate(w http.ResponseWriter, r *http.Request)
 			skipped++
 			continue
 		}
+		// entries for unknown accounts or accounts of companies the caller
+		// has no access to are skipped like any other invalid row
+		key := body.BankAccountID + "|" + body.BankCode
+		acc, known := accounts[key]
+		if !known {
+			var err error
+			acc, err = services.ResolveBankAccount(c.DB, body.BankAccountID, body.BankCode)
+			var verr services.ValidationError
+			if err != nil && !errors.As(err, &verr) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			accounts[key] = acc
+		}
+		if acc.ID == "" || !auth.AllowedCompany(r, acc.CompanyCode) || services.BookToAccount(&body, acc) != nil {
+			skipped++
+			continue
+		}
 		if strings.TrimSpace(body.ID) == "" {
 			body.ID = genID("BE")
 		}
-		body.Fingerprint = computeFingerprint(body.TransactionDate, body.Description, body.Branch, body.Amount, body.AmountType, body.BankCode)
+		// as are entries in periods the caller may not write into
+		p, blocked, err := gate.check(body.CompanyCode, body.TransactionDate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if blocked != "" {
+			skipped++
+			continue
+		}
+		if p != nil {
+			key := services.PeriodKey(p.CompanyCode, p.Period)
+			if overridden[key] == nil {
+				overrides = append(overrides, *p)
+			}
+			overridden[key] = append(overridden[key], body.ID)
+		}
 		validList = append(validList, body)
 	}
 
@@ -329,24 +583,109 @@ func (c BankEntryController) BulkCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := c.DB.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(validList, 200).Error; err != nil {
+	var created []models.BankEntry
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if created, err = createEntries(tx, r, validList); err != nil {
+			return err
+		}
+		_, reason := overrideRequested(r)
+		for _, p := range overrides {
+			if err := recordOverride(tx, r, p, reason, models.AuditEntityBankEntry, models.AuditActionCreate, overridden[services.PeriodKey(p.CompanyCode, p.Period)]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Since CreateInBatches doesn't return exact number of inserted rows if some were ignored,
-	// we just report the number of valid items we attempted to insert.
-	// Or we can check RowsAffected if it's available.
-	// But for simplicity, let's assume validList count.
+	// Only new rows are categorized; rows that already existed keep their
+	// assignments, manual ones included
+	categorized, err := c.categorizeEntries(created)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]int{"inserted": len(validList), "skipped": skipped, "total": len(list)})
+	_ = json.NewEncoder(w).Encode(map[string]int{"inserted": len(created), "skipped": skipped, "total": len(list), "categorized": categorized})
+}
+
+// createEntries inserts entries in batches, skipping those already stored
+// under their id or fingerprint, records the new ones in the audit log and
+// returns them.
+func createEntries(tx *gorm.DB, r *http.Request, list []models.BankEntry) ([]models.BankEntry, error) {
+	ids := make([]string, 0, len(list))
+	fps := make([]string, 0, len(list))
+	for _, e := range list {
+		ids = append(ids, e.ID)
+		fps = append(fps, e.Fingerprint)
+	}
+	var stored []models.BankEntry
+	if err := tx.Unscoped().Select("id", "fingerprint").Where("id IN ? OR fingerprint IN ?", ids, fps).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, e := range stored {
+		seen["id:"+e.ID], seen["fp:"+e.Fingerprint] = true, true
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(list, 200).Error; err != nil {
+		return nil, err
+	}
+	var created []models.BankEntry
+	var logs []models.AuditLog
+	for _, e := range list {
+		if seen["id:"+e.ID] || seen["fp:"+e.Fingerprint] {
+			continue
+		}
+		seen["id:"+e.ID], seen["fp:"+e.Fingerprint] = true, true
+		a, err := services.NewAudit(actorFrom(r), models.AuditEntityBankEntry, e.ID, e.CompanyCode, models.AuditActionCreate, nil, e)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, e)
+		logs = append(logs, a)
+	}
+	if len(logs) == 0 {
+		return created, nil
+	}
+	return created, tx.CreateInBatches(logs, 200).Error
+}
+
+// categorizeEntries runs the category rules on newly created entries.
+func (c BankEntryController) categorizeEntries(list []models.BankEntry) (int, error) {
+	if len(list) == 0 {
+		return 0, nil
+	}
+	rs, err := services.LoadRuleSet(c.DB)
+	if err != nil || len(rs) == 0 {
+		return 0, err
+	}
+	categorized := 0
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		for _, e := range list {
+			hits, err := services.CategorizeBankEntry(tx, rs, e)
+			if err != nil {
+				return err
+			}
+			if len(hits) > 0 {
+				categorized++
+			}
+		}
+		return nil
+	})
+	return categorized, err
 }
 
 type reconcilePayload struct {
 	Invoices []struct {
-		ID     string  `json:"id"`
-		Amount float64 `json:"amount"`
+		ID         string  `json:"id"`
+		Amount     float64 `json:"amount"`
+		BankAmount float64 `json:"bankAmount"`
+		Rate       float64 `json:"rate"`
 	} `json:"invoices"`
 	Note string `json:"note"`
 	Mode string `json:"mode"`
@@ -372,79 +711,134 @@ func (c BankEntryController) Reconcile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var exists int64
-	if err := c.DB.Model(&models.BankEntry{}).Where("id = ?", id).Count(&exists).Error; err != nil || exists == 0 {
+	if ok, err := visible(c.DB, r, &models.BankEntry{}, "id = ?", id); err != nil || !ok {
 		http.Error(w, "bank entry not found", http.StatusNotFound)
 		return
 	}
 
+	lines := make([]services.ReconcileLine, 0, len(p.Invoices))
+	for _, inv := range p.Invoices {
+		lines = append(lines, services.ReconcileLine{InvoiceID: inv.ID, Amount: inv.Amount, BankAmount: inv.BankAmount, Rate: inv.Rate})
+	}
+	replace := strings.EqualFold(p.Mode, "replace") || p.Mode == ""
+	// replacing existing links un-reconciles them
+	if replace && !auth.Allowed(r, models.RoleSupervisor) {
+		var linked int64
+		if err := c.DB.Model(&models.BankEntryInvoice{}).Where("bank_entry_id = ?", id).Count(&linked).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if linked > 0 {
+			auth.Deny(w, r, models.RoleSupervisor)
+			return
+		}
+	}
+
+	invoiceIDs := make([]string, 0, len(lines))
+	for _, l := range lines {
+		invoiceIDs = append(invoiceIDs, l.InvoiceID)
+	}
+
+	var created []models.BankEntryInvoice
 	err := c.DB.Transaction(func(tx *gorm.DB) error {
-		// Validation
-		for _, inv := range p.Invoices {
-			if strings.TrimSpace(inv.ID) == "" {
-				continue
-			}
-			var result struct {
-				TotalAmount     float64
-				ExistingMatched float64
-			}
+		override, err := lockLinkPeriods(tx, r, id, invoiceIDs, replace)
+		if err != nil {
+			return err
+		}
+		if created, err = services.ReconcileEntry(tx, id, lines, p.Note, replace, actorFrom(r)); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityBankEntry, models.AuditActionReconcile, id)
+	})
 
-			// This query is tricky with GORM because of the subquery.
-			// Let's use Raw SQL for this specific check, but within the transaction.
-			err := tx.Raw(`
-				SELECT 
-					ih.total_amount,
-					COALESCE((SELECT SUM(matched_amount) FROM bank_entry_invoices WHERE invoice_header_id = ih.id AND bank_entry_id != ?), 0) as existing_matched
-				FROM invoice_headers ih
-				WHERE ih.id = ?`, id, inv.ID).Scan(&result).Error
+	if err != nil {
+		writeEntryError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
 
-			if err != nil {
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					return fmt.Errorf("invoice %s not found", inv.ID)
-				}
-				return err
-			}
+	unallocated, err := c.unallocatedAmount(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-			if result.ExistingMatched+inv.Amount > result.TotalAmount+0.01 {
-				return fmt.Errorf("invoice %s is already fully paid or amount exceeds total (Total: %.2f, Paid: %.2f, New: %.2f)", inv.ID, result.TotalAmount, result.ExistingMatched, inv.Amount)
-			}
-		}
+	fx := 0.0
+	for _, l := range created {
+		fx += l.FxGainLoss
+	}
 
-		if strings.EqualFold(p.Mode, "replace") || p.Mode == "" {
-			if err := tx.Delete(&models.BankEntryInvoice{}, "bank_entry_id = ?", id).Error; err != nil {
-				return err
-			}
-		}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"inserted": len(created), "unallocatedAmount": unallocated, "links": created, "fxGainLoss": math.Round(fx*100) / 100})
+}
 
-		var newEntries []models.BankEntryInvoice
-		for _, inv := range p.Invoices {
-			if strings.TrimSpace(inv.ID) == "" {
-				continue
-			}
-			newEntries = append(newEntries, models.BankEntryInvoice{
-				BankEntryID:     id,
-				InvoiceHeaderID: inv.ID,
-				MatchedAmount:   inv.Amount,
-				Note:            p.Note,
-			})
+// lockLinkPeriods locks a bank entry FOR UPDATE and checks, in the
+// transaction that changes its links, that the caller may: the periods of
+// the entry and of the invoices attached or detached (all linked ones when
+// replacing) must allow the write.
+func lockLinkPeriods(tx *gorm.DB, r *http.Request, id string, invoiceIDs []string, replace bool) (periodOverride, error) {
+	var e models.BankEntry
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "company_code", "transaction_date").First(&e, "id = ?", id).Error; err != nil {
+		return periodOverride{}, err
+	}
+	dates := []time.Time{e.TransactionDate}
+	if len(invoiceIDs) > 0 || replace {
+		q := tx.Model(&models.InvoiceHeader{}).Where("id IN ?", append([]string{""}, invoiceIDs...))
+		if replace {
+			q = q.Or("id IN (?)", tx.Model(&models.BankEntryInvoice{}).Select("invoice_header_id").Where("bank_entry_id = ?", id))
 		}
-
-		if len(newEntries) > 0 {
-			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&newEntries).Error; err != nil {
-				return err
-			}
+		var invoiceDates []time.Time
+		if err := q.Pluck("invoice_date", &invoiceDates).Error; err != nil {
+			return periodOverride{}, err
 		}
+		dates = append(dates, invoiceDates...)
+	}
+	return lockPeriods(tx, r, e.CompanyCode, dates...)
+}
 
-		return nil
-	})
+// unallocatedAmount is the part of a bank entry not yet matched to any invoice.
+func (c BankEntryController) unallocatedAmount(id string) (float64, error) {
+	var e models.BankEntry
+	err := c.DB.Model(&models.BankEntry{}).
+		Select(bankEntryStatsSelect).
+		Joins(bankEntryStatsJoin).
+		Where("bank_entries.id = ?", id).
+		First(&e).Error
+	return e.UnallocatedAmount, err
+}
 
+// DetachInvoice removes one invoice link from a bank entry, recording who did it.
+func (c BankEntryController) DetachInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/bank-entries/")
+	id, invoiceID, ok := strings.Cut(rest, "/invoices/")
+	if !ok || id == "" || invoiceID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !checkVisible(w, r, c.DB, &models.BankEntry{}, "id = ?", id) {
+		return
+	}
+
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		override, err := lockLinkPeriods(tx, r, id, []string{invoiceID}, false)
+		if err != nil {
+			return err
+		}
+		if err := services.DetachInvoice(tx, id, invoiceID, r.URL.Query().Get("note"), actorFrom(r)); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityBankEntry, models.AuditActionUnreconcile, id)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeEntryError(w, err, http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]int{"inserted": len(p.Invoices)}) // Approximate
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": id, "invoiceId": invoiceID})
 }
 
 func (c BankEntryController) ListAttachedInvoices(w http.ResponseWriter, r *http.Request) {
@@ -458,23 +852,34 @@ func (c BankEntryController) ListAttachedInvoices(w http.ResponseWriter, r *http
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if !checkVisible(w, r, c.DB, &models.BankEntry{}, "id = ?", id) {
+		return
+	}
 
 	var list []map[string]any
 	// Using Raw SQL for join is often cleaner for complex projections not mapping directly to a single model
 	// But we can try to map to a struct
 	type Result struct {
-		ID            string
-		InvoiceNo     string
-		InvoiceDate   time.Time
-		CustomerName  string
-		Status        string
-		TotalAmount   float64
-		MatchedAmount float64
+		ID             string
+		InvoiceNo      string
+		InvoiceDate    time.Time
+		CustomerName   string
+		Status         string
+		Currency       string
+		TotalAmount    float64
+		MatchedAmount  float64
+		BankAmount     float64
+		Rate           float64
+		BaseAmount     float64
+		FxGainLoss     float64
+		PaidAmount     float64
+		CreditedAmount float64
 	}
 	var results []Result
 
+	// paid_amount spans every bank entry settling the invoice, not just this one
 	err := c.DB.Table("bank_entry_invoices bei").
-		Select("ih.id, ih.invoice_no, ih.invoice_date, ih.customer_name, ih.status, ih.total_amount, bei.matched_amount").
+		Select("ih.id, ih.invoice_no, ih.invoice_date, ih.customer_name, ih.status, ih.currency, ih.total_amount, bei.matched_amount, bei.bank_amount, bei.rate, bei.base_amount, bei.fx_gain_loss, (SELECT COALESCE(SUM(x.matched_amount), 0) FROM bank_entry_invoices x WHERE x.invoice_header_id = ih.id) AS paid_amount, (SELECT COALESCE(SUM(cn.amount), 0) FROM credit_notes cn WHERE cn.invoice_header_id = ih.id AND cn.deleted_at IS NULL) AS credited_amount").
 		Joins("JOIN invoice_headers ih ON ih.id = bei.invoice_header_id").
 		Where("bei.bank_entry_id = ?", id).
 		Scan(&results).Error
@@ -486,13 +891,21 @@ func (c BankEntryController) ListAttachedInvoices(w http.ResponseWriter, r *http
 
 	for _, m := range results {
 		list = append(list, map[string]any{
-			"id":            m.ID,
-			"invoiceNo":     m.InvoiceNo,
-			"invoiceDate":   m.InvoiceDate,
-			"customerName":  m.CustomerName,
-			"status":        m.Status,
-			"totalAmount":   m.TotalAmount,
-			"matchedAmount": m.MatchedAmount,
+			"id":             m.ID,
+			"invoiceNo":      m.InvoiceNo,
+			"invoiceDate":    m.InvoiceDate,
+			"customerName":   m.CustomerName,
+			"status":         m.Status,
+			"currency":       m.Currency,
+			"totalAmount":    m.TotalAmount,
+			"matchedAmount":  m.MatchedAmount,
+			"bankAmount":     m.BankAmount,
+			"rate":           m.Rate,
+			"baseAmount":     m.BaseAmount,
+			"fxGainLoss":     m.FxGainLoss,
+			"paidAmount":     m.PaidAmount,
+			"creditedAmount": m.CreditedAmount,
+			"outstanding":    m.TotalAmount - m.PaidAmount - m.CreditedAmount,
 		})
 	}
 
@@ -509,9 +922,19 @@ func (c BankEntryController) GenerateSample(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	bankCode := r.URL.Query().Get("bankCode")
-	if bankCode == "" {
-		bankCode = "SAMPLE-BANK"
+	q := r.URL.Query()
+	var acc models.BankAccount
+	if q.Get("bankAccountId") != "" || q.Get("bankCode") != "" {
+		var ok bool
+		if acc, ok = c.bankAccount(w, r, q.Get("bankAccountId"), q.Get("bankCode")); !ok {
+			return
+		}
+	} else {
+		acc = models.BankAccount{ID: "SAMPLE-BANK-IDR", BankCode: "SAMPLE-BANK", AccountNumber: "SAMPLE", AccountName: "Sample account", Currency: "IDR", CompanyCode: "CMP-001", IsActive: true}
+		if err := c.DB.Where(models.BankAccount{ID: acc.ID}).FirstOrCreate(&acc).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	var samples []models.BankEntry
@@ -525,9 +948,8 @@ func (c BankEntryController) GenerateSample(w http.ResponseWriter, r *http.Reque
 			amountType = "DB"
 		}
 		balance := float64(mrand.Intn(1000000)) / 100.0
-		fp := computeFingerprint(dt, desc, branch, amount, amountType, bankCode)
 
-		samples = append(samples, models.BankEntry{
+		e := models.BankEntry{
 			ID:              genID("BE"),
 			TransactionDate: dt,
 			Description:     desc,
@@ -535,12 +957,15 @@ func (c BankEntryController) GenerateSample(w http.ResponseWriter, r *http.Reque
 			Amount:          amount,
 			AmountType:      amountType,
 			Balance:         balance,
-			BankCode:        bankCode,
-			Fingerprint:     fp,
-		})
+		}
+		_ = services.BookToAccount(&e, acc)
+		samples = append(samples, e)
 	}
 
-	if err := c.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&samples).Error; err != nil {
+	if err := c.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := createEntries(tx, r, samples)
+		return err
+	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,373 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/services"
+	"bank-consolidation/internal/statements"
+	"bank-consolidation/models"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BankImportProfileController struct{ DB *gorm.DB }
+
+func (c BankImportProfileController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var body models.BankImportProfile
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.BankCode = strings.TrimSpace(body.BankCode)
+		if body.BankCode == "" || body.DateColumn == "" || body.DescriptionColumn == "" {
+			http.Error(w, "bankCode, dateColumn, descriptionColumn are required", http.StatusBadRequest)
+			return
+		}
+		if body.AmountColumn == "" && (body.CreditColumn == "" || body.DebitColumn == "") {
+			http.Error(w, "amountColumn or both creditColumn and debitColumn are required", http.StatusBadRequest)
+			return
+		}
+		if body.AmountTypeColumn != "" && body.AmountColumn == "" {
+			http.Error(w, "amountTypeColumn requires amountColumn", http.StatusBadRequest)
+			return
+		}
+		if body.DecimalSeparator == "" {
+			body.DecimalSeparator = "."
+		}
+		if body.DecimalSeparator == body.ThousandSeparator {
+			http.Error(w, "decimalSeparator and thousandSeparator must differ", http.StatusBadRequest)
+			return
+		}
+
+		// Upsert so a bank's mapping can be corrected by posting it again
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			var before any
+			var current models.BankImportProfile
+			err := tx.First(&current, "bank_code = ?", body.BankCode).Error
+			switch {
+			case err == nil:
+				before = current
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				return err
+			}
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&body).Error; err != nil {
+				return err
+			}
+			return auditSave(tx, r, models.AuditEntityBankImportProfile, body.BankCode, "", before, body)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "bankCode": body.BankCode})
+	case http.MethodGet:
+		var list []models.BankImportProfile
+		if err := c.DB.Order("bank_code").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type importRowResult struct {
+	Line       int                `json:"line"`
+	Status     string             `json:"status"`
+	ID         string             `json:"id,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Categories []services.RuleHit `json:"categories,omitempty"`
+}
+
+type importReport struct {
+	BankAccountID string            `json:"bankAccountId"`
+	BankCode      string            `json:"bankCode"`
+	Format        string            `json:"format"`
+	Total         int               `json:"total"`
+	Inserted      int               `json:"inserted"`
+	Duplicates    int               `json:"duplicates"`
+	Rejected      int               `json:"rejected"`
+	Categorized   int               `json:"categorized"`
+	Warnings      []string          `json:"warnings,omitempty"`
+	Rows          []importRowResult `json:"rows"`
+}
+
+// Import accepts a multipart upload (field "file") of a raw bank statement and
+// inserts every valid line as a BankEntry, deduplicated by fingerprint.
+func (c BankEntryController) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// every import targets a known account; bankCode alone is enough while
+	// the bank has a single account
+	acc, ok := c.bankAccount(w, r, r.FormValue("bankAccountId"), r.FormValue("bankCode"))
+	if !ok {
+		return
+	}
+	bankCode := acc.BankCode
+	format := strings.ToLower(strings.TrimSpace(r.FormValue("format")))
+	if format == "" {
+		format = "csv"
+	}
+
+	var res statements.Result
+	switch format {
+	case "csv", "tsv":
+		var profile models.BankImportProfile
+		if err := c.DB.First(&profile, "bank_code = ?", bankCode).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				http.Error(w, "no import profile for bankCode "+bankCode, http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res, err = statements.ParseCSV(file, profile)
+	case "mt940", "sta":
+		res, err = statements.ParseMT940(file)
+	case "camt", "camt053", "camt054", "xml":
+		res, err = statements.ParseCamt(file)
+	case "ofx", "qfx":
+		raw, rerr := io.ReadAll(file)
+		if rerr != nil {
+			http.Error(w, rerr.Error(), http.StatusBadRequest)
+			return
+		}
+		var opening *float64
+		if v := strings.TrimSpace(r.FormValue("openingBalance")); v != "" {
+			f, perr := strconv.ParseFloat(v, 64)
+			if perr != nil {
+				http.Error(w, "invalid openingBalance", http.StatusBadRequest)
+				return
+			}
+			opening = &f
+		}
+		if res, err = statements.ParseOFX(bytes.NewReader(raw), opening); err != nil || opening != nil {
+			break
+		}
+		// without openingBalance the balances run from the account's balance
+		// before the statement, so that LEDGERBAL is still checked
+		f, ferr := c.balanceBefore(acc, res)
+		if ferr != nil {
+			http.Error(w, ferr.Error(), http.StatusInternalServerError)
+			return
+		}
+		res, err = statements.ParseOFX(bytes.NewReader(raw), &f)
+	default:
+		http.Error(w, "unsupported format "+format, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if res.Account != "" && !statements.AccountMatches(res.Account, acc.AccountNumber) {
+		http.Error(w, fmt.Sprintf("statement is for account %s, not %s %s", res.Account, acc.BankCode, acc.AccountNumber), http.StatusBadRequest)
+		return
+	}
+
+	// Statement formats without a branch column take it from the form or
+	// the account
+	branch := strings.TrimSpace(r.FormValue("branch"))
+	if branch == "" {
+		branch = acc.Branch
+	}
+	if branch == "" {
+		branch = "0000"
+	}
+	for i := range res.Rows {
+		if strings.TrimSpace(res.Rows[i].Entry.Branch) == "" {
+			res.Rows[i].Entry.Branch = branch
+		}
+	}
+
+	rs, err := services.LoadRuleSet(c.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := c.insertImportedRows(r, acc, res, rs)
+	report.Format = format
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// balanceBefore is the balance of acc just before the earliest parsed row:
+// that of the last entry booked before it, or the account's opening balance
+// when there is none.
+func (c BankEntryController) balanceBefore(acc models.BankAccount, res statements.Result) (float64, error) {
+	var first time.Time
+	for _, row := range res.Rows {
+		if row.Err == nil && (first.IsZero() || row.Entry.TransactionDate.Before(first)) {
+			first = row.Entry.TransactionDate
+		}
+	}
+	var last models.BankEntry
+	q := c.DB.Where("bank_account_id = ? AND transaction_date < ?", acc.ID, first).Order("transaction_date DESC, id DESC").Limit(1).Find(&last)
+	if q.Error != nil {
+		return 0, q.Error
+	}
+	if q.RowsAffected == 0 {
+		return acc.OpeningBalance, nil
+	}
+	return last.Balance, nil
+}
+
+// insertImportedRows validates and inserts parsed statement rows one by one
+// so that every line gets its own accept/duplicate/reject status. Rows whose
+// statement currency differs from the account's, or that fall in accounting
+// periods the caller may not write into, are rejected.
+func (c BankEntryController) insertImportedRows(r *http.Request, acc models.BankAccount, res statements.Result, rs services.RuleSet) importReport {
+	report := importReport{
+		BankAccountID: acc.ID,
+		BankCode:      acc.BankCode,
+		Total:         len(res.Rows),
+		Warnings:      res.Warnings,
+		Rows:          make([]importRowResult, 0, len(res.Rows)),
+	}
+	gate := newPeriodGate(c.DB, r)
+	_, reason := overrideRequested(r)
+	// external ids met earlier in this upload
+	seenIDs := map[string]bool{}
+
+	for _, row := range res.Rows {
+		result := importRowResult{Line: row.Line}
+		entry := row.Entry
+
+		if row.Err == nil {
+			row.Err = validateImportedEntry(entry)
+		}
+		if row.Err == nil {
+			row.Err = services.BookToAccount(&entry, acc)
+		}
+		var period *models.AccountingPeriod
+		if row.Err == nil {
+			var blocked string
+			if period, blocked, row.Err = gate.check(entry.CompanyCode, entry.TransactionDate); row.Err == nil && blocked != "" {
+				row.Err = errors.New(blocked)
+			}
+		}
+		if row.Err != nil {
+			result.Status = "rejected"
+			result.Error = row.Err.Error()
+			report.Rejected++
+			report.Rows = append(report.Rows, result)
+			continue
+		}
+
+		if strings.TrimSpace(entry.ID) == "" {
+			entry.ID = genID("BE")
+		}
+		// Statement formats with a stable bank transaction ID (OFX FITID) are
+		// deduplicated on it as well, since banks sometimes reword descriptions
+		if entry.ExternalID != "" && seenIDs[entry.ExternalID] {
+			result.Status = "duplicate"
+			report.Duplicates++
+			report.Rows = append(report.Rows, result)
+			continue
+		}
+		if entry.ExternalID != "" {
+			seenIDs[entry.ExternalID] = true
+			var seen int64
+			if err := c.DB.Unscoped().Model(&models.BankEntry{}).Where("bank_account_id = ? AND external_id = ?", entry.BankAccountID, entry.ExternalID).Count(&seen).Error; err != nil {
+				result.Status = "rejected"
+				result.Error = err.Error()
+				report.Rejected++
+				report.Rows = append(report.Rows, result)
+				continue
+			}
+			if seen > 0 {
+				result.Status = "duplicate"
+				report.Duplicates++
+				report.Rows = append(report.Rows, result)
+				continue
+			}
+		}
+
+		inserted := false
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			inserted = true
+			if err := audit(tx, r, models.AuditEntityBankEntry, entry.ID, entry.CompanyCode, models.AuditActionCreate, nil, entry); err != nil {
+				return err
+			}
+			if period == nil {
+				return nil
+			}
+			return recordOverride(tx, r, *period, reason, models.AuditEntityBankEntry, models.AuditActionCreate, []string{entry.ID})
+		})
+		switch {
+		case err != nil:
+			result.Status = "rejected"
+			result.Error = err.Error()
+			report.Rejected++
+		case !inserted:
+			result.Status = "duplicate"
+			report.Duplicates++
+		default:
+			result.Status = "inserted"
+			result.ID = entry.ID
+			report.Inserted++
+			// a failed categorization does not undo the import
+			hits, err := services.CategorizeBankEntry(c.DB, rs, entry)
+			if err != nil {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("line %d: categorization failed: %v", row.Line, err))
+			} else if len(hits) > 0 {
+				result.Categories = hits
+				report.Categorized++
+			}
+		}
+		report.Rows = append(report.Rows, result)
+	}
+	return report
+}
+
+func validateImportedEntry(e models.BankEntry) error {
+	if strings.TrimSpace(e.Description) == "" {
+		return errors.New("description is empty")
+	}
+	if strings.TrimSpace(e.Branch) == "" {
+		return errors.New("branch is empty")
+	}
+	if e.AmountType != "CR" && e.AmountType != "DB" {
+		return errors.New("amountType must be CR or DB")
+	}
+	if e.TransactionDate.IsZero() {
+		return errors.New("transactionDate is empty")
+	}
+	return nil
+}
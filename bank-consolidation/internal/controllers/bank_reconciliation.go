@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"bank-consolidation/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// bankRecStatsJoin is bankEntryStatsJoin as of a point in time: only links
+// created before it count, so matching or detaching later does not rewrite
+// the statement of a past month. It takes that time twice.
+const bankRecStatsJoin = "LEFT JOIN (SELECT bank_entry_id, COUNT(1) AS attached_count, COALESCE(SUM(matched_amount),0) AS matched_total FROM (SELECT bank_entry_id, bank_amount AS matched_amount FROM bank_entry_invoices WHERE created_at < ? UNION ALL SELECT bank_entry_id, matched_amount FROM bank_entry_purchase_invoices WHERE created_at < ?) links GROUP BY bank_entry_id) st ON st.bank_entry_id = bank_entries.id"
+
+// bankRecItem is a bank entry not (fully) matched to invoices at the month
+// end.
+type bankRecItem struct {
+	BankEntryID        string    `json:"bankEntryId"`
+	TransactionDate    time.Time `json:"transactionDate"`
+	Description        string    `json:"description"`
+	Reference          string    `json:"reference"`
+	Amount             float64   `json:"amount"`
+	MatchedAmount      float64   `json:"matchedAmount"`
+	UnreconciledAmount float64   `json:"unreconciledAmount"`
+}
+
+// bankRecStatement reconciles the statement of one bank account at a month
+// end with its book balance: the statement closing balance less the
+// unreconciled credits plus the unreconciled debits (the adjusted balance)
+// should equal the opening balance plus the reconciled movements. Amounts
+// are in the account's currency.
+type bankRecStatement struct {
+	BankAccountID           string        `json:"bankAccountId"`
+	BankCode                string        `json:"bankCode"`
+	AccountNumber           string        `json:"accountNumber"`
+	AccountName             string        `json:"accountName"`
+	CompanyCode             string        `json:"companyCode"`
+	Currency                string        `json:"currency"`
+	Month                   string        `json:"month"`
+	StatementDate           *time.Time    `json:"statementDate"`
+	StatementBalance        float64       `json:"statementBalance"`
+	UnreconciledCredits     []bankRecItem `json:"unreconciledCredits"`
+	UnreconciledCreditTotal float64       `json:"unreconciledCreditTotal"`
+	UnreconciledDebits      []bankRecItem `json:"unreconciledDebits"`
+	UnreconciledDebitTotal  float64       `json:"unreconciledDebitTotal"`
+	AdjustedBalance         float64       `json:"adjustedBalance"`
+	OpeningBalance          float64       `json:"openingBalance"`
+	ReconciledCredits       float64       `json:"reconciledCredits"`
+	ReconciledDebits        float64       `json:"reconciledDebits"`
+	BookBalance             float64       `json:"bookBalance"`
+	Difference              float64       `json:"difference"`
+	Balanced                bool          `json:"balanced"`
+}
+
+// GetBankReconciliation builds the month-end bank reconciliation statement
+// of every account of bankCode (or only bankAccountId) for month (YYYY-MM).
+// The statement closing balance is the Balance of the last entry up to the
+// month end, or the opening balance when there is none. Unreconciled items
+// are all entries up to the month end with an unallocated amount, counting
+// only links made before the month end. format=csv downloads the report as a
+// spreadsheet.
+func (c ReportsController) GetBankReconciliation(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bankCode, accountID := strings.TrimSpace(q.Get("bankCode")), strings.TrimSpace(q.Get("bankAccountId"))
+	if bankCode == "" && accountID == "" {
+		http.Error(w, "bankCode or bankAccountId is required", http.StatusBadRequest)
+		return
+	}
+	month, err := time.Parse("2006-01", q.Get("month"))
+	if err != nil {
+		http.Error(w, "invalid month, expected YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(q.Get("format"))
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	db := c.DB.Model(&models.BankAccount{}).Scopes(companyScope(r, "company_code"))
+	if accountID != "" {
+		db = db.Where("id = ?", accountID)
+	}
+	if bankCode != "" {
+		db = db.Where("bank_code = ?", bankCode)
+	}
+	var accounts []models.BankAccount
+	if err := db.Order("id").Find(&accounts).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(accounts) == 0 {
+		http.Error(w, "no bank account found", http.StatusNotFound)
+		return
+	}
+
+	end := month.AddDate(0, 1, 0)
+	statements := make([]bankRecStatement, 0, len(accounts))
+	for _, acc := range accounts {
+		st, err := c.bankReconciliation(acc, month.Format("2006-01"), end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		statements = append(statements, st)
+	}
+
+	if format == "csv" {
+		name := accountID
+		if bankCode != "" {
+			name = bankCode
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "bank-reconciliation-"+name+"-"+month.Format("2006-01")+".csv"))
+		writeBankRecCSV(w, statements)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"month":      month.Format("2006-01"),
+		"bankCode":   bankCode,
+		"statements": statements,
+	})
+}
+
+// bankReconciliation builds the statement of acc for entries dated before
+// end, with the matches as they stood at end.
+func (c ReportsController) bankReconciliation(acc models.BankAccount, month string, end time.Time) (bankRecStatement, error) {
+	st := bankRecStatement{
+		BankAccountID:       acc.ID,
+		BankCode:            acc.BankCode,
+		AccountNumber:       acc.AccountNumber,
+		AccountName:         acc.AccountName,
+		CompanyCode:         acc.CompanyCode,
+		Currency:            acc.Currency,
+		Month:               month,
+		StatementBalance:    acc.OpeningBalance,
+		UnreconciledCredits: []bankRecItem{},
+		UnreconciledDebits:  []bankRecItem{},
+		OpeningBalance:      acc.OpeningBalance,
+	}
+	upToEnd := func() *gorm.DB {
+		return c.DB.Model(&models.BankEntry{}).Where("bank_entries.bank_account_id = ? AND bank_entries.transaction_date < ?", acc.ID, end)
+	}
+
+	var last models.BankEntry
+	res := upToEnd().Order("transaction_date DESC, id DESC").Limit(1).Find(&last)
+	if res.Error != nil {
+		return st, res.Error
+	}
+	if res.RowsAffected > 0 {
+		st.StatementDate, st.StatementBalance = &last.TransactionDate, last.Balance
+	}
+
+	var open []models.BankEntry
+	if err := upToEnd().
+		Select(bankEntryStatsSelect).
+		Joins(bankRecStatsJoin, end, end).
+		Where("bank_entries.amount - COALESCE(st.matched_total,0) > 0.005").
+		Order("transaction_date ASC, id ASC").
+		Find(&open).Error; err != nil {
+		return st, err
+	}
+	for _, e := range open {
+		ref := e.EndToEndID
+		if ref == "" {
+			ref = e.CreditorReference
+		}
+		item := bankRecItem{
+			BankEntryID:        e.ID,
+			TransactionDate:    e.TransactionDate,
+			Description:        e.Description,
+			Reference:          ref,
+			Amount:             e.Amount,
+			MatchedAmount:      e.MatchedTotal,
+			UnreconciledAmount: e.UnallocatedAmount,
+		}
+		if e.AmountType == "CR" {
+			st.UnreconciledCredits = append(st.UnreconciledCredits, item)
+			st.UnreconciledCreditTotal += e.UnallocatedAmount
+		} else {
+			st.UnreconciledDebits = append(st.UnreconciledDebits, item)
+			st.UnreconciledDebitTotal += e.UnallocatedAmount
+		}
+	}
+
+	var matched struct {
+		Credit float64
+		Debit  float64
+	}
+	if err := upToEnd().
+		Select(`COALESCE(SUM(CASE WHEN bank_entries.amount_type = 'CR' THEN COALESCE(st.matched_total,0) ELSE 0 END), 0) AS credit,
+			COALESCE(SUM(CASE WHEN bank_entries.amount_type = 'DB' THEN COALESCE(st.matched_total,0) ELSE 0 END), 0) AS debit`).
+		Joins(bankRecStatsJoin, end, end).
+		Scan(&matched).Error; err != nil {
+		return st, err
+	}
+
+	st.ReconciledCredits, st.ReconciledDebits = matched.Credit, matched.Debit
+	st.AdjustedBalance = st.StatementBalance - st.UnreconciledCreditTotal + st.UnreconciledDebitTotal
+	st.BookBalance = st.OpeningBalance + st.ReconciledCredits - st.ReconciledDebits
+	st.round()
+	st.Difference = math.Round((st.AdjustedBalance-st.BookBalance)*100) / 100
+	st.Balanced = math.Abs(st.Difference) < 0.005
+	return st, nil
+}
+
+func (st *bankRecStatement) round() {
+	for _, v := range []*float64{&st.UnreconciledCreditTotal, &st.UnreconciledDebitTotal, &st.AdjustedBalance, &st.ReconciledCredits, &st.ReconciledDebits, &st.BookBalance} {
+		*v = math.Round(*v*100) / 100
+	}
+}
+
+// writeBankRecCSV lays the statements out one below the other, one line per
+// item or total, so the file opens as a spreadsheet.
+func writeBankRecCSV(w http.ResponseWriter, statements []bankRecStatement) {
+	cw := csv.NewWriter(w)
+	amount := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	_ = cw.Write([]string{"bankAccountId", "month", "currency", "line", "date", "bankEntryId", "description", "reference", "amount"})
+	for _, st := range statements {
+		line := func(label, date, id, desc, ref, value string) {
+			_ = cw.Write([]string{st.BankAccountID, st.Month, st.Currency, label, date, id, desc, ref, value})
+		}
+		statementDate := ""
+		if st.StatementDate != nil {
+			statementDate = st.StatementDate.Format("2006-01-02")
+		}
+		line("Balance per bank statement", statementDate, "", st.AccountName, "", amount(st.StatementBalance))
+		for _, it := range st.UnreconciledCredits {
+			line("Less: unreconciled credit", it.TransactionDate.Format("2006-01-02"), it.BankEntryID, it.Description, it.Reference, amount(-it.UnreconciledAmount))
+		}
+		line("Total unreconciled credits", "", "", "", "", amount(-st.UnreconciledCreditTotal))
+		for _, it := range st.UnreconciledDebits {
+			line("Add: unreconciled debit", it.TransactionDate.Format("2006-01-02"), it.BankEntryID, it.Description, it.Reference, amount(it.UnreconciledAmount))
+		}
+		line("Total unreconciled debits", "", "", "", "", amount(st.UnreconciledDebitTotal))
+		line("Adjusted bank balance", "", "", "", "", amount(st.AdjustedBalance))
+		line("Opening balance", "", "", "", "", amount(st.OpeningBalance))
+		line("Reconciled credits", "", "", "", "", amount(st.ReconciledCredits))
+		line("Reconciled debits", "", "", "", "", amount(-st.ReconciledDebits))
+		line("Balance per books", "", "", "", "", amount(st.BookBalance))
+		line("Difference", "", "", "", "", amount(st.Difference))
+	}
+	cw.Flush()
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Suggestions ranks open invoices that the given CR bank entry may be paying.
+func (c BankEntryController) Suggestions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/bank-entries/")
+	id = strings.TrimSuffix(id, "/suggestions")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var entry models.BankEntry
+	err := c.DB.Model(&models.BankEntry{}).
+		Select(bankEntryStatsSelect).
+		Joins(bankEntryStatsJoin).
+		Where("bank_entries.id = ?", id).
+		Scopes(companyScope(r, "bank_entries.company_code")).
+		First(&entry).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "bank entry not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entry.AmountType != "CR" {
+		http.Error(w, "suggestions are only available for CR entries", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := 10
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+	minScore := 0.2
+	if v := q.Get("minScore"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+			minScore = f
+		}
+	}
+
+	candidates, err := services.OpenInvoices(c.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	remaining := entry.Amount - entry.MatchedTotal
+	suggestions := services.SuggestInvoices(entry, remaining, candidates, minScore, limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"bankEntryId": entry.ID,
+		"amount":      entry.Amount,
+		"remaining":   remaining,
+		"suggestions": suggestions,
+	})
+}
@@ -1,9 +1,14 @@
 package controllers
 
 import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
 	"bank-consolidation/models"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
 
 	"gorm.io/gorm"
 )
@@ -17,6 +22,7 @@ func (c CategoryController) CreateOrList(w http.ResponseWriter, r *http.Request)
 			ID             string `json:"id"`
 			Type           string `json:"type"`
 			Name           string `json:"name"`
+			CompanyCode    string `json:"companyCode"`
 			DefaultAccount string `json:"defaultAccount"`
 			BusinessRules  any    `json:"businessRules"`
 			TaxRules       any    `json:"taxRules"`
@@ -37,20 +43,55 @@ func (c CategoryController) CreateOrList(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
+		// a shared category applies to every company
+		body.CompanyCode = strings.TrimSpace(body.CompanyCode)
+		if body.CompanyCode == "" && auth.Companies(r) != nil {
+			http.Error(w, "companyCode is required, shared categories need access to all companies", http.StatusBadRequest)
+			return
+		}
+		if body.CompanyCode != "" && !checkCompany(w, r, body.CompanyCode) {
+			return
+		}
+
 		br, _ := json.Marshal(body.BusinessRules)
 		tr, _ := json.Marshal(body.TaxRules)
+		if _, err := services.ParseRules(string(br)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// postings go to the default account, so it must be in the chart
+		body.DefaultAccount = strings.TrimSpace(body.DefaultAccount)
+		if body.DefaultAccount != "" {
+			if err := services.CheckAccount(c.DB, body.DefaultAccount); err != nil {
+				var verr services.ValidationError
+				if errors.As(err, &verr) {
+					http.Error(w, "defaultAccount: "+verr.Msg, http.StatusBadRequest)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 
 		category := models.Category{
 			ID:             body.ID,
 			Type:           body.Type,
 			Name:           body.Name,
+			CompanyCode:    body.CompanyCode,
 			DefaultAccount: body.DefaultAccount,
 			BusinessRules:  string(br),
 			TaxRules:       string(tr),
 			BudgetRef:      body.BudgetRef,
 		}
 
-		if err := c.DB.Create(&category).Error; err != nil {
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&category).Error; err != nil {
+				return err
+			}
+			return audit(tx, r, models.AuditEntityCategory, category.ID, category.CompanyCode, models.AuditActionCreate, nil, category)
+		})
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -60,7 +101,9 @@ func (c CategoryController) CreateOrList(w http.ResponseWriter, r *http.Request)
 		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": body.ID})
 	case http.MethodGet:
 		var categories []models.Category
-		if err := c.DB.Select("id", "type", "name", "default_account").Order("name").Find(&categories).Error; err != nil {
+		if err := c.DB.Select("id", "type", "name", "company_code", "default_account").
+			Scopes(sharedCompanyScope(r, "company_code")).
+			Order("name").Find(&categories).Error; err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -71,3 +114,125 @@ func (c CategoryController) CreateOrList(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+// TestRules previews which existing bank entries and transactions of the
+// caller's companies a category's rules match, without assigning anything. The body may carry
+// unsaved rules to try instead of the stored ones.
+func (c CategoryController) TestRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/categories/"), "/rules/test")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Rules  json.RawMessage `json:"rules"`
+		Source string          `json:"source"`
+		Scan   int             `json:"scan"`
+		Limit  int             `json:"limit"`
+	}
+	if r.ContentLength != 0 {
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if body.Scan <= 0 || body.Scan > 20000 {
+		body.Scan = 5000
+	}
+	if body.Limit <= 0 || body.Limit > 1000 {
+		body.Limit = 100
+	}
+	if body.Source != "" && body.Source != "bank_entries" && body.Source != "transactions" {
+		http.Error(w, "source must be bank_entries or transactions", http.StatusBadRequest)
+		return
+	}
+
+	var cat models.Category
+	if err := c.DB.Scopes(sharedCompanyScope(r, "company_code")).First(&cat, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	raw := cat.BusinessRules
+	if len(body.Rules) > 0 {
+		raw = string(body.Rules)
+	}
+	rules, err := services.ParseRules(raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cr := services.NewCategoryRules(cat.ID, cat.CompanyCode, rules)
+
+	type match struct {
+		services.RuleSubject
+		RuleID          string `json:"ruleId"`
+		AlreadyAssigned bool   `json:"alreadyAssigned"`
+	}
+	matches := []match{}
+	scanned, matched := 0, 0
+	collect := func(s services.RuleSubject) {
+		scanned++
+		if rule, ok := cr.First(s); ok {
+			matched++
+			if len(matches) < body.Limit {
+				matches = append(matches, match{RuleSubject: s, RuleID: rule.ID})
+			}
+		}
+	}
+
+	if len(rules) > 0 && body.Source != "transactions" {
+		var entries []models.BankEntry
+		if err := c.DB.Scopes(companyScope(r, "company_code")).Order("transaction_date DESC, id DESC").Limit(body.Scan).Find(&entries).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, e := range entries {
+			collect(services.BankEntrySubject(e))
+		}
+	}
+	if len(rules) > 0 && body.Source != "bank_entries" {
+		var txs []models.Transaction
+		if err := c.DB.Preload("Rows").Scopes(companyScope(r, "company_code")).Where("validation_status = ?", models.TransactionStatusValid).Order("import_timestamp DESC").Limit(body.Scan).Find(&txs).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, t := range txs {
+			collect(services.TransactionSubject(t))
+		}
+	}
+
+	// flag subjects that already carry this category
+	for i := range matches {
+		var n int64
+		var q *gorm.DB
+		if matches[i].Kind == services.SubjectBankEntry {
+			q = c.DB.Model(&models.BankEntryCategory{}).Where("bank_entry_id = ? AND category_id = ?", matches[i].ID, cat.ID)
+		} else {
+			q = c.DB.Model(&models.TransactionCategory{}).Where("transaction_id = ? AND category_id = ?", matches[i].ID, cat.ID)
+		}
+		if err := q.Count(&n).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		matches[i].AlreadyAssigned = n > 0
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"categoryId": cat.ID,
+		"rules":      rules,
+		"scanned":    scanned,
+		"matched":    matched,
+		"matches":    matches,
+	})
+}
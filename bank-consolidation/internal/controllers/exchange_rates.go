@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/services"
+	"bank-consolidation/internal/statements"
+	"bank-consolidation/models"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ExchangeRateController struct{ DB *gorm.DB }
+
+// CreateOrList upserts (POST, one rate or an array) or lists (GET) exchange
+// rates. A rate is the IDR value of one unit of currency from rateDate on.
+// GET filters: currency, startDate, endDate; with asOf the rate in effect on
+// that date is returned per currency instead.
+func (c ExchangeRateController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var list []models.ExchangeRate
+		if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+			err = json.Unmarshal(b, &list)
+		} else {
+			var one models.ExchangeRate
+			err = json.Unmarshal(b, &one)
+			list = append(list, one)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(list) == 0 {
+			http.Error(w, "payload must not be empty", http.StatusBadRequest)
+			return
+		}
+
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			for i := range list {
+				list[i].ID = 0
+				if err := saveRate(tx, r, &list[i]); err != nil {
+					var verr services.ValidationError
+					if errors.As(err, &verr) && len(list) > 1 {
+						return services.ValidationError{Msg: fmt.Sprintf("rates[%d]: %s", i, verr.Msg)}
+					}
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			var verr services.ValidationError
+			if errors.As(err, &verr) {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "saved": len(list)})
+	case http.MethodGet:
+		q := r.URL.Query()
+		db := c.DB.Model(&models.ExchangeRate{})
+		if v := q.Get("currency"); v != "" {
+			db = db.Where("currency = ?", strings.ToUpper(v))
+		}
+		if v := q.Get("asOf"); v != "" {
+			t, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				http.Error(w, "invalid asOf, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			latest := c.DB.Model(&models.ExchangeRate{}).Select("currency, MAX(rate_date)").Where("rate_date <= ?", t).Group("currency")
+			db = db.Where("(currency, rate_date) IN (?)", latest)
+		}
+		for _, p := range []struct{ param, cond string }{{"startDate", "rate_date >= ?"}, {"endDate", "rate_date <= ?"}} {
+			if v := q.Get(p.param); v != "" {
+				t, err := time.Parse("2006-01-02", v)
+				if err != nil {
+					http.Error(w, "invalid "+p.param+", expected YYYY-MM-DD", http.StatusBadRequest)
+					return
+				}
+				db = db.Where(p.cond, t)
+			}
+		}
+
+		var list []models.ExchangeRate
+		if err := db.Order("currency, rate_date DESC").Limit(1000).Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []models.ExchangeRate{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type rateImportRow struct {
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Import loads rates from a CSV upload (multipart field "file") with the
+// columns currency, date and rate; other columns are ignored. Rows are saved
+// one by one so that a bad line does not stop the rest. The optional form
+// value source is stored with every rate.
+func (c ExchangeRateController) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	source := strings.TrimSpace(r.FormValue("source"))
+	if source == "" {
+		source = "csv"
+	}
+
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		http.Error(w, "cannot read CSV header: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	for _, name := range []string{"currency", "date", "rate"} {
+		if _, ok := cols[name]; !ok {
+			http.Error(w, fmt.Sprintf("required column %q not found in header", name), http.StatusBadRequest)
+			return
+		}
+	}
+	field := func(rec []string, name string) string {
+		if i := cols[name]; i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	rows := []rateImportRow{}
+	saved, rejected := 0, 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		row := rateImportRow{Status: "saved"}
+		var perr *csv.ParseError
+		switch {
+		case err == nil:
+			row.Line, _ = cr.FieldPos(0)
+			err = c.importRate(r, rec, field, source)
+		case errors.As(err, &perr):
+			row.Line = perr.Line
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			row.Status = "rejected"
+			row.Error = err.Error()
+			rejected++
+		} else {
+			saved++
+		}
+		rows = append(rows, row)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"total": len(rows), "saved": saved, "rejected": rejected, "rows": rows})
+}
+
+// saveRate saves a rate as services.SaveExchangeRate does and records it in
+// the audit log under "<currency>/<date>".
+func saveRate(tx *gorm.DB, r *http.Request, x *models.ExchangeRate) error {
+	var before any
+	var current models.ExchangeRate
+	err := tx.First(&current, "currency = ? AND rate_date = ?", strings.ToUpper(strings.TrimSpace(x.Currency)), x.RateDate.Format("2006-01-02")).Error
+	switch {
+	case err == nil:
+		before = current
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	if err := services.SaveExchangeRate(tx, x); err != nil {
+		return err
+	}
+	var after models.ExchangeRate
+	if err := tx.First(&after, "currency = ? AND rate_date = ?", x.Currency, x.RateDate.Format("2006-01-02")).Error; err != nil {
+		return err
+	}
+	return auditSave(tx, r, models.AuditEntityExchangeRate, x.Currency+"/"+x.RateDate.Format("2006-01-02"), "", before, after)
+}
+
+func (c ExchangeRateController) importRate(r *http.Request, rec []string, field func([]string, string) string, source string) error {
+	date, err := statements.ParseDate(field(rec, "date"), "")
+	if err != nil {
+		return err
+	}
+	rate, err := statements.ParseAmount(field(rec, "rate"), ".", ",")
+	if err != nil {
+		return err
+	}
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		return saveRate(tx, r, &models.ExchangeRate{
+			Currency: field(rec, "currency"),
+			RateDate: date,
+			Rate:     rate,
+			Source:   source,
+		})
+	})
+}
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// writeInvoiceError maps errors from the invoice workflow services onto
+// status codes.
+func writeInvoiceError(w http.ResponseWriter, err error) {
+	var verr services.ValidationError
+	var ferr services.FieldErrors
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.As(err, &ferr):
+		writeFieldErrors(w, err)
+	case errors.As(err, &verr):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// invoiceSnapshot is an invoice with its details as recorded in the audit
+// log.
+type invoiceSnapshot struct {
+	models.InvoiceHeader
+	Details []models.InvoiceDetail `json:"details"`
+}
+
+// checkInvoicePeriods answers 409 unless the caller may change an invoice in
+// the period of its date.
+func (c InvoiceController) checkInvoicePeriods(w http.ResponseWriter, r *http.Request, id string) (periodOverride, bool) {
+	var h models.InvoiceHeader
+	if err := c.DB.Select("id", "company_code", "invoice_date").First(&h, "id = ?", id).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return periodOverride{}, false
+	}
+	return checkPeriods(w, r, c.DB, h.CompanyCode, h.InvoiceDate)
+}
+
+func loadInvoiceSnapshot(tx *gorm.DB, id string) (invoiceSnapshot, error) {
+	var s invoiceSnapshot
+	if err := tx.First(&s.InvoiceHeader, "id = ?", id).Error; err != nil {
+		return s, err
+	}
+	err := tx.Where("header_id = ?", id).Order("id").Find(&s.Details).Error
+	return s, err
+}
+
+// Update replaces the header and details of an invoice that has not been
+// paid or credited yet. An omitted companyCode, invoiceDate, currency or
+// customer keeps the current value; other header fields are replaced.
+func (c InvoiceController) Update(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/invoices/")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var payload InvoicePayload
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.Header.InvoiceHeaderID != "" && payload.Header.InvoiceHeaderID != id {
+		http.Error(w, "invoiceHeaderId does not match the URL", http.StatusBadRequest)
+		return
+	}
+	payload.Header.InvoiceHeaderID = id
+	var current models.InvoiceHeader
+	if err := c.DB.Scopes(companyScope(r, "company_code")).First(&current, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// required header fields that are left out keep their current value
+	h := &payload.Header
+	if strings.TrimSpace(h.CompanyCode) == "" {
+		h.CompanyCode = current.CompanyCode
+	}
+	if h.InvoiceDate.IsZero() {
+		h.InvoiceDate = current.InvoiceDate
+	}
+	if strings.TrimSpace(h.Currency) == "" {
+		h.Currency = current.Currency
+	}
+	if strings.TrimSpace(h.CustomerID) == "" {
+		h.CustomerID = current.CustomerID
+	}
+	if strings.TrimSpace(h.CustomerName) == "" {
+		h.CustomerName = current.CustomerName
+	}
+	if err := validateInvoicePayload(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkCompany(w, r, payload.Header.CompanyCode) {
+		return
+	}
+	// both the period the invoice leaves and the one it moves to must allow it
+	override, ok := c.checkInvoicePeriods(w, r, id)
+	if !ok {
+		return
+	}
+	moved, ok := checkPeriods(w, r, c.DB, payload.Header.CompanyCode, payload.Header.InvoiceDate)
+	if !ok {
+		return
+	}
+	override = override.and(moved)
+
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		before, err := loadInvoiceSnapshot(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := services.UpdateInvoice(tx, id, payload.Header, payload.Details); err != nil {
+			return err
+		}
+		after, err := loadInvoiceSnapshot(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := audit(tx, r, models.AuditEntityInvoice, id, after.CompanyCode, models.AuditActionUpdate, before, after); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityInvoice, models.AuditActionUpdate, id)
+	})
+	if err != nil {
+		writeInvoiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status":          "ok",
+		"invoiceHeaderId": id,
+		"totalDetails":    len(payload.Details),
+	})
+}
+
+// Void cancels an invoice that has no reconciled payments.
+func (c InvoiceController) Void(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoices/"), "/void")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !checkVisible(w, r, c.DB, &models.InvoiceHeader{}, "id = ?", id) {
+		return
+	}
+	override, ok := c.checkInvoicePeriods(w, r, id)
+	if !ok {
+		return
+	}
+
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		var before, after models.InvoiceHeader
+		if err := tx.First(&before, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := services.VoidInvoice(tx, id, body.Reason, actorFrom(r)); err != nil {
+			return err
+		}
+		if err := tx.First(&after, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := audit(tx, r, models.AuditEntityInvoice, id, after.CompanyCode, models.AuditActionUpdate, before, after); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityInvoice, models.AuditActionUpdate, id)
+	})
+	if err != nil {
+		writeInvoiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "invoiceHeaderId": id, "invoiceStatus": models.InvoiceStatusVoid})
+}
+
+// CreditNotes issues (POST) or lists (GET) the credit notes of an invoice.
+func (c InvoiceController) CreditNotes(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoices/"), "/credit-notes")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !checkVisible(w, r, c.DB, &models.InvoiceHeader{}, "id = ?", id) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var cn models.CreditNote
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cn); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cn.InvoiceHeaderID = id
+		if cn.ID == "" {
+			cn.ID = genID("CN")
+		}
+		cn.CreatedBy = actorFrom(r)
+		// a credit note is booked on its own date, so it can correct an
+		// invoice of a closed period
+		var company string
+		if err := c.DB.Model(&models.InvoiceHeader{}).Select("company_code").Where("id = ?", id).Scan(&company).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if cn.CreditDate.IsZero() {
+			cn.CreditDate = time.Now()
+		}
+		override, ok := checkPeriods(w, r, c.DB, company, cn.CreditDate)
+		if !ok {
+			return
+		}
+
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			if err := services.IssueCreditNote(tx, &cn); err != nil {
+				return err
+			}
+			if err := audit(tx, r, models.AuditEntityCreditNote, cn.ID, company, models.AuditActionCreate, nil, cn); err != nil {
+				return err
+			}
+			return override.record(tx, r, models.AuditEntityCreditNote, models.AuditActionCreate, cn.ID)
+		})
+		if err != nil {
+			writeInvoiceError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(cn)
+	case http.MethodGet:
+		var list []models.CreditNote
+		if err := c.DB.Where("invoice_header_id = ?", id).Order("credit_date ASC, created_at ASC").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []models.CreditNote{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"bank-consolidation/internal/services"
 	"bank-consolidation/models"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"net/http"
 	"strconv"
@@ -21,13 +23,23 @@ type InvoicePayload struct {
 	Details []models.InvoiceDetail `json:"details"`
 }
 
-func validateInvoicePayload(p InvoicePayload) error {
+// validateInvoicePayload checks required fields and normalises the currency.
+func validateInvoicePayload(p *InvoicePayload) error {
 	if p.Header.InvoiceHeaderID == "" {
 		return errors.New("invoiceHeaderId is required")
 	}
 	if p.Header.InvoiceNo == "" {
 		return errors.New("invoiceNo is required")
 	}
+	p.Header.CompanyCode = strings.TrimSpace(p.Header.CompanyCode)
+	if p.Header.CompanyCode == "" {
+		return errors.New("companyCode is required")
+	}
+	cur, err := services.NormalizeCurrency(p.Header.Currency)
+	if err != nil {
+		return err
+	}
+	p.Header.Currency = cur
 	if len(p.Details) == 0 {
 		return errors.New("details must not be empty")
 	}
@@ -42,6 +54,14 @@ func validateInvoicePayload(p InvoicePayload) error {
 	return nil
 }
 
+func formatDate(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format("2006-01-02")
+	return &s
+}
+
 func (c InvoiceController) Create(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -54,19 +74,35 @@ func (c InvoiceController) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := validateInvoicePayload(payload); err != nil {
+	if err := validateInvoicePayload(&payload); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !checkCompany(w, r, payload.Header.CompanyCode) {
+		return
+	}
+	override, ok := checkPeriods(w, r, c.DB, payload.Header.CompanyCode, payload.Header.InvoiceDate)
+	if !ok {
+		return
+	}
+	if err := services.ApplyPaymentTerms(&payload.Header); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Amounts, PPN and header totals are always computed here; client values
+	// only serve as a cross-check
+	if err := services.ComputeInvoiceTotals(&payload.Header, payload.Details); err != nil {
+		writeFieldErrors(w, err)
+		return
+	}
 
 	err := c.DB.Transaction(func(tx *gorm.DB) error {
 		// Prepare header
 		header := payload.Header
 		header.Status = "pending"
-		header.TotalTax = 0 // Will be calculated or assumed 0 as per original code? Original code set it to 0 in INSERT but loop might calculate?
-		// Actually original code: INSERT ... VALUES (..., 0, ?) -> TotalTax = 0.
-		// But wait, the loop calculates tax? No, the loop inserts into invoice_details with calculated tax, but doesn't update header.
-		// Let's stick to original behavior: Header TotalTax = 0 in INSERT.
+		header.VoidedAt = nil
+		header.VoidedBy = ""
+		header.VoidReason = ""
 
 		if err := tx.Create(&header).Error; err != nil {
 			return err
@@ -79,7 +115,18 @@ func (c InvoiceController) Create(w http.ResponseWriter, r *http.Request) {
 				return err
 			}
 		}
-		return nil
+		// an invoice entered after its due date starts out overdue
+		if err := services.RefreshInvoiceStatus(tx, header.InvoiceHeaderID); err != nil {
+			return err
+		}
+		after, err := loadInvoiceSnapshot(tx, header.InvoiceHeaderID)
+		if err != nil {
+			return err
+		}
+		if err := audit(tx, r, models.AuditEntityInvoice, header.InvoiceHeaderID, header.CompanyCode, models.AuditActionCreate, nil, after); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityInvoice, models.AuditActionCreate, header.InvoiceHeaderID)
 	})
 
 	if err != nil {
@@ -94,6 +141,25 @@ func (c InvoiceController) Create(w http.ResponseWriter, r *http.Request) {
 		"invoiceHeaderId": payload.Header.InvoiceHeaderID,
 		"invoiceNo":       payload.Header.InvoiceNo,
 		"totalDetails":    len(payload.Details),
+		"currency":        payload.Header.Currency,
+		"totalAmount":     payload.Header.TotalAmount,
+		"totalTax":        payload.Header.TotalTax,
+	})
+}
+
+// writeFieldErrors answers 422 with the offending fields so the client can
+// highlight them.
+func writeFieldErrors(w http.ResponseWriter, err error) {
+	var fe services.FieldErrors
+	if !errors.As(err, &fe) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":  "invoice validation failed",
+		"fields": fe,
 	})
 }
 
@@ -109,7 +175,7 @@ func (c InvoiceController) GetByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var header models.InvoiceHeader
-	if err := c.DB.Where("id = ?", id).First(&header).Error; err != nil {
+	if err := c.DB.Scopes(companyScope(r, "company_code")).Where("id = ?", id).First(&header).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -133,6 +199,14 @@ func (c InvoiceController) GetByID(w http.ResponseWriter, r *http.Request) {
 		CustomerID   string  `json:"customerId"`
 		CustomerName string  `json:"customerName"`
 		Status       string  `json:"status"`
+		Currency     string  `json:"currency"`
+		DueDate      *string `json:"dueDate"`
+		Termin       string  `json:"termin"`
+		TermDays     *int    `json:"termDays"`
+		Notes        string  `json:"notes"`
+		VoidedAt     *string `json:"voidedAt,omitempty"`
+		VoidedBy     string  `json:"voidedBy,omitempty"`
+		VoidReason   string  `json:"voidReason,omitempty"`
 		TotalAmount  float64 `json:"totalAmount"`
 		TotalTax     float64 `json:"totalTax"`
 		CompanyCode  string  `json:"companyCode"`
@@ -143,6 +217,14 @@ func (c InvoiceController) GetByID(w http.ResponseWriter, r *http.Request) {
 		CustomerID:   header.CustomerID,
 		CustomerName: header.CustomerName,
 		Status:       header.Status,
+		Currency:     header.Currency,
+		DueDate:      formatDate(header.DueDate),
+		Termin:       header.Termin,
+		TermDays:     header.TermDays,
+		Notes:        header.Notes,
+		VoidedAt:     formatDate(header.VoidedAt),
+		VoidedBy:     header.VoidedBy,
+		VoidReason:   header.VoidReason,
 		TotalAmount:  header.TotalAmount,
 		TotalTax:     header.TotalTax,
 		CompanyCode:  header.CompanyCode,
@@ -186,7 +268,7 @@ func (c InvoiceController) CreateOrList(w http.ResponseWriter, r *http.Request)
 		c.Create(w, r)
 	case http.MethodGet:
 		q := r.URL.Query()
-		db := c.DB.Model(&models.InvoiceHeader{})
+		db := c.DB.Model(&models.InvoiceHeader{}).Scopes(companyScope(r, "company_code"))
 
 		if v := q.Get("status"); v != "" {
 			db = db.Where("status = ?", v)
@@ -200,6 +282,9 @@ func (c InvoiceController) CreateOrList(w http.ResponseWriter, r *http.Request)
 		if v := q.Get("companyCode"); v != "" {
 			db = db.Where("company_code = ?", v)
 		}
+		if v := q.Get("currency"); v != "" {
+			db = db.Where("currency = ?", strings.ToUpper(v))
+		}
 		if v := q.Get("startDate"); v != "" {
 			db = db.Where("invoice_date >= ?", v)
 		}
@@ -208,11 +293,11 @@ func (c InvoiceController) CreateOrList(w http.ResponseWriter, r *http.Request)
 		}
 
 		// Subquery for paid amount
-		paidSubquery := "(SELECT COALESCE(SUM(matched_amount), 0) FROM bank_entry_invoices WHERE invoice_header_id = invoice_headers.id)"
+		paidSubquery := services.InvoicePaidSubquery
 
 		// Option to exclude fully paid
 		if v := q.Get("excludeFullyPaid"); v == "1" || strings.EqualFold(v, "true") {
-			condition := fmt.Sprintf("total_amount > %s", paidSubquery)
+			condition := fmt.Sprintf("total_amount > %s", services.InvoiceSettledExpr)
 
 			if inc := q.Get("includeIds"); inc != "" {
 				idsRaw := strings.Split(inc, ",")
@@ -266,13 +351,14 @@ func (c InvoiceController) CreateOrList(w http.ResponseWriter, r *http.Request)
 		// Select fields including paid_amount
 		type Result struct {
 			models.InvoiceHeader
-			PaidAmount float64 `json:"paidAmount" gorm:"column:paid_amount"`
+			PaidAmount     float64 `json:"paidAmount" gorm:"column:paid_amount"`
+			CreditedAmount float64 `json:"creditedAmount" gorm:"column:credited_amount"`
 		}
 		var results []Result
 
 		// We need to select specific fields to populate the struct correctly, especially the computed column
 		// GORM can scan into struct.
-		if err := db.Select("invoice_headers.*, " + paidSubquery + " as paid_amount").Scan(&results).Error; err != nil {
+		if err := db.Select("invoice_headers.*, " + paidSubquery + " as paid_amount, " + services.InvoiceCreditSubquery + " as credited_amount").Scan(&results).Error; err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -280,16 +366,20 @@ func (c InvoiceController) CreateOrList(w http.ResponseWriter, r *http.Request)
 		var list []map[string]any
 		for _, m := range results {
 			list = append(list, map[string]any{
-				"id":           m.InvoiceHeaderID,
-				"invoiceNo":    m.InvoiceNo,
-				"invoiceDate":  m.InvoiceDate.Format("2006-01-02"), // simplified date
-				"customerId":   m.CustomerID,
-				"customerName": m.CustomerName,
-				"status":       m.Status,
-				"totalAmount":  m.TotalAmount,
-				"totalTax":     m.TotalTax,
-				"companyCode":  m.CompanyCode,
-				"paidAmount":   m.PaidAmount,
+				"id":             m.InvoiceHeaderID,
+				"invoiceNo":      m.InvoiceNo,
+				"invoiceDate":    m.InvoiceDate.Format("2006-01-02"), // simplified date
+				"customerId":     m.CustomerID,
+				"customerName":   m.CustomerName,
+				"status":         m.Status,
+				"dueDate":        formatDate(m.DueDate),
+				"currency":       m.Currency,
+				"totalAmount":    m.TotalAmount,
+				"totalTax":       m.TotalTax,
+				"companyCode":    m.CompanyCode,
+				"paidAmount":     m.PaidAmount,
+				"creditedAmount": m.CreditedAmount,
+				"outstanding":    math.Round((m.TotalAmount-m.PaidAmount-m.CreditedAmount)*100) / 100,
 			})
 		}
 
@@ -373,6 +463,9 @@ func (c InvoiceController) GenerateSample(w http.ResponseWriter, r *http.Request
 			if err := tx.Create(&details).Error; err != nil {
 				return err
 			}
+			if err := audit(tx, r, models.AuditEntityInvoice, headerID, header.CompanyCode, models.AuditActionCreate, nil, invoiceSnapshot{header, details}); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AccountController struct{ DB *gorm.DB }
+
+var accountTypes = map[string]bool{
+	models.AccountTypeAsset:     true,
+	models.AccountTypeLiability: true,
+	models.AccountTypeEquity:    true,
+	models.AccountTypeRevenue:   true,
+	models.AccountTypeExpense:   true,
+}
+
+var accountRoles = map[string]bool{
+	models.AccountRoleBank:         true,
+	models.AccountRoleReceivable:   true,
+	models.AccountRolePayable:      true,
+	models.AccountRoleFxGainLoss:   true,
+	models.AccountRoleSalesReturns: true,
+}
+
+// CreateOrList upserts (POST) or lists (GET) the chart of accounts.
+func (c AccountController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			Code     string  `json:"code"`
+			Name     string  `json:"name"`
+			Type     string  `json:"type"`
+			Role     *string `json:"role"`
+			IsActive *bool   `json:"isActive"`
+		}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.Code = strings.TrimSpace(body.Code)
+		if body.Code == "" || strings.TrimSpace(body.Name) == "" {
+			http.Error(w, "code and name are required", http.StatusBadRequest)
+			return
+		}
+		body.Type = strings.ToLower(strings.TrimSpace(body.Type))
+		if !accountTypes[body.Type] {
+			http.Error(w, "type must be asset, liability, equity, revenue or expense", http.StatusBadRequest)
+			return
+		}
+		if body.Role != nil && *body.Role == "" {
+			body.Role = nil
+		}
+		if body.Role != nil && !accountRoles[*body.Role] {
+			http.Error(w, "role must be bank, receivable, payable, fx_gain_loss or sales_returns", http.StatusBadRequest)
+			return
+		}
+
+		acc := models.Account{Code: body.Code, Name: body.Name, Type: body.Type, Role: body.Role, IsActive: true}
+		if body.IsActive != nil {
+			acc.IsActive = *body.IsActive
+		}
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			// a role moves to the new account
+			if acc.Role != nil {
+				var holder models.Account
+				err := tx.First(&holder, "role = ? AND code <> ?", *acc.Role, acc.Code).Error
+				switch {
+				case err == nil:
+					if err := tx.Model(&holder).Update("role", nil).Error; err != nil {
+						return err
+					}
+					before := holder
+					before.Role = acc.Role
+					if err := audit(tx, r, models.AuditEntityAccount, holder.Code, "", models.AuditActionUpdate, before, holder); err != nil {
+						return err
+					}
+				case !errors.Is(err, gorm.ErrRecordNotFound):
+					return err
+				}
+			}
+			var before any
+			var current models.Account
+			err := tx.First(&current, "code = ?", acc.Code).Error
+			switch {
+			case err == nil:
+				before = current
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				return err
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "code"}},
+				DoUpdates: clause.AssignmentColumns([]string{"name", "type", "role", "is_active", "updated_at"}),
+			}).Create(&acc).Error; err != nil {
+				return err
+			}
+			var saved models.Account
+			if err := tx.First(&saved, "code = ?", acc.Code).Error; err != nil {
+				return err
+			}
+			return auditSave(tx, r, models.AuditEntityAccount, acc.Code, "", before, saved)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(acc)
+	case http.MethodGet:
+		var list []models.Account
+		if err := c.DB.Order("code").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type JournalController struct{ DB *gorm.DB }
+
+// List returns journal entries of the caller's companies with their lines,
+// newest first. Filters: companyCode, source, sourceId, accountCode,
+// startDate and endDate.
+func (c JournalController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	db := c.DB.Model(&models.JournalEntry{}).Scopes(companyScope(r, "company_code"))
+	if v := q.Get("companyCode"); v != "" {
+		db = db.Where("company_code = ?", v)
+	}
+	if v := q.Get("source"); v != "" {
+		db = db.Where("source = ?", v)
+	}
+	if v := q.Get("sourceId"); v != "" {
+		db = db.Where("source_id = ?", v)
+	}
+	if v := q.Get("accountCode"); v != "" {
+		db = db.Where("id IN (?)", c.DB.Model(&models.JournalLine{}).Select("journal_entry_id").Where("account_code = ?", v))
+	}
+	for _, p := range []struct{ param, cond string }{{"startDate", "entry_date >= ?"}, {"endDate", "entry_date <= ?"}} {
+		if v := q.Get(p.param); v != "" {
+			t, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				http.Error(w, "invalid "+p.param+", expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			db = db.Where(p.cond, t)
+		}
+	}
+
+	lim := 100
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 500 {
+			lim = n
+		}
+	}
+
+	var list []models.JournalEntry
+	if err := db.Preload("Lines").Order("entry_date DESC, id DESC").Limit(lim).Find(&list).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.JournalEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AccountingPeriodController struct{ DB *gorm.DB }
+
+var periodStatuses = map[string]bool{
+	models.PeriodStatusOpen:       true,
+	models.PeriodStatusSoftClosed: true,
+	models.PeriodStatusClosed:     true,
+}
+
+// CreateOrList sets (POST) or lists (GET) the close state of accounting
+// periods of the caller's companies. Reopening a closed period needs an
+// admin. GET filters: companyCode, status, and from/to (YYYY-MM,
+// inclusive).
+func (c AccountingPeriodController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var body struct {
+			CompanyCode string `json:"companyCode"`
+			Period      string `json:"period"`
+			Status      string `json:"status"`
+			Note        string `json:"note"`
+		}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.CompanyCode = strings.TrimSpace(body.CompanyCode)
+		if body.CompanyCode == "" {
+			http.Error(w, "companyCode is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := time.Parse("2006-01", body.Period); err != nil {
+			http.Error(w, "invalid period, expected YYYY-MM", http.StatusBadRequest)
+			return
+		}
+		body.Status = strings.ToLower(strings.TrimSpace(body.Status))
+		if !periodStatuses[body.Status] {
+			http.Error(w, "status must be open, soft_closed or closed", http.StatusBadRequest)
+			return
+		}
+		if !checkCompany(w, r, body.CompanyCode) {
+			return
+		}
+
+		var before any
+		var current models.AccountingPeriod
+		err := c.DB.First(&current, "company_code = ? AND period = ?", body.CompanyCode, body.Period).Error
+		switch {
+		case err == nil:
+			before = current
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if current.Status == models.PeriodStatusClosed && body.Status != models.PeriodStatusClosed && !auth.Allowed(r, models.RoleAdmin) {
+			auth.Deny(w, r, models.RoleAdmin)
+			return
+		}
+
+		p := models.AccountingPeriod{CompanyCode: body.CompanyCode, Period: body.Period, Status: body.Status, Note: body.Note}
+		switch {
+		case p.Status == models.PeriodStatusOpen:
+		case p.Status == current.Status:
+			p.ClosedBy, p.ClosedAt = current.ClosedBy, current.ClosedAt
+		default:
+			now := time.Now()
+			p.ClosedBy, p.ClosedAt = actorFrom(r), &now
+		}
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "company_code"}, {Name: "period"}},
+				DoUpdates: clause.AssignmentColumns([]string{"status", "note", "closed_by", "closed_at", "updated_at"}),
+			}).Create(&p).Error; err != nil {
+				return err
+			}
+			var saved models.AccountingPeriod
+			if err := tx.First(&saved, "company_code = ? AND period = ?", p.CompanyCode, p.Period).Error; err != nil {
+				return err
+			}
+			p = saved
+			return auditSave(tx, r, models.AuditEntityAccountingPeriod, services.PeriodKey(p.CompanyCode, p.Period), p.CompanyCode, before, p)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p)
+	case http.MethodGet:
+		q := r.URL.Query()
+		db := c.DB.Model(&models.AccountingPeriod{}).Scopes(companyScope(r, "company_code"))
+		if v := q.Get("companyCode"); v != "" {
+			db = db.Where("company_code = ?", v)
+		}
+		if v := q.Get("status"); v != "" {
+			db = db.Where("status = ?", v)
+		}
+		for _, f := range []struct{ param, cond string }{{"from", "period >= ?"}, {"to", "period <= ?"}} {
+			if v := q.Get(f.param); v != "" {
+				if _, err := time.Parse("2006-01", v); err != nil {
+					http.Error(w, "invalid "+f.param+", expected YYYY-MM", http.StatusBadRequest)
+					return
+				}
+				db = db.Where(f.cond, v)
+			}
+		}
+
+		var list []models.AccountingPeriod
+		if err := db.Order("company_code, period DESC").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []models.AccountingPeriod{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// overrideRequested reports whether the caller asked to write into closed
+// periods (override=true) and why (reason).
+func overrideRequested(r *http.Request) (bool, string) {
+	q := r.URL.Query()
+	v := q.Get("override")
+	return v == "1" || strings.EqualFold(v, "true"), strings.TrimSpace(q.Get("reason"))
+}
+
+// periodBlocked explains why the caller may not write into p, or returns ""
+// when it may: soft-closed periods take a supervisor, closed ones a
+// supervisor asking for an override with a reason.
+func periodBlocked(r *http.Request, p models.AccountingPeriod) string {
+	switch p.Status {
+	case models.PeriodStatusSoftClosed:
+		if !auth.Allowed(r, models.RoleSupervisor) {
+			return fmt.Sprintf("accounting period %s of company %s is soft-closed, only a supervisor may change it", p.Period, p.CompanyCode)
+		}
+	case models.PeriodStatusClosed:
+		override, reason := overrideRequested(r)
+		if !auth.Allowed(r, models.RoleSupervisor) {
+			return fmt.Sprintf("accounting period %s of company %s is closed", p.Period, p.CompanyCode)
+		}
+		if !override || reason == "" {
+			return fmt.Sprintf("accounting period %s of company %s is closed, pass override=true and a reason to change it anyway", p.Period, p.CompanyCode)
+		}
+	}
+	return ""
+}
+
+// periodOverride is the set of soft-closed and closed periods a permitted
+// write goes into.
+type periodOverride struct {
+	periods []models.AccountingPeriod
+	reason  string
+}
+
+// checkPeriods answers 409 unless the caller may write into the accounting
+// periods of company that dates fall in and reports whether to go on. The
+// override returned must be recorded with the change.
+func checkPeriods(w http.ResponseWriter, r *http.Request, db *gorm.DB, company string, dates ...time.Time) (periodOverride, bool) {
+	list, err := services.LockedPeriods(db, company, dates...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return periodOverride{}, false
+	}
+	override, err := allowPeriods(r, list)
+	var perr periodLockedError
+	if errors.As(err, &perr) {
+		writePeriodLocked(w, perr.period, perr.msg)
+		return periodOverride{}, false
+	}
+	return override, true
+}
+
+// lockPeriods is checkPeriods inside the transaction of the write: the
+// periods are read FOR SHARE so they cannot be closed before it commits, and
+// a refusal comes back as a periodLockedError.
+func lockPeriods(tx *gorm.DB, r *http.Request, company string, dates ...time.Time) (periodOverride, error) {
+	list, err := services.LockedPeriods(tx.Clauses(clause.Locking{Strength: "SHARE"}), company, dates...)
+	if err != nil {
+		return periodOverride{}, err
+	}
+	return allowPeriods(r, list)
+}
+
+// periodLockedError is a write refused by the close state of period.
+type periodLockedError struct {
+	period models.AccountingPeriod
+	msg    string
+}
+
+func (e periodLockedError) Error() string { return e.msg }
+
+// allowPeriods returns the override for writing into the soft-closed and
+// closed periods list, or a periodLockedError for the first the caller may
+// not write into.
+func allowPeriods(r *http.Request, list []models.AccountingPeriod) (periodOverride, error) {
+	for _, p := range list {
+		if msg := periodBlocked(r, p); msg != "" {
+			return periodOverride{}, periodLockedError{period: p, msg: msg}
+		}
+	}
+	_, reason := overrideRequested(r)
+	return periodOverride{periods: list, reason: reason}, nil
+}
+
+func writePeriodLocked(w http.ResponseWriter, p models.AccountingPeriod, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":       "period_locked",
+		"message":     msg,
+		"companyCode": p.CompanyCode,
+		"period":      p.Period,
+		"status":      p.Status,
+	})
+}
+
+// and adds the periods of another check of the same write.
+func (o periodOverride) and(other periodOverride) periodOverride {
+	o.periods = append(append([]models.AccountingPeriod{}, o.periods...), other.periods...)
+	return o
+}
+
+// record writes one override to the audit log per period, naming the
+// change it allowed.
+func (o periodOverride) record(tx *gorm.DB, r *http.Request, entity, action string, ids ...string) error {
+	seen := map[uint]bool{}
+	for _, p := range o.periods {
+		if seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+		if err := recordOverride(tx, r, p, o.reason, entity, action, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordOverride(tx *gorm.DB, r *http.Request, p models.AccountingPeriod, reason, entity, action string, ids []string) error {
+	return audit(tx, r, models.AuditEntityAccountingPeriod, services.PeriodKey(p.CompanyCode, p.Period), p.CompanyCode, models.AuditActionOverride, nil, map[string]any{
+		"status":    p.Status,
+		"entity":    entity,
+		"entityIds": ids,
+		"action":    action,
+		"reason":    reason,
+	})
+}
+
+// periodGate checks the rows of bulk writes against accounting periods,
+// loading each period once.
+type periodGate struct {
+	db      *gorm.DB
+	r       *http.Request
+	periods map[string]*models.AccountingPeriod
+}
+
+func newPeriodGate(db *gorm.DB, r *http.Request) *periodGate {
+	return &periodGate{db: db, r: r, periods: map[string]*models.AccountingPeriod{}}
+}
+
+// check returns the soft-closed or closed period a row of company dated
+// date goes into, nil for open ones, and why the caller may not write into
+// it, "" when it may.
+func (g *periodGate) check(company string, date time.Time) (*models.AccountingPeriod, string, error) {
+	key := services.PeriodKey(company, services.PeriodOf(date))
+	p, known := g.periods[key]
+	if !known {
+		list, err := services.LockedPeriods(g.db, company, date)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(list) > 0 {
+			p = &list[0]
+		}
+		g.periods[key] = p
+	}
+	if p == nil {
+		return nil, "", nil
+	}
+	return p, periodBlocked(g.r, *p), nil
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func periodRequest(role, query string) *http.Request {
+	r := httptest.NewRequest(http.MethodPut, "/bank-entries/e1?"+query, nil)
+	if role == "" {
+		return r
+	}
+	return r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Kind: auth.KindUser, Name: "u", Role: role}))
+}
+
+func TestPeriodBlocked(t *testing.T) {
+	period := func(status string) models.AccountingPeriod {
+		return models.AccountingPeriod{CompanyCode: "A", Period: "2024-01", Status: status}
+	}
+	tests := []struct {
+		name    string
+		status  string
+		role    string
+		query   string
+		blocked string // part of the message, "" when the write may go ahead
+	}{
+		{"open, staff", models.PeriodStatusOpen, models.RoleStaff, "", ""},
+		{"open, no principal", models.PeriodStatusOpen, "", "", ""},
+		{"soft-closed, staff", models.PeriodStatusSoftClosed, models.RoleStaff, "", "only a supervisor"},
+		{"soft-closed, staff asking to override", models.PeriodStatusSoftClosed, models.RoleStaff, "override=true&reason=late+fee", "only a supervisor"},
+		{"soft-closed, supervisor", models.PeriodStatusSoftClosed, models.RoleSupervisor, "", ""},
+		{"soft-closed, admin", models.PeriodStatusSoftClosed, models.RoleAdmin, "", ""},
+		{"closed, staff", models.PeriodStatusClosed, models.RoleStaff, "", "is closed"},
+		{"closed, staff with override", models.PeriodStatusClosed, models.RoleStaff, "override=true&reason=late+fee", "is closed"},
+		{"closed, supervisor without override", models.PeriodStatusClosed, models.RoleSupervisor, "", "pass override=true"},
+		{"closed, supervisor override without reason", models.PeriodStatusClosed, models.RoleSupervisor, "override=true", "pass override=true"},
+		{"closed, supervisor override with blank reason", models.PeriodStatusClosed, models.RoleSupervisor, "override=1&reason=+", "pass override=true"},
+		{"closed, supervisor override with reason", models.PeriodStatusClosed, models.RoleSupervisor, "override=true&reason=late+fee", ""},
+		{"closed, supervisor override=1 with reason", models.PeriodStatusClosed, models.RoleSupervisor, "override=1&reason=audit", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := periodBlocked(periodRequest(tt.role, tt.query), period(tt.status))
+			if tt.blocked == "" && got != "" || !strings.Contains(got, tt.blocked) {
+				t.Errorf("periodBlocked = %q, want %q", got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestPeriodGateCheck(t *testing.T) {
+	soft := &models.AccountingPeriod{CompanyCode: "A", Period: "2024-01", Status: models.PeriodStatusSoftClosed}
+	closed := &models.AccountingPeriod{CompanyCode: "A", Period: "2024-02", Status: models.PeriodStatusClosed}
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	tests := []struct {
+		name       string
+		role       string
+		query      string
+		date       string
+		wantPeriod *models.AccountingPeriod
+		blocked    bool
+	}{
+		{"open period", models.RoleStaff, "", "2024-03-15", nil, false},
+		{"soft-closed, staff", models.RoleStaff, "", "2024-01-31", soft, true},
+		{"soft-closed, supervisor", models.RoleSupervisor, "", "2024-01-01", soft, false},
+		{"closed, supervisor without override", models.RoleSupervisor, "", "2024-02-10", closed, true},
+		{"closed, supervisor override", models.RoleSupervisor, "override=true&reason=fix", "2024-02-10", closed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every period is preloaded so the gate never reads the database
+			g := newPeriodGate(nil, periodRequest(tt.role, tt.query))
+			g.periods[services.PeriodKey("A", "2024-01")] = soft
+			g.periods[services.PeriodKey("A", "2024-02")] = closed
+			g.periods[services.PeriodKey("A", "2024-03")] = nil
+			p, msg, err := g.check("A", date(tt.date))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != tt.wantPeriod || (msg != "") != tt.blocked {
+				t.Errorf("check = %+v %q, want %+v blocked %v", p, msg, tt.wantPeriod, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestWriteEntryError(t *testing.T) {
+	closed := models.AccountingPeriod{CompanyCode: "A", Period: "2024-02", Status: models.PeriodStatusClosed}
+	_, locked := allowPeriods(periodRequest(models.RoleStaff, ""), []models.AccountingPeriod{closed})
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"closed period", locked, http.StatusConflict},
+		{"wrapped closed period", fmt.Errorf("reconcile: %w", locked), http.StatusConflict},
+		{"conflict", conflictError("bank entry has reconciled invoices"), http.StatusConflict},
+		{"not found", gorm.ErrRecordNotFound, http.StatusNotFound},
+		{"validation", services.ValidationError{Msg: "too much"}, http.StatusUnprocessableEntity},
+		{"other", errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeEntryError(w, tt.err, http.StatusUnprocessableEntity)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+	w := httptest.NewRecorder()
+	writeEntryError(w, locked, http.StatusBadRequest)
+	if !strings.Contains(w.Body.String(), `"period_locked"`) {
+		t.Errorf("body = %s, want a period_locked error", w.Body.String())
+	}
+}
@@ -0,0 +1,493 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PurchaseInvoiceController struct{ DB *gorm.DB }
+
+// checkPurchasePeriods is checkPeriods for purchase invoices, each checked
+// against the period of its own company and purchaseInvoiceDate.
+func checkPurchasePeriods(w http.ResponseWriter, r *http.Request, db *gorm.DB, invoices ...models.PurchaseInvoiceHeader) (periodOverride, bool) {
+	_, reason := overrideRequested(r)
+	override := periodOverride{reason: reason}
+	for _, inv := range invoices {
+		date, err := time.Parse("2006-01-02", inv.PurchaseInvoiceDate)
+		if err != nil {
+			http.Error(w, "purchase invoice "+strconv.FormatInt(inv.PurchaseInvoiceHeaderID, 10)+" has no valid purchaseInvoiceDate", http.StatusConflict)
+			return periodOverride{}, false
+		}
+		more, ok := checkPeriods(w, r, db, inv.CompanyCode, date)
+		if !ok {
+			return periodOverride{}, false
+		}
+		override = override.and(more)
+	}
+	return override, true
+}
+
+// lockPurchasePeriods is checkPurchasePeriods inside the transaction of the
+// write, see lockPeriods.
+func lockPurchasePeriods(tx *gorm.DB, r *http.Request, invoices ...models.PurchaseInvoiceHeader) (periodOverride, error) {
+	_, reason := overrideRequested(r)
+	override := periodOverride{reason: reason}
+	for _, inv := range invoices {
+		date, err := time.Parse("2006-01-02", inv.PurchaseInvoiceDate)
+		if err != nil {
+			return periodOverride{}, conflictError("purchase invoice " + strconv.FormatInt(inv.PurchaseInvoiceHeaderID, 10) + " has no valid purchaseInvoiceDate")
+		}
+		more, err := lockPeriods(tx, r, inv.CompanyCode, date)
+		if err != nil {
+			return periodOverride{}, err
+		}
+		override = override.and(more)
+	}
+	return override, nil
+}
+
+func validatePurchaseInvoice(h models.PurchaseInvoiceHeader) error {
+	if strings.TrimSpace(h.PurchaseInvoiceNo) == "" {
+		return errors.New("purchaseInvoiceNo is required")
+	}
+	if strings.TrimSpace(h.CompanyCode) == "" {
+		return errors.New("companyCode is required")
+	}
+	if strings.TrimSpace(h.SupplierId) == "" || strings.TrimSpace(h.SupplierName) == "" {
+		return errors.New("supplierId and supplierName are required")
+	}
+	if _, err := time.Parse("2006-01-02", h.PurchaseInvoiceDate); err != nil {
+		return errors.New("purchaseInvoiceDate must be YYYY-MM-DD")
+	}
+	if h.DueDate != "" {
+		if _, err := time.Parse("2006-01-02", h.DueDate); err != nil {
+			return errors.New("dueDate must be YYYY-MM-DD")
+		}
+	}
+	for i, d := range h.Details {
+		if d.ProductID == "" {
+			return errors.New("details[" + strconv.Itoa(i) + "].productId is required")
+		}
+	}
+	return nil
+}
+
+func (c PurchaseInvoiceController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var body models.PurchaseInvoiceHeader
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validatePurchaseInvoice(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.CompanyCode = strings.TrimSpace(body.CompanyCode)
+		if !checkCompany(w, r, body.CompanyCode) {
+			return
+		}
+		override, ok := checkPurchasePeriods(w, r, c.DB, body)
+		if !ok {
+			return
+		}
+
+		// IDs and status are owned by this service
+		body.PurchaseInvoiceHeaderID = 0
+		body.PurchaseInvoiceStatus = models.PurchaseInvoiceStatusPending
+		for i := range body.Details {
+			body.Details[i].PurchaseInvoiceDetailID = 0
+			body.Details[i].PurchaseInvoiceHeader = nil
+		}
+		for i := range body.Taxes {
+			body.Taxes[i].PurchaseInvoiceTaxID = 0
+			body.Taxes[i].PurchaseInvoiceHeader = nil
+		}
+		if body.TotalAmount == 0 {
+			body.TotalAmount = services.PurchaseInvoiceTotal(body)
+		}
+		if body.PaymentStatus == "" {
+			body.PaymentStatus = models.PaymentStatusCredit
+		}
+		if body.PaymentStatus != models.PaymentStatusCash && body.PaymentStatus != models.PaymentStatusCredit {
+			http.Error(w, "paymentStatus must be CASH or CREDIT", http.StatusBadRequest)
+			return
+		}
+
+		// Details and taxes are saved through the has-many associations
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&body).Error; err != nil {
+				return err
+			}
+			key := strconv.FormatInt(body.PurchaseInvoiceHeaderID, 10)
+			if err := audit(tx, r, models.AuditEntityPurchaseInvoice, key, body.CompanyCode, models.AuditActionCreate, nil, body); err != nil {
+				return err
+			}
+			return override.record(tx, r, models.AuditEntityPurchaseInvoice, models.AuditActionCreate, key)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status":                  "ok",
+			"purchaseInvoiceHeaderId": body.PurchaseInvoiceHeaderID,
+			"purchaseInvoiceNo":       body.PurchaseInvoiceNo,
+			"totalDetails":            len(body.Details),
+		})
+	case http.MethodGet:
+		q := r.URL.Query()
+		db := c.DB.Model(&models.PurchaseInvoiceHeader{}).Scopes(companyScope(r, "company_code"))
+
+		if v := q.Get("status"); v != "" {
+			db = db.Where("purchase_invoice_status = ?", strings.ToUpper(v))
+		}
+		if v := q.Get("companyCode"); v != "" {
+			db = db.Where("company_code = ?", v)
+		}
+		if v := q.Get("supplierId"); v != "" {
+			db = db.Where("supplier_id = ?", v)
+		}
+		if v := q.Get("purchaseInvoiceNo"); v != "" {
+			db = db.Where("purchase_invoice_no LIKE ?", "%"+v+"%")
+		}
+		if v := q.Get("startDate"); v != "" {
+			db = db.Where("purchase_invoice_date >= ?", v)
+		}
+		if v := q.Get("endDate"); v != "" {
+			db = db.Where("purchase_invoice_date <= ?", v)
+		}
+		if v := q.Get("excludeFullyPaid"); v == "1" || strings.EqualFold(v, "true") {
+			db = db.Where("total_amount > " + services.PurchasePaidSubquery)
+		}
+
+		var total int64
+		if err := db.Count(&total).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		lim := 50
+		off := 0
+		if v := q.Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 500 {
+				lim = n
+			}
+		}
+		if v := q.Get("offset"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				off = n
+			}
+		}
+
+		var items []models.PurchaseInvoiceHeader
+		if err := db.Select("purchase_invoice_headers.*, " + services.PurchasePaidSubquery + " AS paid_amount").
+			Order("purchase_invoice_date DESC, purchase_invoice_header_id DESC").
+			Limit(lim).Offset(off).
+			Find(&items).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		hasNext := int64(off+lim) < total
+		nextOffset := off + lim
+		if !hasNext {
+			nextOffset = off
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"items": items,
+			"pagination": map[string]any{
+				"total": total, "limit": lim, "offset": off, "hasNext": hasNext, "nextOffset": nextOffset,
+			},
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func purchaseInvoiceID(path, suffix string) (int64, bool) {
+	s := strings.TrimSuffix(strings.TrimPrefix(path, "/purchase-invoices/"), suffix)
+	id, err := strconv.ParseInt(s, 10, 64)
+	return id, err == nil && id > 0
+}
+
+func (c PurchaseInvoiceController) GetByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := purchaseInvoiceID(r.URL.Path, "")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var inv models.PurchaseInvoiceHeader
+	err := c.DB.Select("purchase_invoice_headers.*, "+services.PurchasePaidSubquery+" AS paid_amount").
+		Preload("Details").
+		Preload("Taxes").
+		Scopes(companyScope(r, "company_code")).
+		First(&inv, "purchase_invoice_header_id = ?", id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(inv)
+}
+
+// UpdateStatus applies a manual status change such as PENDING -> VERIFIED.
+func (c PurchaseInvoiceController) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := purchaseInvoiceID(r.URL.Path, "/status")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Status models.PurchaseInvoiceStatusType `json:"status"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.Status = models.PurchaseInvoiceStatusType(strings.ToUpper(string(body.Status)))
+
+	var current models.PurchaseInvoiceHeader
+	if err := c.DB.Scopes(companyScope(r, "company_code")).First(&current, "purchase_invoice_header_id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	override, ok := checkPurchasePeriods(w, r, c.DB, current)
+	if !ok {
+		return
+	}
+
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		var inv models.PurchaseInvoiceHeader
+		if err := tx.Scopes(companyScope(r, "company_code")).First(&inv, "purchase_invoice_header_id = ?", id).Error; err != nil {
+			return err
+		}
+		if !services.CanTransitionPurchase(inv.PurchaseInvoiceStatus, body.Status) {
+			return services.ValidationError{Msg: "cannot change status from " + string(inv.PurchaseInvoiceStatus) + " to " + string(body.Status)}
+		}
+		if body.Status == models.PurchaseInvoiceStatusVoid {
+			var links int64
+			if err := tx.Model(&models.BankEntryPurchaseInvoice{}).Where("purchase_invoice_header_id = ?", id).Count(&links).Error; err != nil {
+				return err
+			}
+			if links > 0 {
+				return services.ValidationError{Msg: "purchase invoice has matched payments, release them before voiding"}
+			}
+		}
+		if err := tx.Model(&models.PurchaseInvoiceHeader{}).
+			Where("purchase_invoice_header_id = ?", id).
+			Updates(map[string]any{"purchase_invoice_status": body.Status, "updated_by": actorFrom(r), "updated_at": time.Now().Format("2006-01-02 15:04:05")}).Error; err != nil {
+			return err
+		}
+		var after models.PurchaseInvoiceHeader
+		if err := tx.First(&after, "purchase_invoice_header_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := audit(tx, r, models.AuditEntityPurchaseInvoice, strconv.FormatInt(id, 10), after.CompanyCode, models.AuditActionUpdate, inv, after); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityPurchaseInvoice, models.AuditActionUpdate, strconv.FormatInt(id, 10))
+	})
+	if err != nil {
+		var verr services.ValidationError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.As(err, &verr):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "purchaseInvoiceHeaderId": id, "purchaseInvoiceStatus": body.Status})
+}
+
+type purchaseReconcilePayload struct {
+	Invoices []struct {
+		ID     int64   `json:"id"`
+		Amount float64 `json:"amount"`
+	} `json:"invoices"`
+	Note string `json:"note"`
+	Mode string `json:"mode"`
+}
+
+// ReconcilePurchase links a DB bank entry to the purchase invoices it pays.
+func (c BankEntryController) ReconcilePurchase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/bank-entries/")
+	id = strings.TrimSuffix(id, "/purchase-invoices")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !checkVisible(w, r, c.DB, &models.BankEntry{}, "id = ?", id) {
+		return
+	}
+
+	var p purchaseReconcilePayload
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lines := make([]services.PurchaseLine, 0, len(p.Invoices))
+	for _, inv := range p.Invoices {
+		lines = append(lines, services.PurchaseLine{InvoiceID: inv.ID, Amount: inv.Amount})
+	}
+	replace := strings.EqualFold(p.Mode, "replace") || p.Mode == ""
+	// replacing existing links un-reconciles them
+	if replace && !auth.Allowed(r, models.RoleSupervisor) {
+		var linked int64
+		if err := c.DB.Model(&models.BankEntryPurchaseInvoice{}).Where("bank_entry_id = ?", id).Count(&linked).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if linked > 0 {
+			auth.Deny(w, r, models.RoleSupervisor)
+			return
+		}
+	}
+
+	ids := []int64{0}
+	for _, l := range lines {
+		ids = append(ids, l.InvoiceID)
+	}
+
+	var created []models.BankEntryPurchaseInvoice
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		override, err := lockLinkPeriods(tx, r, id, nil, false)
+		if err != nil {
+			return err
+		}
+		// the purchase invoices linked, and in replace mode those unlinked
+		q := tx.Select("purchase_invoice_header_id", "company_code", "purchase_invoice_date").Where("purchase_invoice_header_id IN ?", ids)
+		if replace {
+			q = q.Or("purchase_invoice_header_id IN (?)", tx.Model(&models.BankEntryPurchaseInvoice{}).Select("purchase_invoice_header_id").Where("bank_entry_id = ?", id))
+		}
+		var invoices []models.PurchaseInvoiceHeader
+		if err := q.Find(&invoices).Error; err != nil {
+			return err
+		}
+		more, err := lockPurchasePeriods(tx, r, invoices...)
+		if err != nil {
+			return err
+		}
+		override = override.and(more)
+		if created, err = services.ReconcilePurchaseEntry(tx, id, lines, p.Note, replace, actorFrom(r)); err != nil {
+			return err
+		}
+		return override.record(tx, r, models.AuditEntityBankEntry, models.AuditActionReconcile, id)
+	})
+	if err != nil {
+		writeEntryError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	unallocated, err := c.unallocatedAmount(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"inserted": len(created), "unallocatedAmount": unallocated})
+}
+
+func (c BankEntryController) ListAttachedPurchaseInvoices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/bank-entries/")
+	id = strings.TrimSuffix(id, "/purchase-invoices")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !checkVisible(w, r, c.DB, &models.BankEntry{}, "id = ?", id) {
+		return
+	}
+
+	type Result struct {
+		PurchaseInvoiceHeaderID int64
+		PurchaseInvoiceNo       string
+		PurchaseInvoiceDate     string
+		SupplierName            string
+		PurchaseInvoiceStatus   string
+		TotalAmount             float64
+		MatchedAmount           float64
+		PaidAmount              float64
+	}
+	var results []Result
+	err := c.DB.Table("bank_entry_purchase_invoices bepi").
+		Select("pih.purchase_invoice_header_id, pih.purchase_invoice_no, pih.purchase_invoice_date, pih.supplier_name, pih.purchase_invoice_status, pih.total_amount, bepi.matched_amount, (SELECT COALESCE(SUM(x.matched_amount), 0) FROM bank_entry_purchase_invoices x WHERE x.purchase_invoice_header_id = pih.purchase_invoice_header_id) AS paid_amount").
+		Joins("JOIN purchase_invoice_headers pih ON pih.purchase_invoice_header_id = bepi.purchase_invoice_header_id").
+		Where("bepi.bank_entry_id = ?", id).
+		Scan(&results).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]map[string]any, 0, len(results))
+	for _, m := range results {
+		list = append(list, map[string]any{
+			"id":                    m.PurchaseInvoiceHeaderID,
+			"purchaseInvoiceNo":     m.PurchaseInvoiceNo,
+			"purchaseInvoiceDate":   m.PurchaseInvoiceDate,
+			"supplierName":          m.SupplierName,
+			"purchaseInvoiceStatus": m.PurchaseInvoiceStatus,
+			"totalAmount":           m.TotalAmount,
+			"matchedAmount":         m.MatchedAmount,
+			"paidAmount":            m.PaidAmount,
+			"outstanding":           math.Round((m.TotalAmount-m.PaidAmount)*100) / 100,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
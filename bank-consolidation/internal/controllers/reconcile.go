@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ReconcileController struct{ DB *gorm.DB }
+
+// actorFrom names the authenticated caller for history records.
+func actorFrom(r *http.Request) string {
+	if p, ok := auth.FromRequest(r); ok {
+		return p.Actor()
+	}
+	return "anonymous"
+}
+
+type autoReconcilePayload struct {
+	BankAccountID string   `json:"bankAccountId"`
+	BankCode      string   `json:"bankCode"`
+	Month         string   `json:"month"`
+	StartMonth    string   `json:"startMonth"`
+	EndMonth      string   `json:"endMonth"`
+	Rules         []string `json:"rules"`
+	DryRun        bool     `json:"dryRun"`
+}
+
+// Auto runs the deterministic auto-reconcile rules over the CR entries of a
+// bank account (or of every account of bankCode) in a month range.
+// dryRun=true (body or query) only returns the report.
+func (c ReconcileController) Auto(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var p autoReconcilePayload
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := r.URL.Query().Get("dryRun"); v == "1" || strings.EqualFold(v, "true") {
+		p.DryRun = true
+	}
+	if strings.TrimSpace(p.BankAccountID) == "" && strings.TrimSpace(p.BankCode) == "" {
+		http.Error(w, "bankAccountId or bankCode is required", http.StatusBadRequest)
+		return
+	}
+	if p.Month != "" {
+		p.StartMonth, p.EndMonth = p.Month, p.Month
+	}
+	if p.EndMonth == "" {
+		p.EndMonth = p.StartMonth
+	}
+	start, err := time.Parse("2006-01", p.StartMonth)
+	if err != nil {
+		http.Error(w, "invalid month/startMonth, expected YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse("2006-01", p.EndMonth)
+	if err != nil || end.Before(start) {
+		http.Error(w, "invalid endMonth, expected YYYY-MM not before startMonth", http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidateRules(p.Rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id := strings.TrimSpace(p.BankAccountID); id != "" {
+		acc, err := services.ResolveBankAccount(c.DB, id, strings.TrimSpace(p.BankCode))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !checkCompany(w, r, acc.CompanyCode) {
+			return
+		}
+	}
+
+	rep, err := services.AutoReconcile(c.DB, services.AutoOptions{
+		BankAccountID: strings.TrimSpace(p.BankAccountID),
+		BankCode:      strings.TrimSpace(p.BankCode),
+		Companies:     auth.Companies(r),
+		From:          start,
+		To:            end.AddDate(0, 1, 0),
+		Rules:         p.Rules,
+		DryRun:        p.DryRun,
+		Actor:         actorFrom(r),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rep)
+}
+
+// History lists bank entry/invoice link events of the caller's companies,
+// newest first, filtered by bankEntryId and/or invoiceId.
+func (c ReconcileController) History(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	db := c.DB.Model(&models.BankEntryInvoiceEvent{})
+	if auth.Companies(r) != nil {
+		db = db.Where("bank_entry_id IN (?)", c.DB.Unscoped().Model(&models.BankEntry{}).Select("id").Scopes(companyScope(r, "company_code")))
+	}
+	if v := q.Get("bankEntryId"); v != "" {
+		db = db.Where("bank_entry_id = ?", v)
+	}
+	if v := q.Get("invoiceId"); v != "" {
+		db = db.Where("invoice_header_id = ?", v)
+	}
+	if v := q.Get("actor"); v != "" {
+		db = db.Where("actor = ?", v)
+	}
+
+	lim := 100
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
+			lim = n
+		}
+	}
+
+	var list []models.BankEntryInvoiceEvent
+	if err := db.Order("created_at DESC, id DESC").Limit(lim).Find(&list).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
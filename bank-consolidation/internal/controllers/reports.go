@@ -1,8 +1,15 @@
 package controllers
 
 import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
+	"bank-consolidation/models"
 	"encoding/json"
+	"math"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -13,7 +20,7 @@ type ReportsController struct {
 
 func (c ReportsController) GetInvoices(w http.ResponseWriter, r *http.Request) {
 	var list []map[string]any
-	if err := c.DB.Table("v_invoice_summary").Order("invoice_date DESC").Find(&list).Error; err != nil {
+	if err := c.DB.Table("v_invoice_summary").Scopes(companyScope(r, "company_code")).Order("invoice_date DESC").Find(&list).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -21,15 +28,18 @@ func (c ReportsController) GetInvoices(w http.ResponseWriter, r *http.Request) {
 	var responseList []map[string]any
 	for _, item := range list {
 		responseList = append(responseList, map[string]any{
-			"headerId":     item["header_id"],
-			"invoiceNo":    item["invoice_no"],
-			"invoiceDate":  item["invoice_date"],
-			"customerId":   item["customer_id"],
-			"customerName": item["customer_name"],
-			"status":       item["status"],
-			"totalAmount":  item["total_amount"],
-			"totalTax":     item["total_tax"],
-			"companyCode":  item["company_code"],
+			"headerId":       item["header_id"],
+			"invoiceNo":      item["invoice_no"],
+			"invoiceDate":    item["invoice_date"],
+			"dueDate":        item["due_date"],
+			"customerId":     item["customer_id"],
+			"customerName":   item["customer_name"],
+			"status":         item["status"],
+			"currency":       item["currency"],
+			"totalAmount":    item["total_amount"],
+			"totalTax":       item["total_tax"],
+			"creditedAmount": item["credited_amount"],
+			"companyCode":    item["company_code"],
 		})
 	}
 
@@ -39,7 +49,7 @@ func (c ReportsController) GetInvoices(w http.ResponseWriter, r *http.Request) {
 
 func (c ReportsController) GetTransactionCategories(w http.ResponseWriter, r *http.Request) {
 	var list []map[string]any
-	if err := c.DB.Table("v_transaction_category_summary").Order("transaction_id").Find(&list).Error; err != nil {
+	if err := c.DB.Table("v_transaction_category_summary").Scopes(companyScope(r, "company_code")).Order("transaction_id").Find(&list).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -50,6 +60,7 @@ func (c ReportsController) GetTransactionCategories(w http.ResponseWriter, r *ht
 			"transactionId":    item["transaction_id"],
 			"importSource":     item["import_source"],
 			"validationStatus": item["validation_status"],
+			"companyCode":      item["company_code"],
 			"categoryId":       item["category_id"],
 			"categoryType":     item["category_type"],
 			"categoryName":     item["category_name"],
@@ -59,3 +70,292 @@ func (c ReportsController) GetTransactionCategories(w http.ResponseWriter, r *ht
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(responseList)
 }
+
+type agingBuckets struct {
+	Current    float64 `json:"current"`
+	Days1To30  float64 `json:"days1To30"`
+	Days31To60 float64 `json:"days31To60"`
+	Days61To90 float64 `json:"days61To90"`
+	Over90     float64 `json:"over90"`
+	Total      float64 `json:"total"`
+}
+
+func (b *agingBuckets) add(daysPastDue int, amount float64) {
+	switch {
+	case daysPastDue <= 0:
+		b.Current += amount
+	case daysPastDue <= 30:
+		b.Days1To30 += amount
+	case daysPastDue <= 60:
+		b.Days31To60 += amount
+	case daysPastDue <= 90:
+		b.Days61To90 += amount
+	default:
+		b.Over90 += amount
+	}
+	b.Total += amount
+}
+
+func (b *agingBuckets) round() {
+	for _, v := range []*float64{&b.Current, &b.Days1To30, &b.Days31To60, &b.Days61To90, &b.Over90, &b.Total} {
+		*v = math.Round(*v*100) / 100
+	}
+}
+
+type agingCustomer struct {
+	CompanyCode  string `json:"companyCode"`
+	CustomerID   string `json:"customerId"`
+	CustomerName string `json:"customerName"`
+	Invoices     int    `json:"invoices"`
+	agingBuckets
+}
+
+type agingCompany struct {
+	CompanyCode string `json:"companyCode"`
+	agingBuckets
+}
+
+// GetARAging buckets outstanding receivables (total minus payments matched
+// up to asOf) by days past due, per customer and per company. Amounts are in
+// the invoices' currency, so one currency (default IDR) is reported at a time.
+func (c ReportsController) GetARAging(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	asOf := time.Now()
+	if v := q.Get("asOf"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "invalid asOf, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		asOf = t
+	}
+	asOfDay := time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.Local)
+	nextDay := asOfDay.AddDate(0, 0, 1)
+	currency, err := services.NormalizeCurrency(q.Get("currency"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type row struct {
+		ID           string
+		CompanyCode  string
+		CustomerID   string
+		CustomerName string
+		InvoiceDate  time.Time
+		DueDate      *time.Time
+		TotalAmount  float64
+		PaidAmount   float64
+		Credited     float64
+	}
+	db := c.DB.Table("invoice_headers ih").
+		Select(`ih.id, ih.company_code, ih.customer_id, ih.customer_name, ih.invoice_date, ih.due_date, ih.total_amount,
+			COALESCE((SELECT SUM(bei.matched_amount) FROM bank_entry_invoices bei
+				JOIN bank_entries be ON be.id = bei.bank_entry_id
+				WHERE bei.invoice_header_id = ih.id AND be.transaction_date < ?), 0) AS paid_amount,
+			COALESCE((SELECT SUM(cn.amount) FROM credit_notes cn
+				WHERE cn.invoice_header_id = ih.id AND cn.deleted_at IS NULL AND cn.credit_date < ?), 0) AS credited`, nextDay, nextDay).
+		Where("ih.deleted_at IS NULL AND ih.status <> ? AND ih.invoice_date < ? AND ih.currency = ?", models.InvoiceStatusVoid, nextDay, currency).
+		Scopes(companyScope(r, "ih.company_code"))
+	if v := q.Get("companyCode"); v != "" {
+		db = db.Where("ih.company_code = ?", v)
+	}
+	if v := q.Get("customerId"); v != "" {
+		db = db.Where("ih.customer_id = ?", v)
+	}
+	var rows []row
+	if err := db.Scan(&rows).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	customers := map[[2]string]*agingCustomer{}
+	companies := map[string]*agingCompany{}
+	var total agingBuckets
+	for _, rw := range rows {
+		outstanding := rw.TotalAmount - rw.PaidAmount - rw.Credited
+		if outstanding <= 0.005 {
+			continue
+		}
+		due := rw.InvoiceDate
+		if rw.DueDate != nil {
+			due = *rw.DueDate
+		}
+		dueDay := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.Local)
+		days := int(math.Round(asOfDay.Sub(dueDay).Hours() / 24))
+
+		key := [2]string{rw.CompanyCode, rw.CustomerID}
+		cu, ok := customers[key]
+		if !ok {
+			cu = &agingCustomer{CompanyCode: rw.CompanyCode, CustomerID: rw.CustomerID, CustomerName: rw.CustomerName}
+			customers[key] = cu
+		}
+		cu.Invoices++
+		cu.add(days, outstanding)
+
+		co, ok := companies[rw.CompanyCode]
+		if !ok {
+			co = &agingCompany{CompanyCode: rw.CompanyCode}
+			companies[rw.CompanyCode] = co
+		}
+		co.add(days, outstanding)
+		total.add(days, outstanding)
+	}
+
+	custList := make([]agingCustomer, 0, len(customers))
+	for _, cu := range customers {
+		cu.round()
+		custList = append(custList, *cu)
+	}
+	sort.Slice(custList, func(i, j int) bool {
+		if custList[i].CompanyCode != custList[j].CompanyCode {
+			return custList[i].CompanyCode < custList[j].CompanyCode
+		}
+		return custList[i].CustomerID < custList[j].CustomerID
+	})
+	compList := make([]agingCompany, 0, len(companies))
+	for _, co := range companies {
+		co.round()
+		compList = append(compList, *co)
+	}
+	sort.Slice(compList, func(i, j int) bool { return compList[i].CompanyCode < compList[j].CompanyCode })
+	total.round()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"asOf":      asOfDay.Format("2006-01-02"),
+		"currency":  currency,
+		"customers": custList,
+		"companies": compList,
+		"total":     total,
+	})
+}
+
+// GetTrialBalance sums the journal per account for entries dated from
+// startDate (optional) up to asOf (default today), over the caller's
+// companies or only companyCode.
+func (c ReportsController) GetTrialBalance(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var from *time.Time
+	if v := q.Get("startDate"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "invalid startDate, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = &t
+	}
+	asOf := time.Now()
+	if v := q.Get("asOf"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "invalid asOf, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		asOf = t
+	}
+
+	companies := auth.Companies(r)
+	if v := q.Get("companyCode"); v != "" {
+		if !checkCompany(w, r, v) {
+			return
+		}
+		companies = []string{v}
+	}
+
+	tb, err := services.ComputeTrialBalance(c.DB, companies, from, &asOf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"startDate":     formatDate(from),
+		"asOf":          asOf.Format("2006-01-02"),
+		"rows":          tb.Rows,
+		"totalDebit":    tb.TotalDebit,
+		"totalCredit":   tb.TotalCredit,
+		"debitBalance":  tb.DebitBalance,
+		"creditBalance": tb.CreditBalance,
+		"balanced":      tb.Balanced,
+	})
+}
+
+type fxGainLossRow struct {
+	BankEntryID     string    `json:"bankEntryId"`
+	TransactionDate time.Time `json:"transactionDate"`
+	BankAccountID   string    `json:"bankAccountId"`
+	BankCode        string    `json:"bankCode"`
+	EntryCurrency   string    `json:"entryCurrency"`
+	BankAmount      float64   `json:"bankAmount"`
+	InvoiceID       string    `json:"invoiceId"`
+	InvoiceNo       string    `json:"invoiceNo"`
+	InvoiceDate     time.Time `json:"invoiceDate"`
+	InvoiceCurrency string    `json:"invoiceCurrency"`
+	MatchedAmount   float64   `json:"matchedAmount"`
+	Rate            float64   `json:"rate"`
+	BaseAmount      float64   `json:"baseAmount"`
+	FxGainLoss      float64   `json:"fxGainLoss"`
+}
+
+// GetFxGainLoss lists the realised FX gain (positive) or loss of every
+// reconciliation involving a foreign currency, in IDR, for bank entries dated
+// between startDate and endDate. Optional filters: bankAccountId, bankCode
+// and currency (matching either side).
+func (c ReportsController) GetFxGainLoss(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	db := c.DB.Table("bank_entry_invoices bei").
+		Select(`bei.bank_entry_id, be.transaction_date, be.bank_account_id, be.bank_code, be.currency AS entry_currency, bei.bank_amount,
+			ih.id AS invoice_id, ih.invoice_no, ih.invoice_date, ih.currency AS invoice_currency,
+			bei.matched_amount, bei.rate, bei.base_amount, bei.fx_gain_loss`).
+		Joins("JOIN bank_entries be ON be.id = bei.bank_entry_id AND be.deleted_at IS NULL").
+		Joins("JOIN invoice_headers ih ON ih.id = bei.invoice_header_id").
+		Where("(be.currency <> ? OR ih.currency <> ?)", services.BaseCurrency, services.BaseCurrency).
+		Scopes(companyScope(r, "be.company_code"))
+	for _, p := range []struct{ param, cond string }{{"startDate", "be.transaction_date >= ?"}, {"endDate", "be.transaction_date < ?"}} {
+		if v := q.Get(p.param); v != "" {
+			t, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				http.Error(w, "invalid "+p.param+", expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			if p.param == "endDate" {
+				t = t.AddDate(0, 0, 1)
+			}
+			db = db.Where(p.cond, t)
+		}
+	}
+	if v := q.Get("bankAccountId"); v != "" {
+		db = db.Where("be.bank_account_id = ?", v)
+	}
+	if v := q.Get("bankCode"); v != "" {
+		db = db.Where("be.bank_code = ?", v)
+	}
+	if v := q.Get("currency"); v != "" {
+		db = db.Where("(be.currency = ? OR ih.currency = ?)", strings.ToUpper(v), strings.ToUpper(v))
+	}
+
+	rows := []fxGainLossRow{}
+	if err := db.Order("be.transaction_date, bei.bank_entry_id, ih.id").Scan(&rows).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var gain, loss float64
+	for _, rw := range rows {
+		if rw.FxGainLoss > 0 {
+			gain += rw.FxGainLoss
+		} else {
+			loss -= rw.FxGainLoss
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"baseCurrency": services.BaseCurrency,
+		"rows":         rows,
+		"totalGain":    math.Round(gain*100) / 100,
+		"totalLoss":    math.Round(loss*100) / 100,
+		"net":          math.Round((gain-loss)*100) / 100,
+	})
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/auth"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// companyScope limits a query to rows whose column holds one of the
+// caller's companies.
+func companyScope(r *http.Request, column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		list := auth.Companies(r)
+		if list == nil {
+			return db
+		}
+		if len(list) == 0 {
+			return db.Where("1 = 0")
+		}
+		return db.Where(column+" IN ?", list)
+	}
+}
+
+// sharedCompanyScope is companyScope that also keeps rows without a company,
+// which are shared by all companies.
+func sharedCompanyScope(r *http.Request, column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		list := auth.Companies(r)
+		if list == nil {
+			return db
+		}
+		return db.Where(column+" IN ?", append([]string{""}, list...))
+	}
+}
+
+// visible reports whether the row of model matching where exists and belongs
+// to one of the caller's companies. Handlers answer 404 otherwise, so other
+// companies' ids are not disclosed.
+func visible(db *gorm.DB, r *http.Request, model any, where string, args ...any) (bool, error) {
+	var n int64
+	err := db.Model(model).Scopes(companyScope(r, "company_code")).Where(where, args...).Count(&n).Error
+	return n > 0, err
+}
+
+// checkVisible is visible for handlers: it answers 404 or 500 itself and
+// reports whether to go on.
+func checkVisible(w http.ResponseWriter, r *http.Request, db *gorm.DB, model any, where string, args ...any) bool {
+	ok, err := visible(db, r, model, where, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// checkCompany answers 403 unless the caller may write data of company and
+// reports whether to go on.
+func checkCompany(w http.ResponseWriter, r *http.Request, company string) bool {
+	if !auth.AllowedCompany(r, company) {
+		auth.DenyCompany(w, r, company)
+		return false
+	}
+	return true
+}
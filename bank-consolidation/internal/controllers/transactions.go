@@ -1,10 +1,15 @@
 package controllers
 
 import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/services"
 	"bank-consolidation/models"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 
 	"gorm.io/gorm"
@@ -13,6 +18,26 @@ import (
 
 type TransactionController struct{ DB *gorm.DB }
 
+// transactionColumns are the columns of a transaction shown in listings and
+// the audit log, everything but the raw CSV.
+var transactionColumns = []string{"id", "import_source", "company_code", "bank_account_id", "validation_status", "validation_error", "row_count", "error_count", "validated_at", "import_timestamp"}
+
+// transactionSnapshot is a transaction with its categories as recorded in
+// the audit log.
+type transactionSnapshot struct {
+	models.Transaction
+	CategoryIDs []string `json:"categoryIds"`
+}
+
+func loadTransactionSnapshot(tx *gorm.DB, id string) (transactionSnapshot, error) {
+	var s transactionSnapshot
+	if err := tx.Select(transactionColumns).First(&s.Transaction, "id = ?", id).Error; err != nil {
+		return s, err
+	}
+	err := tx.Model(&models.TransactionCategory{}).Where("transaction_id = ?", id).Order("category_id").Pluck("category_id", &s.CategoryIDs).Error
+	return s, err
+}
+
 func (c TransactionController) CreateOrList(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
@@ -26,29 +51,90 @@ func (c TransactionController) CreateOrList(w http.ResponseWriter, r *http.Reque
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		body.CompanyCode = strings.TrimSpace(body.CompanyCode)
 		if body.ID == "" {
 			http.Error(w, "id is required", http.StatusBadRequest)
 			return
 		}
+		// without a companyCode the transaction goes to the caller's only
+		// company
+		if body.CompanyCode == "" {
+			companies := auth.Companies(r)
+			if len(companies) != 1 {
+				http.Error(w, "companyCode is required when you have access to several companies", http.StatusBadRequest)
+				return
+			}
+			body.CompanyCode = companies[0]
+		}
+		if !checkCompany(w, r, body.CompanyCode) {
+			return
+		}
+		// the bank account is optional; without one the transaction posts
+		// to the bank control account
+		if body.BankAccountID = strings.TrimSpace(body.BankAccountID); body.BankAccountID != "" {
+			acc, ok := BankEntryController{DB: c.DB}.bankAccount(w, r, body.BankAccountID, "")
+			if !ok {
+				return
+			}
+			if acc.CompanyCode != body.CompanyCode {
+				http.Error(w, "bank account "+acc.ID+" belongs to company "+acc.CompanyCode+", not "+body.CompanyCode, http.StatusBadRequest)
+				return
+			}
+		}
 
+		// rawCsv carries the file; older clients sent the fields inline and
+		// their body is kept as is (it will not validate)
+		raw := body.RawCSV
+		if strings.TrimSpace(raw) == "" {
+			raw = string(b)
+		}
 		t := models.Transaction{
 			ID:               body.ID,
-			RawCSV:           string(b),
+			RawCSV:           raw,
 			ImportSource:     body.ImportSource,
-			ValidationStatus: "pending",
+			CompanyCode:      body.CompanyCode,
+			BankAccountID:    body.BankAccountID,
+			ValidationStatus: models.TransactionStatusPending,
 		}
 
-		if err := c.DB.Create(&t).Error; err != nil {
+		rs, err := services.LoadRuleSet(c.DB)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var res services.TransactionValidation
+		hits := []services.RuleHit{}
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&t).Error; err != nil {
+				return err
+			}
+			var matched []services.RuleHit
+			var err error
+			res, matched, err = services.ProcessTransaction(tx, rs, t.ID, actorFrom(r))
+			if matched != nil {
+				hits = matched
+			}
+			if err != nil {
+				return err
+			}
+			after, err := loadTransactionSnapshot(tx, t.ID)
+			if err != nil {
+				return err
+			}
+			return audit(tx, r, models.AuditEntityTransaction, t.ID, t.CompanyCode, models.AuditActionCreate, nil, after)
+		})
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": body.ID})
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "id": body.ID, "validation": res, "categories": hits})
 	case http.MethodGet:
 		var list []models.Transaction
-		if err := c.DB.Select("id", "import_source", "validation_status", "import_timestamp").
+		if err := c.DB.Select(transactionColumns).
+			Scopes(companyScope(r, "company_code")).
 			Order("import_timestamp DESC").
 			Limit(100).
 			Find(&list).Error; err != nil {
@@ -80,6 +166,9 @@ func (c TransactionController) MapCategories(w http.ResponseWriter, r *http.Requ
 	}
 	var body struct {
 		CategoryIDs []string `json:"categoryIds"`
+		// Amounts optionally gives the part of the transaction a category
+		// covers, keyed by category id.
+		Amounts map[string]float64 `json:"amounts"`
 	}
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
@@ -87,22 +176,267 @@ func (c TransactionController) MapCategories(w http.ResponseWriter, r *http.Requ
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	for cid, amount := range body.Amounts {
+		if !slices.Contains(body.CategoryIDs, cid) {
+			http.Error(w, "amount given for category "+cid+" which is not in categoryIds", http.StatusBadRequest)
+			return
+		}
+		if amount <= 0 {
+			http.Error(w, "amount of category "+cid+" must be positive", http.StatusBadRequest)
+			return
+		}
+	}
 
+	var posted bool
 	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		var t models.Transaction
+		if err := tx.Select("id", "company_code").Scopes(companyScope(r, "company_code")).First(&t, "id = ?", id).Error; err != nil {
+			return err
+		}
+		// only shared categories and those of the transaction's company apply
 		for _, cid := range body.CategoryIDs {
-			tc := models.TransactionCategory{TransactionID: id, CategoryID: cid}
-			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tc).Error; err != nil {
+			var n int64
+			if err := tx.Model(&models.Category{}).Where("id = ? AND company_code IN ?", cid, []string{"", t.CompanyCode}).Count(&n).Error; err != nil {
 				return err
 			}
+			if n == 0 {
+				return services.ValidationError{Msg: "category " + cid + " does not exist for company " + t.CompanyCode}
+			}
+		}
+		before, err := loadTransactionSnapshot(tx, id)
+		if err != nil {
+			return err
 		}
-		return nil
+		for _, cid := range body.CategoryIDs {
+			tc := models.TransactionCategory{TransactionID: id, CategoryID: cid, AssignedBy: models.AssignedByManual}
+			onConflict := clause.OnConflict{DoNothing: true}
+			if amount, ok := body.Amounts[cid]; ok {
+				tc.Amount = &amount
+				onConflict = clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"amount"})}
+			}
+			if err := tx.Clauses(onConflict).Create(&tc).Error; err != nil {
+				return err
+			}
+		}
+		if posted, err = services.PostTransaction(tx, id, actorFrom(r)); err != nil {
+			return err
+		}
+		after, err := loadTransactionSnapshot(tx, id)
+		if err != nil {
+			return err
+		}
+		return audit(tx, r, models.AuditEntityTransaction, id, t.CompanyCode, models.AuditActionUpdate, before, after)
 	})
 
 	if err != nil {
+		var verr services.ValidationError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.As(err, &verr):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "transactionId": id, "count": len(body.CategoryIDs), "posted": posted})
+}
+
+// Validate re-runs the validation pipeline, e.g. after its schema changed.
+func (c TransactionController) Validate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/transactions/"), "/validate")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !checkVisible(w, r, c.DB, &models.Transaction{}, "id = ?", id) {
+		return
+	}
+	rs, err := services.LoadRuleSet(c.DB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var res services.TransactionValidation
+	hits := []services.RuleHit{}
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		before, err := loadTransactionSnapshot(tx, id)
+		if err != nil {
+			return err
+		}
+		var matched []services.RuleHit
+		res, matched, err = services.ProcessTransaction(tx, rs, id, actorFrom(r))
+		if matched != nil {
+			hits = matched
+		}
+		if err != nil {
+			return err
+		}
+		after, err := loadTransactionSnapshot(tx, id)
+		if err != nil {
+			return err
+		}
+		return audit(tx, r, models.AuditEntityTransaction, id, after.CompanyCode, models.AuditActionUpdate, before, after)
+	})
+	if err != nil {
+		var verr services.ValidationError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.As(err, &verr):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"validation": res, "categories": hits})
+}
+
+// ListRows returns materialised transaction rows of the caller's companies.
+// Filters: transactionId, valid, amountType, reference, startDate and
+// endDate.
+func (c TransactionController) ListRows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	db := c.DB.Model(&models.TransactionRow{}).
+		Where("transaction_id IN (?)", c.DB.Model(&models.Transaction{}).Select("id").Scopes(companyScope(r, "company_code")))
+	if strings.HasPrefix(r.URL.Path, "/transactions/") {
+		db = db.Where("transaction_id = ?", strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/transactions/"), "/rows"))
+	} else if v := q.Get("transactionId"); v != "" {
+		db = db.Where("transaction_id = ?", v)
+	}
+	if v := q.Get("valid"); v != "" {
+		db = db.Where("valid = ?", v == "1" || strings.EqualFold(v, "true"))
+	}
+	if v := q.Get("amountType"); v != "" {
+		db = db.Where("amount_type = ?", strings.ToUpper(v))
+	}
+	if v := q.Get("reference"); v != "" {
+		db = db.Where("reference = ?", v)
+	}
+	if v := q.Get("startDate"); v != "" {
+		db = db.Where("transaction_date >= ?", v)
+	}
+	if v := q.Get("endDate"); v != "" {
+		db = db.Where("transaction_date <= ?", v)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	lim := 100
+	off := 0
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
+			lim = n
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			off = n
+		}
+	}
 
+	var rows []models.TransactionRow
+	if err := db.Order("transaction_id, line").Limit(lim).Offset(off).Find(&rows).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rows == nil {
+		rows = []models.TransactionRow{}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "transactionId": id, "count": len(body.CategoryIDs)})
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"items":      rows,
+		"pagination": map[string]any{"total": total, "limit": lim, "offset": off, "hasNext": int64(off+lim) < total},
+	})
+}
+
+type TransactionSchemaController struct{ DB *gorm.DB }
+
+// CreateOrList upserts (POST) or lists (GET) transaction CSV schemas. The
+// importSource "*" is the fallback for sources without a schema.
+func (c TransactionSchemaController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var body models.TransactionSchema
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body.ImportSource = strings.TrimSpace(body.ImportSource)
+		if body.ImportSource == "" || strings.TrimSpace(body.DateColumn) == "" || strings.TrimSpace(body.DescriptionColumn) == "" {
+			http.Error(w, "importSource, dateColumn and descriptionColumn are required", http.StatusBadRequest)
+			return
+		}
+		if body.AmountColumn == "" && (body.CreditColumn == "" || body.DebitColumn == "") {
+			http.Error(w, "amountColumn or both creditColumn and debitColumn are required", http.StatusBadRequest)
+			return
+		}
+		if body.Delimiter == "" {
+			body.Delimiter = ","
+		}
+		if body.CreditMarker == "" {
+			body.CreditMarker = "CR"
+		}
+		if body.DebitMarker == "" {
+			body.DebitMarker = "DB"
+		}
+		if body.DecimalSeparator == "" {
+			body.DecimalSeparator = "."
+		}
+		if body.DecimalSeparator == body.ThousandSeparator {
+			http.Error(w, "decimalSeparator and thousandSeparator must differ", http.StatusBadRequest)
+			return
+		}
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			var before any
+			var current models.TransactionSchema
+			err := tx.First(&current, "import_source = ?", body.ImportSource).Error
+			switch {
+			case err == nil:
+				before = current
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				return err
+			}
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&body).Error; err != nil {
+				return err
+			}
+			return auditSave(tx, r, models.AuditEntityTransactionSchema, body.ImportSource, "", before, body)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(body)
+	case http.MethodGet:
+		var list []models.TransactionSchema
+		if err := c.DB.Order("import_source").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
 }
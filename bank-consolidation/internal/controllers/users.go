@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserController struct{ DB *gorm.DB }
+
+type userPayload struct {
+	Username    string  `json:"username"`
+	DisplayName *string `json:"displayName"`
+	Password    string  `json:"password"`
+	Role        string  `json:"role"`
+	Companies   *string `json:"companies"`
+	IsActive    *bool   `json:"isActive"`
+}
+
+// minPasswordLength is the shortest password accepted for a user.
+const minPasswordLength = 8
+
+// CreateOrList creates or updates (POST, by username) or lists (GET) users.
+// On update an empty password or role and an omitted displayName keep the
+// current one; new users are viewers unless a role is given. companies is a
+// comma-separated list of company codes or "*" for all; omitted, it keeps
+// the current list (all companies for new users).
+func (c UserController) CreateOrList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var p userPayload
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.Username = strings.TrimSpace(p.Username)
+		if p.Username == "" {
+			http.Error(w, "username is required", http.StatusBadRequest)
+			return
+		}
+		p.Role = strings.ToLower(strings.TrimSpace(p.Role))
+		if p.Role != "" && !auth.ValidRole(p.Role) {
+			http.Error(w, "role must be one of viewer, staff, supervisor, admin", http.StatusBadRequest)
+			return
+		}
+		if p.Password != "" && len(p.Password) < minPasswordLength {
+			http.Error(w, "password must be at least "+strconv.Itoa(minPasswordLength)+" characters", http.StatusBadRequest)
+			return
+		}
+
+		var u models.User
+		err := c.DB.First(&u, "username = ?", p.Username).Error
+		created := errors.Is(err, gorm.ErrRecordNotFound)
+		if err != nil && !created {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var before any
+		if created {
+			if p.Password == "" {
+				http.Error(w, "password is required for a new user", http.StatusBadRequest)
+				return
+			}
+			u = models.User{Username: p.Username, Role: models.RoleViewer, Companies: "*", IsActive: true}
+		} else {
+			before = u
+		}
+		if p.Role != "" {
+			u.Role = p.Role
+		}
+		if p.Companies != nil {
+			u.Companies = auth.FormatCompanies(auth.ParseCompanies(*p.Companies))
+		}
+		if p.DisplayName != nil {
+			u.DisplayName = strings.TrimSpace(*p.DisplayName)
+		}
+		if p.IsActive != nil {
+			u.IsActive = *p.IsActive
+		}
+		if p.Password != "" {
+			if u.PasswordHash, err = auth.HashPassword(p.Password); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		// Save skips zero values on create, so an inactive user or an
+		// explicitly empty company list is written explicitly
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&u).Error; err != nil {
+				return err
+			}
+			if !u.IsActive || u.Companies == "" {
+				if err := tx.Model(&u).Select("is_active", "companies").Updates(&u).Error; err != nil {
+					return err
+				}
+			}
+			// the hash is never recorded, only that it changed
+			after := struct {
+				models.User
+				PasswordChanged bool `json:"passwordChanged,omitempty"`
+			}{u, p.Password != ""}
+			return auditSave(tx, r, models.AuditEntityUser, strconv.FormatUint(uint64(u.ID), 10), "", before, after)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_ = json.NewEncoder(w).Encode(u)
+	case http.MethodGet:
+		var list []models.User
+		if err := c.DB.Order("username").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []models.User{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type apiKeyPayload struct {
+	Name      string  `json:"name"`
+	Role      string  `json:"role"`
+	Companies *string `json:"companies"`
+	ExpiresAt string  `json:"expiresAt"`
+}
+
+// APIKeys issues (POST) or lists (GET) API keys. Keys act with role, staff
+// by default, on companies ("*", all, by default). The key is only part of the POST response; it cannot be
+// retrieved later.
+func (c UserController) APIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var p apiKeyPayload
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.Name = strings.TrimSpace(p.Name)
+		if p.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		k := models.APIKey{Name: p.Name, Role: strings.ToLower(strings.TrimSpace(p.Role)), CreatedBy: actorFrom(r)}
+		if k.Role == "" {
+			k.Role = models.RoleStaff
+		}
+		if !auth.ValidRole(k.Role) {
+			http.Error(w, "role must be one of viewer, staff, supervisor, admin", http.StatusBadRequest)
+			return
+		}
+		k.Companies = "*"
+		if p.Companies != nil {
+			k.Companies = auth.FormatCompanies(auth.ParseCompanies(*p.Companies))
+		}
+		if p.ExpiresAt != "" {
+			t, err := time.Parse("2006-01-02", p.ExpiresAt)
+			if err != nil {
+				http.Error(w, "invalid expiresAt, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			k.ExpiresAt = &t
+		}
+		key, hash, err := auth.NewAPIKey()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		k.KeyHash = hash
+		k.Prefix = key[:len(auth.APIKeyPrefix)+6]
+
+		var n int64
+		if err := c.DB.Model(&models.APIKey{}).Where("name = ?", k.Name).Count(&n).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n > 0 {
+			http.Error(w, "an API key named "+k.Name+" already exists", http.StatusConflict)
+			return
+		}
+		err = c.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&k).Error; err != nil {
+				return err
+			}
+			if k.Companies == "" {
+				if err := tx.Model(&k).Update("companies", "").Error; err != nil {
+					return err
+				}
+			}
+			return audit(tx, r, models.AuditEntityAPIKey, strconv.FormatUint(uint64(k.ID), 10), "", models.AuditActionCreate, nil, k)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(struct {
+			models.APIKey
+			Key string `json:"key"`
+		}{k, key})
+	case http.MethodGet:
+		var list []models.APIKey
+		if err := c.DB.Order("name").Find(&list).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []models.APIKey{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// RevokeAPIKey revokes a key; requests using it fail from then on.
+func (c UserController) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/api-keys/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	err = c.DB.Transaction(func(tx *gorm.DB) error {
+		var before models.APIKey
+		if err := tx.First(&before, "id = ? AND revoked_at IS NULL", id).Error; err != nil {
+			return err
+		}
+		after := before
+		now := time.Now()
+		after.RevokedAt = &now
+		if err := tx.Model(&after).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return audit(tx, r, models.AuditEntityAPIKey, strconv.FormatUint(id, 10), "", models.AuditActionUpdate, before, after)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
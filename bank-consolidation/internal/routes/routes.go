@@ -1,81 +1,222 @@
 package routes
 
 import (
+	"bank-consolidation/internal/auth"
+	"bank-consolidation/internal/config"
 	"bank-consolidation/internal/controllers"
+	"bank-consolidation/models"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func Register(db *gorm.DB) *gin.Engine {
+// Register builds the engine. Everything under /api/v1 except the login
+// requires a session token or an API key. Any role may read; writes need
+// staff, deletes, un-reconciling, master data, closing accounting periods
+// and the audit log a supervisor, and user management and the /seed
+// generators (which only exist when cfg.SeedDev is set) an admin. Writes
+// into soft-closed or closed accounting periods are further restricted, see
+// controllers.checkPeriods.
+func Register(db *gorm.DB, cfg config.Config) *gin.Engine {
 	inv := controllers.InvoiceController{DB: db}
 	txc := controllers.TransactionController{DB: db}
 	cat := controllers.CategoryController{DB: db}
 	be := controllers.BankEntryController{DB: db}
 	rpt := controllers.ReportsController{DB: db}
+	bip := controllers.BankImportProfileController{DB: db}
+	rec := controllers.ReconcileController{DB: db}
+	pi := controllers.PurchaseInvoiceController{DB: db}
+	acc := controllers.AccountController{DB: db}
+	tsc := controllers.TransactionSchemaController{DB: db}
+	jnl := controllers.JournalController{DB: db}
+	fx := controllers.ExchangeRateController{DB: db}
+	ba := controllers.BankAccountController{DB: db}
+	usr := controllers.UserController{DB: db}
+	adt := controllers.AuditController{DB: db}
+	prd := controllers.AccountingPeriodController{DB: db}
+	ath := controllers.AuthController{DB: db, Secret: []byte(cfg.JWTSecret), TokenTTL: cfg.TokenTTL}
 
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "http://127.0.0.1:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: false,
 	}))
-	api := r.Group("/api/v1")
+	r.POST("/api/v1/auth/login", func(c *gin.Context) { ath.Login(c.Writer, c.Request) })
 
-	api.POST("/invoices", func(c *gin.Context) { inv.Create(c.Writer, c.Request) })
-	api.POST("/invoices/seed", func(c *gin.Context) { inv.GenerateSample(c.Writer, c.Request) })
+	api := r.Group("/api/v1", auth.Required(db, []byte(cfg.JWTSecret)))
+	staff := api.Group("", auth.Require(models.RoleStaff))
+	supervisor := api.Group("", auth.Require(models.RoleSupervisor))
+	admin := api.Group("", auth.Require(models.RoleAdmin))
+
+	api.GET("/auth/me", func(c *gin.Context) { ath.Me(c.Writer, c.Request) })
+	admin.POST("/users", func(c *gin.Context) { usr.CreateOrList(c.Writer, c.Request) })
+	admin.GET("/users", func(c *gin.Context) { usr.CreateOrList(c.Writer, c.Request) })
+	admin.POST("/api-keys", func(c *gin.Context) { usr.APIKeys(c.Writer, c.Request) })
+	admin.GET("/api-keys", func(c *gin.Context) { usr.APIKeys(c.Writer, c.Request) })
+	admin.DELETE("/api-keys/:id", func(c *gin.Context) {
+		c.Request.URL.Path = "/api-keys/" + c.Param("id")
+		usr.RevokeAPIKey(c.Writer, c.Request)
+	})
+
+	if cfg.SeedDev {
+		admin.POST("/invoices/seed", func(c *gin.Context) { inv.GenerateSample(c.Writer, c.Request) })
+		admin.POST("/bank-entries/seed", func(c *gin.Context) { be.GenerateSample(c.Writer, c.Request) })
+	}
+
+	staff.POST("/invoices", func(c *gin.Context) { inv.Create(c.Writer, c.Request) })
 	api.GET("/invoices", func(c *gin.Context) { inv.CreateOrList(c.Writer, c.Request) })
 	api.GET("/invoices/:id", func(c *gin.Context) {
 		c.Request.URL.Path = "/invoices/" + c.Param("id")
 		inv.GetByID(c.Writer, c.Request)
 	})
+	staff.PUT("/invoices/:id", func(c *gin.Context) {
+		c.Request.URL.Path = "/invoices/" + c.Param("id")
+		inv.Update(c.Writer, c.Request)
+	})
+	staff.POST("/invoices/:id/void", func(c *gin.Context) {
+		c.Request.URL.Path = "/invoices/" + c.Param("id") + "/void"
+		inv.Void(c.Writer, c.Request)
+	})
+	staff.POST("/invoices/:id/credit-notes", func(c *gin.Context) {
+		c.Request.URL.Path = "/invoices/" + c.Param("id") + "/credit-notes"
+		inv.CreditNotes(c.Writer, c.Request)
+	})
+	api.GET("/invoices/:id/credit-notes", func(c *gin.Context) {
+		c.Request.URL.Path = "/invoices/" + c.Param("id") + "/credit-notes"
+		inv.CreditNotes(c.Writer, c.Request)
+	})
 
-	api.POST("/transactions", func(c *gin.Context) { txc.CreateOrList(c.Writer, c.Request) })
+	staff.POST("/transactions", func(c *gin.Context) { txc.CreateOrList(c.Writer, c.Request) })
 	api.GET("/transactions", func(c *gin.Context) { txc.CreateOrList(c.Writer, c.Request) })
-	api.POST("/transactions/:id/categories", func(c *gin.Context) {
+	staff.POST("/transactions/:id/categories", func(c *gin.Context) {
 		c.Request.URL.Path = "/transactions/" + c.Param("id") + "/categories"
 		txc.MapCategories(c.Writer, c.Request)
 	})
+	staff.POST("/transactions/:id/validate", func(c *gin.Context) {
+		c.Request.URL.Path = "/transactions/" + c.Param("id") + "/validate"
+		txc.Validate(c.Writer, c.Request)
+	})
+	api.GET("/transactions/:id/rows", func(c *gin.Context) {
+		c.Request.URL.Path = "/transactions/" + c.Param("id") + "/rows"
+		txc.ListRows(c.Writer, c.Request)
+	})
+	api.GET("/transaction-rows", func(c *gin.Context) { txc.ListRows(c.Writer, c.Request) })
+	supervisor.POST("/transaction-schemas", func(c *gin.Context) { tsc.CreateOrList(c.Writer, c.Request) })
+	api.GET("/transaction-schemas", func(c *gin.Context) { tsc.CreateOrList(c.Writer, c.Request) })
 
-	api.POST("/categories", func(c *gin.Context) { cat.CreateOrList(c.Writer, c.Request) })
+	supervisor.POST("/categories", func(c *gin.Context) { cat.CreateOrList(c.Writer, c.Request) })
 	api.GET("/categories", func(c *gin.Context) { cat.CreateOrList(c.Writer, c.Request) })
+	staff.POST("/categories/:id/rules/test", func(c *gin.Context) {
+		c.Request.URL.Path = "/categories/" + c.Param("id") + "/rules/test"
+		cat.TestRules(c.Writer, c.Request)
+	})
 
 	// Bank entries CRUD
-	api.POST("/bank-entries", func(c *gin.Context) { be.CreateOrList(c.Writer, c.Request) })
-	api.POST("/bank-entries/seed", func(c *gin.Context) { be.GenerateSample(c.Writer, c.Request) })
-	api.POST("/bank-entries/bulk", func(c *gin.Context) { be.BulkCreate(c.Writer, c.Request) })
+	staff.POST("/bank-entries", func(c *gin.Context) { be.CreateOrList(c.Writer, c.Request) })
+	staff.POST("/bank-entries/bulk", func(c *gin.Context) { be.BulkCreate(c.Writer, c.Request) })
+	staff.POST("/bank-entries/import", func(c *gin.Context) { be.Import(c.Writer, c.Request) })
 	api.GET("/bank-entries", func(c *gin.Context) { be.CreateOrList(c.Writer, c.Request) })
+	api.GET("/bank-entries/continuity", func(c *gin.Context) { be.Continuity(c.Writer, c.Request) })
 	api.GET("/bank-entries/:id", func(c *gin.Context) {
 		c.Request.URL.Path = "/bank-entries/" + c.Param("id")
 		be.GetByID(c.Writer, c.Request)
 	})
-	api.PUT("/bank-entries/:id", func(c *gin.Context) {
+	staff.PUT("/bank-entries/:id", func(c *gin.Context) {
 		c.Request.URL.Path = "/bank-entries/" + c.Param("id")
 		be.Update(c.Writer, c.Request)
 	})
-	api.DELETE("/bank-entries/:id", func(c *gin.Context) {
+	supervisor.DELETE("/bank-entries/:id", func(c *gin.Context) {
 		c.Request.URL.Path = "/bank-entries/" + c.Param("id")
 		be.Delete(c.Writer, c.Request)
 	})
-	api.POST("/bank-entries/:id/reconcile", func(c *gin.Context) {
+	staff.POST("/bank-entries/:id/reconcile", func(c *gin.Context) {
 		c.Request.URL.Path = "/bank-entries/" + c.Param("id") + "/reconcile"
 		be.Reconcile(c.Writer, c.Request)
 	})
+	api.GET("/bank-entries/:id/suggestions", func(c *gin.Context) {
+		c.Request.URL.Path = "/bank-entries/" + c.Param("id") + "/suggestions"
+		be.Suggestions(c.Writer, c.Request)
+	})
 	api.GET("/bank-entries/:id/invoices", func(c *gin.Context) {
 		c.Request.URL.Path = "/bank-entries/" + c.Param("id") + "/invoices"
 		be.ListAttachedInvoices(c.Writer, c.Request)
 	})
+	supervisor.DELETE("/bank-entries/:id/invoices/:invoiceId", func(c *gin.Context) {
+		c.Request.URL.Path = "/bank-entries/" + c.Param("id") + "/invoices/" + c.Param("invoiceId")
+		be.DetachInvoice(c.Writer, c.Request)
+	})
+	staff.POST("/bank-entries/:id/purchase-invoices", func(c *gin.Context) {
+		c.Request.URL.Path = "/bank-entries/" + c.Param("id") + "/purchase-invoices"
+		be.ReconcilePurchase(c.Writer, c.Request)
+	})
+	api.GET("/bank-entries/:id/purchase-invoices", func(c *gin.Context) {
+		c.Request.URL.Path = "/bank-entries/" + c.Param("id") + "/purchase-invoices"
+		be.ListAttachedPurchaseInvoices(c.Writer, c.Request)
+	})
+
+	staff.POST("/purchase-invoices", func(c *gin.Context) { pi.CreateOrList(c.Writer, c.Request) })
+	api.GET("/purchase-invoices", func(c *gin.Context) { pi.CreateOrList(c.Writer, c.Request) })
+	api.GET("/purchase-invoices/:id", func(c *gin.Context) {
+		c.Request.URL.Path = "/purchase-invoices/" + c.Param("id")
+		pi.GetByID(c.Writer, c.Request)
+	})
+	staff.PUT("/purchase-invoices/:id/status", func(c *gin.Context) {
+		c.Request.URL.Path = "/purchase-invoices/" + c.Param("id") + "/status"
+		pi.UpdateStatus(c.Writer, c.Request)
+	})
+
+	staff.POST("/reconcile/auto", func(c *gin.Context) { rec.Auto(c.Writer, c.Request) })
+	api.GET("/reconcile/history", func(c *gin.Context) { rec.History(c.Writer, c.Request) })
+	supervisor.GET("/audit", func(c *gin.Context) { adt.List(c.Writer, c.Request) })
+
+	supervisor.POST("/accounting-periods", func(c *gin.Context) { prd.CreateOrList(c.Writer, c.Request) })
+	api.GET("/accounting-periods", func(c *gin.Context) { prd.CreateOrList(c.Writer, c.Request) })
+
+	supervisor.POST("/accounts", func(c *gin.Context) { acc.CreateOrList(c.Writer, c.Request) })
+	api.GET("/accounts", func(c *gin.Context) { acc.CreateOrList(c.Writer, c.Request) })
+	api.GET("/journal-entries", func(c *gin.Context) { jnl.List(c.Writer, c.Request) })
+
+	staff.POST("/exchange-rates", func(c *gin.Context) { fx.CreateOrList(c.Writer, c.Request) })
+	api.GET("/exchange-rates", func(c *gin.Context) { fx.CreateOrList(c.Writer, c.Request) })
+	staff.POST("/exchange-rates/import", func(c *gin.Context) { fx.Import(c.Writer, c.Request) })
+
+	supervisor.POST("/bank-accounts", func(c *gin.Context) { ba.CreateOrList(c.Writer, c.Request) })
+	api.GET("/bank-accounts", func(c *gin.Context) { ba.CreateOrList(c.Writer, c.Request) })
+	api.GET("/bank-accounts/:id", func(c *gin.Context) {
+		c.Request.URL.Path = "/bank-accounts/" + c.Param("id")
+		ba.GetByID(c.Writer, c.Request)
+	})
+
+	supervisor.POST("/bank-import-profiles", func(c *gin.Context) { bip.CreateOrList(c.Writer, c.Request) })
+	api.GET("/bank-import-profiles", func(c *gin.Context) { bip.CreateOrList(c.Writer, c.Request) })
 
 	api.GET("/reports/invoices", func(c *gin.Context) {
 		rpt.GetInvoices(c.Writer, c.Request)
 	})
 
+	api.GET("/reports/ar-aging", func(c *gin.Context) {
+		rpt.GetARAging(c.Writer, c.Request)
+	})
+
+	api.GET("/reports/trial-balance", func(c *gin.Context) {
+		rpt.GetTrialBalance(c.Writer, c.Request)
+	})
+
+	api.GET("/reports/fx-gain-loss", func(c *gin.Context) {
+		rpt.GetFxGainLoss(c.Writer, c.Request)
+	})
+
 	api.GET("/reports/transactions/categories", func(c *gin.Context) {
 		rpt.GetTransactionCategories(c.Writer, c.Request)
 	})
 
+	api.GET("/reports/bank-reconciliation", func(c *gin.Context) {
+		rpt.GetBankReconciliation(c.Writer, c.Request)
+	})
+
 	return r
 }
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"bytes"
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// auditIgnored are fields that change on every write and say nothing about
+// what was changed.
+var auditIgnored = map[string]bool{"updatedAt": true}
+
+// NewAudit builds the audit record of a change to an entity. before is nil
+// for creates and after is nil for deletes; both are stored as their JSON
+// form and compared field by field.
+func NewAudit(actor, entity, id, company, action string, before, after any) (models.AuditLog, error) {
+	if actor == "" {
+		actor = "system"
+	}
+	a := models.AuditLog{Actor: actor, EntityType: entity, EntityID: id, CompanyCode: company, Action: action}
+	var err error
+	if a.Before, err = auditJSON(before); err != nil {
+		return a, err
+	}
+	if a.After, err = auditJSON(after); err != nil {
+		return a, err
+	}
+	a.Changes, err = auditDiff(a.Before, a.After)
+	return a, err
+}
+
+// RecordAudit appends the audit record of a change, normally in the
+// transaction making it.
+func RecordAudit(tx *gorm.DB, actor, entity, id, company, action string, before, after any) error {
+	a, err := NewAudit(actor, entity, id, company, action, before, after)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&a).Error
+}
+
+func auditJSON(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil || bytes.Equal(b, []byte("null")) {
+		return nil, err
+	}
+	return b, nil
+}
+
+// auditDiff lists the top-level fields that differ between two JSON objects.
+// A missing side counts as an object without fields, so a create lists
+// every field with a null "from".
+func auditDiff(before, after json.RawMessage) (json.RawMessage, error) {
+	var b, a map[string]json.RawMessage
+	if len(before) > 0 {
+		if err := json.Unmarshal(before, &b); err != nil {
+			return nil, nil
+		}
+	}
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &a); err != nil {
+			return nil, nil
+		}
+	}
+	type change struct {
+		From json.RawMessage `json:"from"`
+		To   json.RawMessage `json:"to"`
+	}
+	changes := map[string]change{}
+	for k, v := range b {
+		if !auditIgnored[k] && !bytes.Equal(v, a[k]) {
+			changes[k] = change{From: v, To: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, seen := b[k]; !seen && !auditIgnored[k] && string(v) != "null" {
+			changes[k] = change{To: v}
+		}
+	}
+	return json.Marshal(changes)
+}
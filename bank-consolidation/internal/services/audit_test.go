@@ -0,0 +1,43 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAuditDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{"create skips nulls", ``, `{"id":"1","name":"Kas","notes":null}`, `{"id":{"from":null,"to":"1"},"name":{"from":null,"to":"Kas"}}`},
+		{"delete", `{"id":"1","name":"Kas"}`, ``, `{"id":{"from":"1","to":null},"name":{"from":"Kas","to":null}}`},
+		{"update lists changed fields only", `{"id":"1","name":"Kas","amount":10}`, `{"id":"1","name":"Bank","amount":10}`, `{"name":{"from":"Kas","to":"Bank"}}`},
+		{"updatedAt ignored", `{"id":"1","updatedAt":"2025-01-01"}`, `{"id":"1","updatedAt":"2025-01-02"}`, `{}`},
+		{"added field", `{"id":"1"}`, `{"id":"1","branch":"01"}`, `{"branch":{"from":null,"to":"01"}}`},
+		{"not an object", `[1]`, `{"id":"1"}`, `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auditDiff(json.RawMessage(tt.before), json.RawMessage(tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil {
+				got = json.RawMessage("null")
+			}
+			var g, w any
+			if err := json.Unmarshal(got, &g); err != nil {
+				t.Fatalf("unmarshal %s: %v", got, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &w); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g, w) {
+				t.Errorf("auditDiff = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EntryFingerprint identifies a bank entry for deduplication. It covers the
+// bank account rather than the bank, so the same line on two accounts at one
+// bank is kept twice. It is the only fingerprint helper: imports, manual
+// and bulk entries and the backfill all go through it.
+func EntryFingerprint(e models.BankEntry) string {
+	dtStr := e.TransactionDate.Format("2006-01-02 15:04:05")
+	base := strings.ToLower(strings.TrimSpace(dtStr)) + "|" + strings.ToLower(strings.TrimSpace(e.Description)) + "|" + strings.TrimSpace(e.Branch) + "|" + fmt.Sprintf("%.2f", e.Amount) + "|" + strings.TrimSpace(e.AmountType) + "|" + strings.TrimSpace(e.BankAccountID)
+	h := sha256.Sum256([]byte(base))
+	return hex.EncodeToString(h[:])
+}
+
+// ResolveBankAccount returns the active account that entries are booked to:
+// the one with id when given, otherwise the only active account of bankCode.
+func ResolveBankAccount(db *gorm.DB, id, bankCode string) (models.BankAccount, error) {
+	var acc models.BankAccount
+	id, bankCode = strings.TrimSpace(id), strings.TrimSpace(bankCode)
+	switch {
+	case id != "":
+		err := db.First(&acc, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return acc, invalidf("bank account %s not found", id)
+		}
+		if err != nil {
+			return acc, err
+		}
+		if bankCode != "" && !strings.EqualFold(bankCode, acc.BankCode) {
+			return acc, invalidf("bank account %s belongs to bank %s, not %s", id, acc.BankCode, bankCode)
+		}
+	case bankCode != "":
+		var list []models.BankAccount
+		if err := db.Where("bank_code = ? AND is_active = ?", bankCode, true).Limit(2).Find(&list).Error; err != nil {
+			return acc, err
+		}
+		switch len(list) {
+		case 0:
+			return acc, invalidf("no active bank account for bankCode %s", bankCode)
+		case 1:
+			acc = list[0]
+		default:
+			return acc, invalidf("bankCode %s has several accounts, give bankAccountId", bankCode)
+		}
+	default:
+		return acc, invalidf("bankAccountId or bankCode is required")
+	}
+	if !acc.IsActive {
+		return acc, invalidf("bank account %s is inactive", acc.ID)
+	}
+	return acc, nil
+}
+
+// BookToAccount fills the account fields of e, including the company, and
+// recomputes its fingerprint. An entry currency other than the account's is rejected.
+func BookToAccount(e *models.BankEntry, acc models.BankAccount) error {
+	if cur := strings.TrimSpace(e.Currency); cur != "" && !strings.EqualFold(cur, acc.Currency) {
+		return invalidf("currency %s does not match bank account %s (%s)", strings.ToUpper(cur), acc.ID, acc.Currency)
+	}
+	e.BankAccountID = acc.ID
+	e.BankCode = acc.BankCode
+	e.Currency = acc.Currency
+	e.CompanyCode = acc.CompanyCode
+	e.Fingerprint = EntryFingerprint(*e)
+	return nil
+}
+
+// bankGLAccount is the ledger account for the bank side of postings to a
+// bank account: its GLAccount when set, else the bank control account.
+func bankGLAccount(tx *gorm.DB, bankAccountID string) (string, error) {
+	if bankAccountID != "" {
+		var acc models.BankAccount
+		if err := tx.Unscoped().Select("id", "gl_account").First(&acc, "id = ?", bankAccountID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", err
+		}
+		if acc.GLAccount != "" {
+			return acc.GLAccount, CheckAccount(tx, acc.GLAccount)
+		}
+	}
+	return ControlAccount(tx, models.AccountRoleBank)
+}
+
+// BackfillBankAccounts books entries that predate bank accounts to one
+// account per bank code, currency and company, creating it when needed, and
+// moves their fingerprints over to the account. Entries without a company
+// take the one of the invoices matched to them, and migrated accounts
+// without a company take the one their entries share, so that they are
+// visible to the users of that company.
+func BackfillBankAccounts(db *gorm.DB) error {
+	if err := db.Exec(`UPDATE bank_entries be JOIN (
+			SELECT l.bank_entry_id, MIN(l.company_code) AS company_code FROM (
+				SELECT bei.bank_entry_id, ih.company_code FROM bank_entry_invoices bei JOIN invoice_headers ih ON ih.id = bei.invoice_header_id
+				UNION ALL
+				SELECT bepi.bank_entry_id, pih.company_code FROM bank_entry_purchase_invoices bepi JOIN purchase_invoice_headers pih ON pih.purchase_invoice_header_id = bepi.purchase_invoice_header_id
+			) l WHERE l.company_code <> '' GROUP BY l.bank_entry_id HAVING COUNT(DISTINCT l.company_code) = 1
+		) x ON x.bank_entry_id = be.id
+		SET be.company_code = x.company_code
+		WHERE be.company_code = '' AND (be.bank_account_id IS NULL OR be.bank_account_id = '' OR be.bank_account_id IN (SELECT id FROM bank_accounts WHERE company_code = ''))`).Error; err != nil {
+		return err
+	}
+
+	var groups []struct {
+		BankCode    string
+		Currency    string
+		CompanyCode string
+	}
+	if err := db.Unscoped().Model(&models.BankEntry{}).
+		Distinct("bank_code", "currency", "company_code").
+		Where("bank_account_id IS NULL OR bank_account_id = ''").
+		Scan(&groups).Error; err != nil {
+		return err
+	}
+	for _, g := range groups {
+		suffix := g.Currency
+		if g.CompanyCode != "" {
+			suffix += "-" + g.CompanyCode
+		}
+		acc := models.BankAccount{
+			ID:            g.BankCode + "-" + suffix,
+			BankCode:      g.BankCode,
+			AccountNumber: "MIGRATED-" + suffix,
+			AccountName:   g.BankCode + " " + g.Currency + " (migrated)",
+			Currency:      g.Currency,
+			CompanyCode:   g.CompanyCode,
+			IsActive:      true,
+		}
+		if err := db.Unscoped().Where(models.BankAccount{ID: acc.ID}).FirstOrCreate(&acc).Error; err != nil {
+			return err
+		}
+		var batch []models.BankEntry
+		err := db.Unscoped().
+			Where("(bank_account_id IS NULL OR bank_account_id = '') AND bank_code = ? AND currency = ? AND company_code = ?", g.BankCode, g.Currency, g.CompanyCode).
+			FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+				for _, e := range batch {
+					e.BankAccountID = acc.ID
+					if err := tx.Unscoped().Model(&models.BankEntry{}).Where("id = ?", e.ID).Updates(map[string]any{
+						"bank_account_id": acc.ID,
+						"fingerprint":     EntryFingerprint(e),
+					}).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.Exec(`UPDATE bank_accounts ba JOIN (
+			SELECT bank_account_id, MIN(company_code) AS company_code FROM bank_entries
+			WHERE company_code <> '' GROUP BY bank_account_id HAVING COUNT(DISTINCT company_code) = 1
+		) x ON x.bank_account_id = ba.id
+		SET ba.company_code = x.company_code
+		WHERE ba.company_code = ''`).Error
+}
+
+type BankAccountSummary struct {
+	models.BankAccount
+	Entries          int64      `json:"entries"`
+	FirstEntryDate   *time.Time `json:"firstEntryDate"`
+	LastEntryDate    *time.Time `json:"lastEntryDate"`
+	TotalCredit      float64    `json:"totalCredit"`
+	TotalDebit       float64    `json:"totalDebit"`
+	BookBalance      float64    `json:"bookBalance"`
+	StatementBalance *float64   `json:"statementBalance"`
+}
+
+// SummarizeBankAccount adds movement totals to an account: BookBalance is
+// the opening balance plus all credits minus all debits and
+// StatementBalance the balance the bank reported on the latest entry.
+func SummarizeBankAccount(db *gorm.DB, acc models.BankAccount) (BankAccountSummary, error) {
+	sum := BankAccountSummary{BankAccount: acc}
+	var agg struct {
+		Entries int64
+		First   *time.Time
+		Last    *time.Time
+		Credit  float64
+		Debit   float64
+	}
+	if err := db.Model(&models.BankEntry{}).
+		Select(`COUNT(1) AS entries, MIN(transaction_date) AS first, MAX(transaction_date) AS last,
+			COALESCE(SUM(CASE WHEN amount_type = 'CR' THEN amount ELSE 0 END), 0) AS credit,
+			COALESCE(SUM(CASE WHEN amount_type = 'DB' THEN amount ELSE 0 END), 0) AS debit`).
+		Where("bank_account_id = ?", acc.ID).
+		Scan(&agg).Error; err != nil {
+		return sum, err
+	}
+	sum.Entries, sum.FirstEntryDate, sum.LastEntryDate = agg.Entries, agg.First, agg.Last
+	sum.TotalCredit, sum.TotalDebit = round2(agg.Credit), round2(agg.Debit)
+	sum.BookBalance = round2(acc.OpeningBalance + agg.Credit - agg.Debit)
+	if agg.Entries > 0 {
+		var last models.BankEntry
+		if err := db.Where("bank_account_id = ?", acc.ID).Order("transaction_date DESC, id DESC").First(&last).Error; err != nil {
+			return sum, err
+		}
+		sum.StatementBalance = &last.Balance
+	}
+	return sum, nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"math"
+	"time"
+)
+
+const balanceTolerance = 0.005
+
+const (
+	IssueBalanceBreak     = "balance_break"
+	IssueOutOfOrder       = "out_of_order"
+	IssueDuplicateBalance = "duplicate_balance"
+)
+
+type ContinuityIssue struct {
+	Type            string    `json:"type"`
+	EntryID         string    `json:"entryId"`
+	PreviousEntryID string    `json:"previousEntryId"`
+	TransactionDate time.Time `json:"transactionDate"`
+	ExpectedBalance float64   `json:"expectedBalance"`
+	ActualBalance   float64   `json:"actualBalance"`
+	Difference      float64   `json:"difference"`
+}
+
+type ContinuityReport struct {
+	Checked        int               `json:"checked"`
+	OK             bool              `json:"ok"`
+	OpeningBalance float64           `json:"openingBalance"`
+	ClosingBalance float64           `json:"closingBalance"`
+	Issues         []ContinuityIssue `json:"issues"`
+}
+
+// nextBalance is the balance expected after applying e on top of prev.
+func nextBalance(prev float64, e models.BankEntry) float64 {
+	if e.AmountType == "DB" {
+		return prev - e.Amount
+	}
+	return prev + e.Amount
+}
+
+func chains(prev, e models.BankEntry) bool {
+	return math.Abs(nextBalance(prev.Balance, e)-e.Balance) < balanceTolerance
+}
+
+// CheckContinuity walks entries (already sorted in booking order) and checks
+// that each balance equals the previous balance plus or minus its amount.
+// When anchor is not nil it is the last entry before the checked range and
+// is only used as the starting balance.
+func CheckContinuity(anchor *models.BankEntry, entries []models.BankEntry) ContinuityReport {
+	rep := ContinuityReport{Checked: len(entries), Issues: []ContinuityIssue{}}
+	if len(entries) == 0 {
+		rep.OK = true
+		if anchor != nil {
+			rep.OpeningBalance = anchor.Balance
+			rep.ClosingBalance = anchor.Balance
+		}
+		return rep
+	}
+
+	list := make([]models.BankEntry, 0, len(entries)+1)
+	if anchor != nil {
+		list = append(list, *anchor)
+		rep.OpeningBalance = anchor.Balance
+	} else {
+		// without an anchor the first entry's own amount is the opening movement
+		rep.OpeningBalance = math.Round((entries[0].Balance-signed(entries[0]))*100) / 100
+	}
+	list = append(list, entries...)
+	rep.ClosingBalance = entries[len(entries)-1].Balance
+
+	for i := 1; i < len(list); i++ {
+		prev, cur := list[i-1], list[i]
+		if chains(prev, cur) {
+			continue
+		}
+		issue := ContinuityIssue{
+			EntryID:         cur.ID,
+			PreviousEntryID: prev.ID,
+			TransactionDate: cur.TransactionDate,
+			ExpectedBalance: math.Round(nextBalance(prev.Balance, cur)*100) / 100,
+			ActualBalance:   cur.Balance,
+		}
+		issue.Difference = math.Round((issue.ActualBalance-issue.ExpectedBalance)*100) / 100
+
+		switch {
+		case cur.Amount != 0 && math.Abs(cur.Balance-prev.Balance) < balanceTolerance:
+			issue.Type = IssueDuplicateBalance
+		case i+1 < len(list) && chains(prev, list[i+1]) && chains(list[i+1], cur):
+			// the next row belongs before this one; report the pair once and
+			// keep walking in the corrected order
+			issue.Type = IssueOutOfOrder
+			rep.Issues = append(rep.Issues, issue)
+			list[i], list[i+1] = list[i+1], list[i]
+			i++
+			continue
+		default:
+			issue.Type = IssueBalanceBreak
+		}
+		rep.Issues = append(rep.Issues, issue)
+	}
+	rep.OK = len(rep.Issues) == 0
+	return rep
+}
+
+func signed(e models.BankEntry) float64 {
+	if e.AmountType == "DB" {
+		return -e.Amount
+	}
+	return e.Amount
+}
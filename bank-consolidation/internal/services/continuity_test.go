@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"testing"
+)
+
+func entry(id, amountType string, amount, balance float64) models.BankEntry {
+	return models.BankEntry{ID: id, AmountType: amountType, Amount: amount, Balance: balance}
+}
+
+func TestCheckContinuity(t *testing.T) {
+	anchor := entry("a0", "CR", 0, 1000)
+	tests := []struct {
+		name           string
+		anchor         *models.BankEntry
+		entries        []models.BankEntry
+		wantOpening    float64
+		wantClosing    float64
+		wantIssueTypes []string
+	}{
+		{
+			name:        "empty with anchor",
+			anchor:      &anchor,
+			wantOpening: 1000,
+			wantClosing: 1000,
+		},
+		{
+			name:        "chained without anchor",
+			entries:     []models.BankEntry{entry("e1", "CR", 500, 1500), entry("e2", "DB", 200, 1300)},
+			wantOpening: 1000,
+			wantClosing: 1300,
+		},
+		{
+			name:        "chained from anchor",
+			anchor:      &anchor,
+			entries:     []models.BankEntry{entry("e1", "DB", 250, 750), entry("e2", "CR", 50.5, 800.5)},
+			wantOpening: 1000,
+			wantClosing: 800.5,
+		},
+		{
+			name:           "balance break",
+			anchor:         &anchor,
+			entries:        []models.BankEntry{entry("e1", "CR", 100, 1100), entry("e2", "CR", 100, 1250)},
+			wantOpening:    1000,
+			wantClosing:    1250,
+			wantIssueTypes: []string{IssueBalanceBreak},
+		},
+		{
+			name:           "out of order pair",
+			anchor:         &anchor,
+			entries:        []models.BankEntry{entry("e2", "DB", 300, 900), entry("e1", "CR", 200, 1200)},
+			wantOpening:    1000,
+			wantClosing:    1200,
+			wantIssueTypes: []string{IssueOutOfOrder},
+		},
+		{
+			name:           "duplicate balance",
+			anchor:         &anchor,
+			entries:        []models.BankEntry{entry("e1", "CR", 100, 1100), entry("e2", "CR", 100, 1100)},
+			wantOpening:    1000,
+			wantClosing:    1100,
+			wantIssueTypes: []string{IssueDuplicateBalance},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep := CheckContinuity(tt.anchor, tt.entries)
+			if rep.Checked != len(tt.entries) {
+				t.Errorf("Checked = %d, want %d", rep.Checked, len(tt.entries))
+			}
+			if rep.OpeningBalance != tt.wantOpening || rep.ClosingBalance != tt.wantClosing {
+				t.Errorf("opening/closing = %v/%v, want %v/%v", rep.OpeningBalance, rep.ClosingBalance, tt.wantOpening, tt.wantClosing)
+			}
+			if rep.OK != (len(tt.wantIssueTypes) == 0) {
+				t.Errorf("OK = %v with issues %+v", rep.OK, rep.Issues)
+			}
+			if len(rep.Issues) != len(tt.wantIssueTypes) {
+				t.Fatalf("issues = %+v, want types %v", rep.Issues, tt.wantIssueTypes)
+			}
+			for i, typ := range tt.wantIssueTypes {
+				if rep.Issues[i].Type != typ {
+					t.Errorf("issue %d type = %q, want %q", i, rep.Issues[i].Type, typ)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckContinuityBreakAmounts(t *testing.T) {
+	anchor := entry("a0", "CR", 0, 1000)
+	rep := CheckContinuity(&anchor, []models.BankEntry{entry("e1", "DB", 100, 880)})
+	if len(rep.Issues) != 1 {
+		t.Fatalf("issues = %+v, want one", rep.Issues)
+	}
+	got := rep.Issues[0]
+	if got.EntryID != "e1" || got.PreviousEntryID != "a0" || got.ExpectedBalance != 900 || got.ActualBalance != 880 || got.Difference != -20 {
+		t.Errorf("issue = %+v", got)
+	}
+}
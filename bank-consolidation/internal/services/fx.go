@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BaseCurrency is the currency of the ledger. Journals, AR aging and FX
+// results are in IDR and exchange rates are IDR per unit.
+const BaseCurrency = "IDR"
+
+// NormalizeCurrency upper-cases an ISO 4217 code; empty means IDR.
+func NormalizeCurrency(s string) (string, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return BaseCurrency, nil
+	}
+	if len(s) != 3 {
+		return "", fmt.Errorf("currency %q must be a 3 letter ISO code", s)
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("currency %q must be a 3 letter ISO code", s)
+		}
+	}
+	return s, nil
+}
+
+// RateOn returns the IDR value of one unit of currency on date, taken from
+// the latest rate dated on or before it. IDR is always 1.
+func RateOn(tx *gorm.DB, currency string, date time.Time) (float64, error) {
+	if currency == BaseCurrency {
+		return 1, nil
+	}
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	var rate models.ExchangeRate
+	err := tx.Where("currency = ? AND rate_date <= ?", currency, day).Order("rate_date DESC").First(&rate).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, invalidf("no %s exchange rate on or before %s", currency, day.Format("2006-01-02"))
+	}
+	return rate.Rate, err
+}
+
+// SaveExchangeRate validates x and inserts it, replacing the rate of the
+// same currency and date.
+func SaveExchangeRate(tx *gorm.DB, x *models.ExchangeRate) error {
+	cur, err := NormalizeCurrency(x.Currency)
+	if err != nil {
+		return invalidf("%s", err.Error())
+	}
+	if cur == BaseCurrency {
+		return invalidf("%s is the base currency, its rate is always 1", BaseCurrency)
+	}
+	if x.RateDate.IsZero() {
+		return invalidf("rateDate is required")
+	}
+	if x.Rate <= 0 {
+		return invalidf("rate for %s on %s must be positive", cur, x.RateDate.Format("2006-01-02"))
+	}
+	x.Currency = cur
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "currency"}, {Name: "rate_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source", "updated_at"}),
+	}).Create(x).Error
+}
+
+// Settlement is how much of a bank entry a payment of an invoice uses and
+// what it is worth in IDR.
+type Settlement struct {
+	BankAmount float64
+	Rate       float64
+	BaseAmount float64
+	FxGainLoss float64
+}
+
+// Settle converts amount, in the invoice's currency, into the entry's
+// currency. Same-currency matches use rate 1. Otherwise bankAmount (what
+// the bank actually credited) wins, then an explicit rate, and finally the
+// exchange-rate table on the entry date. The receivable is valued at the
+// invoice date rate and the receipt at the entry date rate; the difference
+// is the realised FX gain (positive) or loss.
+func Settle(tx *gorm.DB, entry models.BankEntry, invoiceCurrency string, invoiceDate time.Time, amount, bankAmount, rate float64) (Settlement, error) {
+	var s Settlement
+	if rate < 0 || bankAmount < 0 {
+		return s, invalidf("bankAmount and rate must not be negative")
+	}
+	switch {
+	case invoiceCurrency == entry.Currency:
+		if bankAmount > 0 && math.Abs(bankAmount-amount) > 0.005 {
+			return s, invalidf("bankAmount %.2f differs from amount %.2f although bank entry and invoice are both in %s", bankAmount, amount, entry.Currency)
+		}
+		s.BankAmount, s.Rate = amount, 1
+	case bankAmount > 0:
+		if rate > 0 && math.Abs(round2(amount*rate)-bankAmount) > 0.01 {
+			return s, invalidf("bankAmount %.2f does not equal amount %.2f at rate %g", bankAmount, amount, rate)
+		}
+		s.BankAmount, s.Rate = round2(bankAmount), bankAmount/amount
+	case rate > 0:
+		s.BankAmount, s.Rate = round2(amount*rate), rate
+	default:
+		from, err := RateOn(tx, invoiceCurrency, entry.TransactionDate)
+		if err != nil {
+			return s, err
+		}
+		to, err := RateOn(tx, entry.Currency, entry.TransactionDate)
+		if err != nil {
+			return s, err
+		}
+		s.Rate = from / to
+		s.BankAmount = round2(amount * s.Rate)
+	}
+	s.Rate = math.Round(s.Rate*1e8) / 1e8
+
+	booked, err := RateOn(tx, invoiceCurrency, invoiceDate)
+	if err != nil {
+		return s, err
+	}
+	received, err := RateOn(tx, entry.Currency, entry.TransactionDate)
+	if err != nil {
+		return s, err
+	}
+	s.BaseAmount = round2(amount * booked)
+	s.FxGainLoss = round2(s.BankAmount*received - s.BaseAmount)
+	return s, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeCurrency(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "IDR", false},
+		{" usd ", "USD", false},
+		{"sgd", "SGD", false},
+		{"US", "", true},
+		{"US1", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeCurrency(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeCurrency(%q) = %q, %v; want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// Settle only reads exchange rates for foreign currencies, so IDR cases run
+// without a database.
+func TestSettle(t *testing.T) {
+	entry := models.BankEntry{Currency: BaseCurrency, TransactionDate: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)}
+	invoiceDate := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		amount     float64
+		bankAmount float64
+		rate       float64
+		want       Settlement
+		wantErr    bool
+	}{
+		{"same currency", 1500000, 0, 0, Settlement{BankAmount: 1500000, Rate: 1, BaseAmount: 1500000}, false},
+		{"same currency with equal bank amount", 1500000, 1500000, 0, Settlement{BankAmount: 1500000, Rate: 1, BaseAmount: 1500000}, false},
+		{"same currency ignores rate", 1000, 0, 16000, Settlement{BankAmount: 1000, Rate: 1, BaseAmount: 1000}, false},
+		{"same currency bank amount differs", 1500000, 1499000, 0, Settlement{}, true},
+		{"negative rate", 1000, 0, -1, Settlement{}, true},
+		{"negative bank amount", 1000, -1, 0, Settlement{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Settle(nil, entry, BaseCurrency, invoiceDate, tt.amount, tt.bankAmount, tt.rate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				var ve ValidationError
+				if !errors.As(err, &ve) {
+					t.Errorf("error = %T, want ValidationError", err)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Settle = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
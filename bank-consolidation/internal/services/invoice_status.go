@@ -0,0 +1,36 @@
+package services
+
+import (
+	"bank-consolidation/models"
+
+	"gorm.io/gorm"
+)
+
+// invoiceStatusExpr derives an invoice's status from its matched payments,
+// credit notes and due date. Void invoices keep their status.
+var invoiceStatusExpr = `CASE
+	WHEN status = '` + models.InvoiceStatusVoid + `' THEN status
+	WHEN ` + InvoiceSettledExpr + ` >= total_amount - 0.005 THEN '` + models.InvoiceStatusPaid + `'
+	WHEN due_date IS NOT NULL AND due_date < CURDATE() THEN '` + models.InvoiceStatusOverdue + `'
+	WHEN ` + InvoiceSettledExpr + ` > 0 THEN '` + models.InvoiceStatusPartiallyPaid + `'
+	ELSE '` + models.InvoiceStatusPending + `'
+END`
+
+// RefreshInvoiceStatus recomputes the status of the given invoices. Call it
+// in the same transaction that changes their bank_entry_invoices rows.
+func RefreshInvoiceStatus(tx *gorm.DB, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Model(&models.InvoiceHeader{}).
+		Where("id IN ?", ids).
+		Update("status", gorm.Expr(invoiceStatusExpr)).Error
+}
+
+// SweepOverdue moves unpaid invoices whose due date has passed to overdue.
+func SweepOverdue(db *gorm.DB) (int64, error) {
+	res := db.Model(&models.InvoiceHeader{}).
+		Where("status IN ? AND due_date IS NOT NULL AND due_date < CURDATE()", []string{models.InvoiceStatusPending, models.InvoiceStatusPartiallyPaid}).
+		Update("status", gorm.Expr(invoiceStatusExpr))
+	return res.RowsAffected, res.Error
+}
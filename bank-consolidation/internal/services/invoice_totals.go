@@ -0,0 +1,130 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// lineTolerance is how far a client-sent line amount or PPN may differ from
+// the computed value before the request is rejected.
+const lineTolerance = 0.01
+
+// FieldError points at the request field that failed validation.
+type FieldError struct {
+	Field   string  `json:"field"`
+	Message string  `json:"message"`
+	Sent    float64 `json:"sent,omitempty"`
+	Want    float64 `json:"expected,omitempty"`
+}
+
+// FieldErrors collects every field problem of one request.
+type FieldErrors []FieldError
+
+func (fe FieldErrors) Error() string {
+	msgs := make([]string, 0, len(fe))
+	for _, e := range fe {
+		msgs = append(msgs, e.Field+": "+e.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (fe *FieldErrors) add(field, format string, args ...any) {
+	*fe = append(*fe, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (fe *FieldErrors) mismatch(field string, sent, want float64) {
+	*fe = append(*fe, FieldError{
+		Field:   field,
+		Message: fmt.Sprintf("sent %.2f but computed %.2f", sent, want),
+		Sent:    sent,
+		Want:    want,
+	})
+}
+
+// LineDiscount is the total discount of a detail line: Disc and ClaimAbleDisc
+// are percentages of qty × unit price, ClaimAbleDiscAmount (which wins over
+// ClaimAbleDisc when set) and ManualDiscount are absolute amounts.
+func LineDiscount(d models.InvoiceDetail) float64 {
+	gross := d.Qty * d.UnitPrice
+	disc := gross * d.Disc / 100
+	if d.ClaimAbleDiscAmount != 0 {
+		disc += d.ClaimAbleDiscAmount
+	} else {
+		disc += gross * d.ClaimAbleDisc / 100
+	}
+	return round2(disc + d.ManualDiscount)
+}
+
+// ComputeInvoiceTotals recomputes every detail's Amount and Ppn and rolls
+// them up into the header's TotalAmount (including PPN and OtherExpense) and
+// TotalTax. Values the client sent are kept only as a cross-check: a non-zero
+// value that disagrees with the computed one is reported as a FieldErrors.
+func ComputeInvoiceTotals(h *models.InvoiceHeader, details []models.InvoiceDetail) error {
+	var errs FieldErrors
+	var subtotal, tax float64
+
+	for i := range details {
+		d := &details[i]
+		field := fmt.Sprintf("details[%d]", i)
+		if d.Qty <= 0 {
+			errs.add(field+".qty", "must be greater than 0")
+		}
+		if d.UnitPrice < 0 {
+			errs.add(field+".unitPrice", "must not be negative")
+		}
+		if d.PpnPercent < 0 || d.PpnPercent > 100 {
+			errs.add(field+".ppnPercent", "must be between 0 and 100")
+		}
+		if d.Disc < 0 || d.Disc > 100 || d.ClaimAbleDisc < 0 || d.ClaimAbleDisc > 100 {
+			errs.add(field+".disc", "discount percentages must be between 0 and 100")
+		}
+		if d.ClaimAbleDiscAmount < 0 || d.ManualDiscount < 0 {
+			errs.add(field+".manualDiscount", "discount amounts must not be negative")
+		}
+
+		gross := round2(d.Qty * d.UnitPrice)
+		disc := LineDiscount(*d)
+		if disc > gross {
+			errs.add(field+".disc", "discount %.2f exceeds line value %.2f", disc, gross)
+		}
+		amount := round2(gross - disc)
+		ppn := round2(amount * d.PpnPercent / 100)
+
+		if d.Amount != 0 && math.Abs(d.Amount-amount) > lineTolerance {
+			errs.mismatch(field+".amount", d.Amount, amount)
+		}
+		if d.Ppn != 0 && math.Abs(d.Ppn-ppn) > lineTolerance {
+			errs.mismatch(field+".ppn", d.Ppn, ppn)
+		}
+		d.Amount = amount
+		d.Ppn = ppn
+		subtotal += amount
+		tax += ppn
+	}
+
+	if h.OtherExpense < 0 {
+		errs.add("header.otherExpense", "must not be negative")
+	}
+	tax = round2(tax)
+	total := round2(subtotal + tax + h.OtherExpense)
+
+	// clients that compute PPN on the subtotal instead of per line may be off
+	// by half a cent for every line
+	headerTolerance := lineTolerance * float64(len(details))
+	if h.TotalTax != 0 && math.Abs(h.TotalTax-tax) > headerTolerance {
+		errs.mismatch("header.totalTax", h.TotalTax, tax)
+	}
+	if h.TotalAmount != 0 && math.Abs(h.TotalAmount-total) > headerTolerance {
+		errs.mismatch("header.totalAmount", h.TotalAmount, total)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	h.TotalAmount = total
+	h.TotalTax = tax
+	h.TotalProduct = len(details)
+	return nil
+}
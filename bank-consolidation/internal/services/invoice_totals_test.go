@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"testing"
+)
+
+func TestLineDiscount(t *testing.T) {
+	tests := []struct {
+		name string
+		d    models.InvoiceDetail
+		want float64
+	}{
+		{"none", models.InvoiceDetail{Qty: 2, UnitPrice: 100}, 0},
+		{"percentage", models.InvoiceDetail{Qty: 2, UnitPrice: 100, Disc: 10}, 20},
+		{"claimable percentage", models.InvoiceDetail{Qty: 2, UnitPrice: 100, Disc: 10, ClaimAbleDisc: 5}, 30},
+		{"claimable amount wins", models.InvoiceDetail{Qty: 2, UnitPrice: 100, ClaimAbleDisc: 5, ClaimAbleDiscAmount: 3}, 3},
+		{"manual on top", models.InvoiceDetail{Qty: 3, UnitPrice: 33.33, Disc: 10, ManualDiscount: 1.5}, 11.5},
+	}
+	for _, tt := range tests {
+		if got := LineDiscount(tt.d); got != tt.want {
+			t.Errorf("%s: LineDiscount = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestComputeInvoiceTotals(t *testing.T) {
+	lines := func() []models.InvoiceDetail {
+		return []models.InvoiceDetail{
+			{Qty: 2, UnitPrice: 50000, Disc: 10, PpnPercent: 11},
+			{Qty: 1, UnitPrice: 20000, ClaimAbleDisc: 5, ClaimAbleDiscAmount: 500, ManualDiscount: 200},
+		}
+	}
+	tests := []struct {
+		name       string
+		header     models.InvoiceHeader
+		details    func() []models.InvoiceDetail
+		wantTotal  float64
+		wantTax    float64
+		wantFields []string
+	}{
+		{
+			name:      "computed from lines",
+			header:    models.InvoiceHeader{OtherExpense: 5000},
+			details:   lines,
+			wantTotal: 124200,
+			wantTax:   9900,
+		},
+		{
+			name:      "matching client totals accepted",
+			header:    models.InvoiceHeader{OtherExpense: 5000, TotalAmount: 124200.01, TotalTax: 9900},
+			details:   lines,
+			wantTotal: 124200,
+			wantTax:   9900,
+		},
+		{
+			name:       "header mismatch",
+			header:     models.InvoiceHeader{TotalAmount: 100000, TotalTax: 1},
+			details:    lines,
+			wantFields: []string{"header.totalTax", "header.totalAmount"},
+		},
+		{
+			name:   "line mismatch",
+			header: models.InvoiceHeader{},
+			details: func() []models.InvoiceDetail {
+				return []models.InvoiceDetail{{Qty: 1, UnitPrice: 1000, PpnPercent: 11, Amount: 1000, Ppn: 100}}
+			},
+			wantFields: []string{"details[0].ppn"},
+		},
+		{
+			name:   "invalid line values",
+			header: models.InvoiceHeader{OtherExpense: -1},
+			details: func() []models.InvoiceDetail {
+				return []models.InvoiceDetail{{Qty: 0, UnitPrice: -1, PpnPercent: 120, Disc: 101, ManualDiscount: -2}}
+			},
+			wantFields: []string{"details[0].qty", "details[0].unitPrice", "details[0].ppnPercent", "details[0].disc", "details[0].manualDiscount", "header.otherExpense"},
+		},
+		{
+			name:   "discount above line value",
+			header: models.InvoiceHeader{},
+			details: func() []models.InvoiceDetail {
+				return []models.InvoiceDetail{{Qty: 1, UnitPrice: 100, ManualDiscount: 150}}
+			},
+			wantFields: []string{"details[0].disc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.header
+			details := tt.details()
+			err := ComputeInvoiceTotals(&h, details)
+			if len(tt.wantFields) > 0 {
+				var fe FieldErrors
+				if !errors.As(err, &fe) {
+					t.Fatalf("error = %v, want FieldErrors", err)
+				}
+				if len(fe) != len(tt.wantFields) {
+					t.Fatalf("errors = %v, want fields %v", fe, tt.wantFields)
+				}
+				for i, f := range tt.wantFields {
+					if fe[i].Field != f {
+						t.Errorf("error %d field = %q, want %q", i, fe[i].Field, f)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.TotalAmount != tt.wantTotal || h.TotalTax != tt.wantTax || h.TotalProduct != len(details) {
+				t.Errorf("totals = %v/%v/%d, want %v/%v/%d", h.TotalAmount, h.TotalTax, h.TotalProduct, tt.wantTotal, tt.wantTax, len(details))
+			}
+			if details[0].Amount != 90000 || details[0].Ppn != 9900 || details[1].Amount != 19300 || details[1].Ppn != 0 {
+				t.Errorf("line amounts = %+v", details)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InvoiceCreditSubquery sums the credit notes issued against invoice_headers.id.
+const InvoiceCreditSubquery = "(SELECT COALESCE(SUM(amount), 0) FROM credit_notes WHERE invoice_header_id = invoice_headers.id AND deleted_at IS NULL)"
+
+// InvoiceSettledExpr is the part of invoice_headers.total_amount covered by
+// payments and credit notes together.
+const InvoiceSettledExpr = "(" + InvoicePaidSubquery + " + " + InvoiceCreditSubquery + ")"
+
+// lockInvoice loads an invoice for update. A missing invoice is reported as
+// gorm.ErrRecordNotFound.
+func lockInvoice(tx *gorm.DB, id string) (models.InvoiceHeader, error) {
+	var h models.InvoiceHeader
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&h, "id = ?", id).Error
+	return h, err
+}
+
+// invoiceSettlement returns what has been matched from bank entries and
+// what has been credited against an invoice.
+func invoiceSettlement(tx *gorm.DB, id string) (paid, credited float64, err error) {
+	var s struct {
+		Paid     float64
+		Credited float64
+	}
+	err = tx.Raw(`SELECT
+			COALESCE((SELECT SUM(matched_amount) FROM bank_entry_invoices WHERE invoice_header_id = ?), 0) AS paid,
+			COALESCE((SELECT SUM(amount) FROM credit_notes WHERE invoice_header_id = ? AND deleted_at IS NULL), 0) AS credited`, id, id).
+		Scan(&s).Error
+	return s.Paid, s.Credited, err
+}
+
+// invoiceEditableColumns are the header columns UpdateInvoice writes; status,
+// void and bookkeeping columns are owned by the service.
+var invoiceEditableColumns = []string{
+	"invoice_no", "invoice_date", "sales_order_id", "sales_order_no", "delivery_order_id", "delivery_order_no",
+	"purchase_order_no", "sales_id", "sales_name", "customer_id", "customer_name", "deliver_to", "currency",
+	"total_amount", "total_tax", "due_date", "termin", "term_days", "notes", "company_code", "other_expense",
+}
+
+// UpdateInvoice replaces the header fields and details of an invoice that
+// has no payments or credit notes yet. Totals are recomputed from details.
+func UpdateInvoice(tx *gorm.DB, id string, header models.InvoiceHeader, details []models.InvoiceDetail) error {
+	current, err := lockInvoice(tx, id)
+	if err != nil {
+		return err
+	}
+	if current.Status == models.InvoiceStatusVoid {
+		return invalidf("invoice %s is void", id)
+	}
+	paid, credited, err := invoiceSettlement(tx, id)
+	if err != nil {
+		return err
+	}
+	if paid > 0 || credited > 0 {
+		return invalidf("invoice %s already has payments or credit notes, issue a credit note instead", id)
+	}
+
+	header.InvoiceHeaderID = id
+	if err := ApplyPaymentTerms(&header); err != nil {
+		return invalidf("%s", err.Error())
+	}
+	if err := ComputeInvoiceTotals(&header, details); err != nil {
+		return err
+	}
+
+	// Select so cleared optional fields are written too
+	if err := tx.Model(&models.InvoiceHeader{}).Where("id = ?", id).
+		Select(invoiceEditableColumns).
+		Updates(&header).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Delete(&models.InvoiceDetail{}, "header_id = ?", id).Error; err != nil {
+		return err
+	}
+	for _, d := range details {
+		d.InvoiceHeaderID = id
+		if err := tx.Create(&d).Error; err != nil {
+			return err
+		}
+	}
+	// the due date may have moved
+	return RefreshInvoiceStatus(tx, id)
+}
+
+// VoidInvoice cancels an invoice and reverses the journals of its credit
+// notes. Reconciled payments must be released first so bank entries never
+// point at a void invoice.
+func VoidInvoice(tx *gorm.DB, id, reason, actor string) error {
+	current, err := lockInvoice(tx, id)
+	if err != nil {
+		return err
+	}
+	if current.Status == models.InvoiceStatusVoid {
+		return invalidf("invoice %s is already void", id)
+	}
+	var links int64
+	if err := tx.Model(&models.BankEntryInvoice{}).Where("invoice_header_id = ?", id).Count(&links).Error; err != nil {
+		return err
+	}
+	if links > 0 {
+		return invalidf("invoice %s has %d reconciled payment(s), release them before voiding", id, links)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return invalidf("a void reason is required")
+	}
+	now := time.Now()
+	if err := tx.Model(&models.InvoiceHeader{}).Where("id = ?", id).Updates(map[string]any{
+		"status":      models.InvoiceStatusVoid,
+		"voided_at":   now,
+		"voided_by":   actor,
+		"void_reason": strings.TrimSpace(reason),
+	}).Error; err != nil {
+		return err
+	}
+	// its credit notes no longer reduce a receivable
+	var notes []string
+	if err := tx.Model(&models.CreditNote{}).Where("invoice_header_id = ?", id).Order("id").Pluck("id", &notes).Error; err != nil {
+		return err
+	}
+	for _, cn := range notes {
+		if err := PostCreditNote(tx, cn, actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IssueCreditNote books a credit note against its original invoice and
+// posts it to the journal. The credit may not exceed what is still outstanding after payments and earlier
+// credit notes.
+func IssueCreditNote(tx *gorm.DB, cn *models.CreditNote) error {
+	if strings.TrimSpace(cn.CreditNoteNo) == "" {
+		return invalidf("creditNoteNo is required")
+	}
+	if cn.Amount <= 0 {
+		return invalidf("amount must be positive")
+	}
+	cn.Amount = round2(cn.Amount)
+
+	inv, err := lockInvoice(tx, cn.InvoiceHeaderID)
+	if err != nil {
+		return err
+	}
+	if inv.Status == models.InvoiceStatusVoid {
+		return invalidf("invoice %s is void", inv.InvoiceHeaderID)
+	}
+	if cn.CreditDate.IsZero() {
+		cn.CreditDate = time.Now()
+	}
+	if cn.CreditDate.Before(inv.InvoiceDate.Truncate(24 * time.Hour)) {
+		return invalidf("creditDate precedes the invoice date")
+	}
+	paid, credited, err := invoiceSettlement(tx, inv.InvoiceHeaderID)
+	if err != nil {
+		return err
+	}
+	if outstanding := round2(inv.TotalAmount - paid - credited); cn.Amount > outstanding+0.01 {
+		return invalidf("credit %.2f exceeds the outstanding %.2f of invoice %s", cn.Amount, outstanding, inv.InvoiceHeaderID)
+	}
+
+	cn.CompanyCode = inv.CompanyCode
+	if err := tx.Create(cn).Error; err != nil {
+		return err
+	}
+	if err := RefreshInvoiceStatus(tx, inv.InvoiceHeaderID); err != nil {
+		return err
+	}
+	return PostCreditNote(tx, cn.ID, cn.CreatedBy)
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultAccounts is the minimal chart created on migration so that postings
+// work out of the box. The codes can be changed later; postings look them up
+// by role.
+func DefaultAccounts() []models.Account {
+	role := func(s string) *string { return &s }
+	return []models.Account{
+		{Code: "1110", Name: "Bank", Type: models.AccountTypeAsset, Role: role(models.AccountRoleBank), IsActive: true},
+		{Code: "1130", Name: "Accounts Receivable", Type: models.AccountTypeAsset, Role: role(models.AccountRoleReceivable), IsActive: true},
+		{Code: "2110", Name: "Accounts Payable", Type: models.AccountTypeLiability, Role: role(models.AccountRolePayable), IsActive: true},
+		{Code: "4190", Name: "Sales Returns and Allowances", Type: models.AccountTypeRevenue, Role: role(models.AccountRoleSalesReturns), IsActive: true},
+		{Code: "7110", Name: "Foreign Exchange Gain/Loss", Type: models.AccountTypeRevenue, Role: role(models.AccountRoleFxGainLoss), IsActive: true},
+	}
+}
+
+// BackfillCategoryAccounts adds an active account for every category
+// DefaultAccount missing from the chart, named after the category and typed
+// revenue for money_in and expense for money_out. Categories created before
+// their accounts were checked would otherwise fail to post.
+func BackfillCategoryAccounts(db *gorm.DB) error {
+	return db.Exec(`INSERT INTO accounts (code, name, type, is_active, created_at, updated_at)
+		SELECT c.default_account, MIN(c.name),
+			CASE WHEN MIN(c.type) = 'money_in' THEN ? ELSE ? END, TRUE, NOW(), NOW()
+		FROM categories c
+		LEFT JOIN accounts a ON a.code = c.default_account
+		WHERE c.default_account <> '' AND c.deleted_at IS NULL AND a.code IS NULL
+		GROUP BY c.default_account`, models.AccountTypeRevenue, models.AccountTypeExpense).Error
+}
+
+// ControlAccount returns the code of the active account holding role.
+func ControlAccount(tx *gorm.DB, role string) (string, error) {
+	var acc models.Account
+	err := tx.Where("role = ? AND is_active = ?", role, true).First(&acc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", invalidf("no active %s account in the chart of accounts", role)
+	}
+	return acc.Code, err
+}
+
+// CheckAccount rejects postings to unknown or inactive accounts.
+func CheckAccount(tx *gorm.DB, code string) error {
+	var n int64
+	if err := tx.Model(&models.Account{}).Where("code = ? AND is_active = ?", code, true).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return invalidf("account %s is not an active account in the chart of accounts", code)
+	}
+	return nil
+}
+
+// syncJournal brings the journal of one source document in line with
+// desired, a map of account code to net amount (debits positive, credits
+// negative). Only the difference to what is already posted is booked, as a
+// new balanced entry, so re-matching or detaching never edits history. The
+// entry is booked to the company of the source document.
+func syncJournal(tx *gorm.DB, source, sourceID, company string, date time.Time, desc string, desired map[string]float64, actor string) error {
+	type postedRow struct {
+		AccountCode string
+		Net         float64
+	}
+	var rows []postedRow
+	if err := tx.Table("journal_lines jl").
+		Select("jl.account_code, SUM(jl.debit - jl.credit) AS net").
+		Joins("JOIN journal_entries je ON je.id = jl.journal_entry_id").
+		Where("je.source = ? AND je.source_id = ?", source, sourceID).
+		Group("jl.account_code").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	posted := make(map[string]float64, len(rows))
+	for _, p := range rows {
+		posted[p.AccountCode] += p.Net
+	}
+	lines, debit, err := journalDelta(desired, posted)
+	if err != nil {
+		return fmt.Errorf("journal for %s %s %w", source, sourceID, err)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if actor == "" {
+		actor = "system"
+	}
+	return tx.Create(&models.JournalEntry{
+		EntryDate:   date,
+		Source:      source,
+		SourceID:    sourceID,
+		CompanyCode: company,
+		Description: desc,
+		TotalDebit:  debit,
+		CreatedBy:   actor,
+		Lines:       lines,
+	}).Error
+}
+
+// journalDelta returns the lines, in account order, that take posted to
+// desired (both account code to net amount, debits positive) and their
+// total debit. Differences under half a cent are dropped; lines that do not
+// balance are an error.
+func journalDelta(desired, posted map[string]float64) ([]models.JournalLine, float64, error) {
+	delta := map[string]float64{}
+	for code, amt := range desired {
+		delta[code] += amt
+	}
+	for code, amt := range posted {
+		delta[code] -= amt
+	}
+
+	codes := make([]string, 0, len(delta))
+	for code := range delta {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	var lines []models.JournalLine
+	var debit, credit float64
+	for _, code := range codes {
+		amt := round2(delta[code])
+		switch {
+		case amt > 0:
+			lines = append(lines, models.JournalLine{AccountCode: code, Debit: amt})
+			debit += amt
+		case amt < 0:
+			lines = append(lines, models.JournalLine{AccountCode: code, Credit: -amt})
+			credit -= amt
+		}
+	}
+	if math.Abs(debit-credit) > 0.005 {
+		return nil, 0, fmt.Errorf("does not balance: debit %.2f, credit %.2f", debit, credit)
+	}
+	return lines, round2(debit), nil
+}
+
+// PostBankEntry posts the settlement recorded for a bank entry: Dr Bank /
+// Cr Accounts Receivable (in IDR, with any FX difference to the FX gain/loss
+// account) for invoices matched to a CR entry and Dr Accounts Payable / Cr
+// Bank for purchase invoices matched to a DB entry. Bank is the GL account of
+// the entry's bank account. Call it in the transaction that changes the
+// entry's links or the entry itself.
+func PostBankEntry(tx *gorm.DB, entryID, actor string) error {
+	var entry models.BankEntry
+	if err := tx.First(&entry, "id = ?", entryID).Error; err != nil {
+		return err
+	}
+	bank, err := bankGLAccount(tx, entry.BankAccountID)
+	if err != nil {
+		return err
+	}
+
+	var matched float64
+	if entry.AmountType == "DB" {
+		if err := tx.Model(&models.BankEntryPurchaseInvoice{}).
+			Where("bank_entry_id = ?", entryID).
+			Select("COALESCE(SUM(matched_amount), 0)").Scan(&matched).Error; err != nil {
+			return err
+		}
+		payable, err := ControlAccount(tx, models.AccountRolePayable)
+		if err != nil {
+			return err
+		}
+		return syncJournal(tx, models.JournalSourceBankPayment, entryID, entry.CompanyCode, entry.TransactionDate,
+			"Payment "+entry.Description, map[string]float64{payable: matched, bank: -matched}, actor)
+	}
+
+	var sums struct {
+		Base float64
+		Fx   float64
+	}
+	if err := tx.Model(&models.BankEntryInvoice{}).
+		Where("bank_entry_id = ?", entryID).
+		Select("COALESCE(SUM(base_amount), 0) AS base, COALESCE(SUM(fx_gain_loss), 0) AS fx").Scan(&sums).Error; err != nil {
+		return err
+	}
+	receivable, err := ControlAccount(tx, models.AccountRoleReceivable)
+	if err != nil {
+		return err
+	}
+	// the receivable leaves at its booked IDR value; the bank receives it at
+	// the entry date rate and the difference is realised FX gain or loss
+	desired := map[string]float64{bank: sums.Base + sums.Fx, receivable: -sums.Base}
+	if round2(sums.Fx) != 0 {
+		fx, err := ControlAccount(tx, models.AccountRoleFxGainLoss)
+		if err != nil {
+			return err
+		}
+		desired[fx] -= sums.Fx
+	}
+	return syncJournal(tx, models.JournalSourceBankReceipt, entryID, entry.CompanyCode, entry.TransactionDate,
+		"Receipt "+entry.Description, desired, actor)
+}
+
+// PostCreditNote posts a credit note: Dr Sales Returns / Cr Accounts
+// Receivable at the invoice date rate, the IDR value the receivable it
+// reduces is booked at. The credit note of a void invoice no longer reduces
+// anything and is reversed on the void date. Call it in the transaction that
+// issues the credit note or voids its invoice.
+func PostCreditNote(tx *gorm.DB, id, actor string) error {
+	var cn models.CreditNote
+	if err := tx.First(&cn, "id = ?", id).Error; err != nil {
+		return err
+	}
+	var inv models.InvoiceHeader
+	if err := tx.Unscoped().Select("id", "status", "currency", "invoice_date", "voided_at").First(&inv, "id = ?", cn.InvoiceHeaderID).Error; err != nil {
+		return err
+	}
+
+	desired := map[string]float64{}
+	date := cn.CreditDate
+	if inv.Status == models.InvoiceStatusVoid {
+		if inv.VoidedAt != nil {
+			date = *inv.VoidedAt
+		}
+	} else {
+		rate, err := RateOn(tx, inv.Currency, inv.InvoiceDate)
+		if err != nil {
+			return err
+		}
+		returns, err := ControlAccount(tx, models.AccountRoleSalesReturns)
+		if err != nil {
+			return err
+		}
+		receivable, err := ControlAccount(tx, models.AccountRoleReceivable)
+		if err != nil {
+			return err
+		}
+		base := round2(cn.Amount * rate)
+		desired[returns] = base
+		desired[receivable] = -base
+	}
+	return syncJournal(tx, models.JournalSourceCreditNote, cn.ID, cn.CompanyCode, date,
+		"Credit note "+cn.CreditNoteNo, desired, actor)
+}
+
+// BackfillCreditNoteJournals posts the credit notes issued before credit
+// notes were journalled. Those that cannot be posted yet, e.g. for want of
+// an exchange rate, are left for the next run.
+func BackfillCreditNoteJournals(db *gorm.DB) error {
+	var ids []string
+	if err := db.Model(&models.CreditNote{}).
+		Where("id NOT IN (?)", db.Model(&models.JournalEntry{}).Select("source_id").Where("source = ?", models.JournalSourceCreditNote)).
+		Order("id").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := db.Transaction(func(tx *gorm.DB) error { return PostCreditNote(tx, id, "system") })
+		var verr ValidationError
+		if err != nil && !errors.As(err, &verr) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PostTransaction posts a categorized transaction against its categories'
+// DefaultAccount with the GL account of its bank account (see
+// bankGLAccount) as the other side: money_in categories are credited,
+// money_out categories debited, each with its own amount (see
+// splitByCategory). Categories without an account are ignored. It reports
+// whether anything is (still) posted.
+func PostTransaction(tx *gorm.DB, transactionID, actor string) (bool, error) {
+	var t models.Transaction
+	if err := tx.First(&t, "id = ?", transactionID).Error; err != nil {
+		return false, err
+	}
+	var cats []categoryPosting
+	if err := tx.Table("transaction_categories tc").
+		Select("tc.category_id, tc.amount, c.default_account, c.type").
+		Joins("JOIN categories c ON c.id = tc.category_id AND c.deleted_at IS NULL").
+		Where("tc.transaction_id = ? AND c.default_account <> ''", transactionID).
+		Order("c.id").
+		Scan(&cats).Error; err != nil {
+		return false, err
+	}
+
+	desired := map[string]float64{}
+	amount, date, ok, err := transactionFigures(tx, t)
+	if err != nil {
+		return false, err
+	}
+	if ok && len(cats) > 0 {
+		parts, err := splitByCategory(amount, cats)
+		if err != nil {
+			return false, err
+		}
+		bank, err := bankGLAccount(tx, t.BankAccountID)
+		if err != nil {
+			return false, err
+		}
+		for i, cat := range cats {
+			if err := CheckAccount(tx, cat.DefaultAccount); err != nil {
+				return false, err
+			}
+			sign := 1.0 // money_out: Dr category, Cr Bank
+			if cat.Type == "money_in" {
+				sign = -1
+			}
+			desired[cat.DefaultAccount] += sign * parts[i]
+			desired[bank] -= sign * parts[i]
+		}
+	}
+	if date.IsZero() {
+		date = t.ImportTimestamp
+	}
+	if err := syncJournal(tx, models.JournalSourceTransaction, transactionID, t.CompanyCode, date, "Transaction "+transactionID, desired, actor); err != nil {
+		return false, err
+	}
+	return len(desired) > 0, nil
+}
+
+// categoryPosting is a category of a transaction as far as posting goes.
+type categoryPosting struct {
+	CategoryID     string
+	Amount         *float64
+	DefaultAccount string
+	Type           string
+}
+
+// splitByCategory returns the part of total each category posts: its own
+// amount when it has one, and what the others leave for the one category
+// without an amount. Amounts beyond total and several categories without an
+// amount are refused rather than guessed.
+func splitByCategory(total float64, cats []categoryPosting) ([]float64, error) {
+	parts := make([]float64, len(cats))
+	rest := total
+	var open []string
+	for i, c := range cats {
+		if c.Amount == nil {
+			open = append(open, c.CategoryID)
+			continue
+		}
+		parts[i] = round2(*c.Amount)
+		rest -= parts[i]
+	}
+	rest = round2(rest)
+	if rest < 0 {
+		return nil, invalidf("category amounts exceed the transaction amount %.2f by %.2f", total, -rest)
+	}
+	if len(open) > 1 {
+		return nil, invalidf("categories %s have no amount, give each category of the transaction its amount", strings.Join(open, ", "))
+	}
+	for i, c := range cats {
+		if c.Amount == nil {
+			parts[i] = rest
+		}
+	}
+	return parts, nil
+}
+
+type TrialBalanceRow struct {
+	Code          string  `json:"code"`
+	Name          string  `json:"name"`
+	Type          string  `json:"type"`
+	Debit         float64 `json:"debit"`
+	Credit        float64 `json:"credit"`
+	DebitBalance  float64 `json:"debitBalance"`
+	CreditBalance float64 `json:"creditBalance"`
+}
+
+type TrialBalance struct {
+	Rows          []TrialBalanceRow `json:"rows"`
+	TotalDebit    float64           `json:"totalDebit"`
+	TotalCredit   float64           `json:"totalCredit"`
+	DebitBalance  float64           `json:"debitBalance"`
+	CreditBalance float64           `json:"creditBalance"`
+	Balanced      bool              `json:"balanced"`
+}
+
+// ComputeTrialBalance sums journal lines per account for entries dated in
+// [from, to] of companies; a nil bound or company list is open.
+func ComputeTrialBalance(db *gorm.DB, companies []string, from, to *time.Time) (TrialBalance, error) {
+	q := db.Table("journal_lines jl").
+		Select("jl.account_code AS code, COALESCE(a.name, '') AS name, COALESCE(a.type, '') AS type, SUM(jl.debit) AS debit, SUM(jl.credit) AS credit").
+		Joins("JOIN journal_entries je ON je.id = jl.journal_entry_id").
+		Joins("LEFT JOIN accounts a ON a.code = jl.account_code").
+		Group("jl.account_code, a.name, a.type").
+		Order("jl.account_code")
+	if companies != nil {
+		q = q.Where("je.company_code IN ?", companies)
+	}
+	if from != nil {
+		q = q.Where("je.entry_date >= ?", *from)
+	}
+	if to != nil {
+		q = q.Where("je.entry_date <= ?", *to)
+	}
+
+	tb := TrialBalance{Rows: []TrialBalanceRow{}}
+	if err := q.Scan(&tb.Rows).Error; err != nil {
+		return tb, err
+	}
+	for i := range tb.Rows {
+		r := &tb.Rows[i]
+		r.Debit, r.Credit = round2(r.Debit), round2(r.Credit)
+		if net := round2(r.Debit - r.Credit); net >= 0 {
+			r.DebitBalance = net
+		} else {
+			r.CreditBalance = -net
+		}
+		tb.TotalDebit += r.Debit
+		tb.TotalCredit += r.Credit
+		tb.DebitBalance += r.DebitBalance
+		tb.CreditBalance += r.CreditBalance
+	}
+	tb.TotalDebit, tb.TotalCredit = round2(tb.TotalDebit), round2(tb.TotalCredit)
+	tb.DebitBalance, tb.CreditBalance = round2(tb.DebitBalance), round2(tb.CreditBalance)
+	tb.Balanced = math.Abs(tb.DebitBalance-tb.CreditBalance) < 0.005
+	return tb, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"reflect"
+	"testing"
+)
+
+func TestJournalDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		desired   map[string]float64
+		posted    map[string]float64
+		wantLines []models.JournalLine
+		wantDebit float64
+		wantErr   bool
+	}{
+		{
+			name:      "first posting",
+			desired:   map[string]float64{"1110": 1000, "1130": -1000},
+			wantLines: []models.JournalLine{{AccountCode: "1110", Debit: 1000}, {AccountCode: "1130", Credit: 1000}},
+			wantDebit: 1000,
+		},
+		{
+			name:    "re-posting the same figures books nothing",
+			desired: map[string]float64{"1110": 1000, "1130": -1000},
+			posted:  map[string]float64{"1110": 1000, "1130": -1000},
+		},
+		{
+			name:    "rounding noise books nothing",
+			desired: map[string]float64{"1110": 1000.004, "1130": -1000.004},
+			posted:  map[string]float64{"1110": 1000, "1130": -1000},
+		},
+		{
+			name:      "partial detach books the difference",
+			desired:   map[string]float64{"1110": 600, "1130": -600},
+			posted:    map[string]float64{"1110": 1000, "1130": -1000},
+			wantLines: []models.JournalLine{{AccountCode: "1110", Credit: 400}, {AccountCode: "1130", Debit: 400}},
+			wantDebit: 400,
+		},
+		{
+			name:      "full detach reverses",
+			desired:   map[string]float64{},
+			posted:    map[string]float64{"1110": 1000, "1130": -1000},
+			wantLines: []models.JournalLine{{AccountCode: "1110", Credit: 1000}, {AccountCode: "1130", Debit: 1000}},
+			wantDebit: 1000,
+		},
+		{
+			name:      "moved to another account",
+			desired:   map[string]float64{"1120": 1000, "1130": -1000},
+			posted:    map[string]float64{"1110": 1000, "1130": -1000},
+			wantLines: []models.JournalLine{{AccountCode: "1110", Credit: 1000}, {AccountCode: "1120", Debit: 1000}},
+			wantDebit: 1000,
+		},
+		{
+			name:      "fx difference",
+			desired:   map[string]float64{"1110": 16250, "1130": -16000, "7110": -250},
+			wantLines: []models.JournalLine{{AccountCode: "1110", Debit: 16250}, {AccountCode: "1130", Credit: 16000}, {AccountCode: "7110", Credit: 250}},
+			wantDebit: 16250,
+		},
+		{
+			name:    "unbalanced",
+			desired: map[string]float64{"1110": 1000, "1130": -999},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, debit, err := journalDelta(tt.desired, tt.posted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(lines, tt.wantLines) || debit != tt.wantDebit {
+				t.Errorf("lines = %+v (debit %v), want %+v (debit %v)", lines, debit, tt.wantLines, tt.wantDebit)
+			}
+			// posting the delta on top of posted must leave exactly desired
+			if err == nil {
+				after := map[string]float64{}
+				for code, amt := range tt.posted {
+					after[code] += amt
+				}
+				for _, l := range lines {
+					after[l.AccountCode] += l.Debit - l.Credit
+				}
+				if again, _, _ := journalDelta(tt.desired, after); len(again) != 0 {
+					t.Errorf("re-posting books %+v, want nothing", again)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitByCategory(t *testing.T) {
+	amount := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		total   float64
+		cats    []categoryPosting
+		want    []float64
+		wantErr bool
+	}{
+		{
+			name:  "single category takes the total",
+			total: 1000,
+			cats:  []categoryPosting{{CategoryID: "salary"}},
+			want:  []float64{1000},
+		},
+		{
+			name:  "own amounts, not an even split",
+			total: 1000,
+			cats:  []categoryPosting{{CategoryID: "salary", Amount: amount(900)}, {CategoryID: "fees", Amount: amount(100)}},
+			want:  []float64{900, 100},
+		},
+		{
+			name:  "category without amount takes the rest",
+			total: 1000,
+			cats:  []categoryPosting{{CategoryID: "fees", Amount: amount(2.5)}, {CategoryID: "salary"}},
+			want:  []float64{2.5, 997.5},
+		},
+		{
+			name:  "amounts short of the total leave the rest unposted",
+			total: 1000,
+			cats:  []categoryPosting{{CategoryID: "fees", Amount: amount(250)}},
+			want:  []float64{250},
+		},
+		{
+			name:    "amounts beyond the total",
+			total:   1000,
+			cats:    []categoryPosting{{CategoryID: "salary", Amount: amount(900)}, {CategoryID: "fees", Amount: amount(100.01)}},
+			wantErr: true,
+		},
+		{
+			name:    "several categories without amount",
+			total:   1000,
+			cats:    []categoryPosting{{CategoryID: "salary"}, {CategoryID: "fees"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitByCategory(tt.total, tt.cats)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+				t.Errorf("parts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
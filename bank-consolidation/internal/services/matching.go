@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InvoicePaidSubquery sums what is already matched against invoice_headers.id.
+const InvoicePaidSubquery = "(SELECT COALESCE(SUM(matched_amount), 0) FROM bank_entry_invoices WHERE invoice_header_id = invoice_headers.id)"
+
+// Weights of the individual signals in a suggestion's confidence score.
+const (
+	weightAmount    = 0.40
+	weightInvoiceNo = 0.35
+	weightCustomer  = 0.15
+	weightDate      = 0.10
+)
+
+type OpenInvoice struct {
+	ID             string    `json:"id"`
+	InvoiceNo      string    `json:"invoiceNo"`
+	InvoiceDate    time.Time `json:"invoiceDate"`
+	CustomerID     string    `json:"customerId"`
+	CustomerName   string    `json:"customerName"`
+	CompanyCode    string    `json:"companyCode"`
+	Currency       string    `json:"currency"`
+	TotalAmount    float64   `json:"totalAmount"`
+	PaidAmount     float64   `json:"paidAmount"`
+	CreditedAmount float64   `json:"creditedAmount"`
+	Outstanding    float64   `json:"outstanding"`
+}
+
+type Suggestion struct {
+	OpenInvoice
+	Score   float64            `json:"score"`
+	Signals map[string]float64 `json:"signals"`
+}
+
+// OpenInvoices lists invoices that still have an outstanding balance after
+// payments and credit notes.
+func OpenInvoices(db *gorm.DB) ([]OpenInvoice, error) {
+	var list []OpenInvoice
+	err := db.Table("invoice_headers").
+		Select("id, invoice_no, invoice_date, customer_id, customer_name, company_code, currency, total_amount, "+InvoicePaidSubquery+" AS paid_amount, "+InvoiceCreditSubquery+" AS credited_amount").
+		Where("deleted_at IS NULL AND status <> ?", "void").
+		Where("total_amount > " + InvoiceSettledExpr).
+		Scan(&list).Error
+	for i := range list {
+		list[i].Outstanding = math.Round((list[i].TotalAmount-list[i].PaidAmount-list[i].CreditedAmount)*100) / 100
+	}
+	return list, err
+}
+
+// SuggestInvoices ranks candidates for a CR bank entry whose still
+// unallocated amount is remaining. Only candidates of the entry's company and
+// currency scoring above minScore are returned, best first.
+func SuggestInvoices(entry models.BankEntry, remaining float64, candidates []OpenInvoice, minScore float64, limit int) []Suggestion {
+	text := entry.Description + " " + entry.RemittanceInfo + " " + entry.CreditorReference + " " + entry.CounterpartyName
+	compact := compactText(text)
+	tokens := tokenSet(text)
+
+	out := []Suggestion{}
+	for _, inv := range candidates {
+		if inv.CompanyCode != entry.CompanyCode || inv.Currency != entry.Currency {
+			continue
+		}
+		s := Suggestion{OpenInvoice: inv, Signals: map[string]float64{
+			"amount":    amountSignal(remaining, inv.Outstanding),
+			"invoiceNo": invoiceNoSignal(inv.InvoiceNo, compact, tokens),
+			"customer":  customerSignal(inv.CustomerName, tokens),
+			"date":      dateSignal(inv.InvoiceDate, entry.TransactionDate),
+		}}
+		s.Score = s.Signals["amount"]*weightAmount +
+			s.Signals["invoiceNo"]*weightInvoiceNo +
+			s.Signals["customer"]*weightCustomer +
+			s.Signals["date"]*weightDate
+		s.Score = math.Round(s.Score*1000) / 1000
+		if s.Score >= minScore {
+			out = append(out, s)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].InvoiceDate.Before(out[j].InvoiceDate)
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// amountSignal is 1 for an exact match and decays to 0 at a 5% difference,
+// which still catches transfers short by bank fees.
+func amountSignal(paid, outstanding float64) float64 {
+	if paid <= 0 || outstanding <= 0 {
+		return 0
+	}
+	diff := math.Abs(paid - outstanding)
+	if diff <= 0.01 {
+		return 1
+	}
+	rel := diff / outstanding
+	if rel >= 0.05 {
+		return 0
+	}
+	return math.Round((0.9-rel/0.05*0.9)*1000) / 1000
+}
+
+var nonAlnum = regexp.MustCompile(`[^A-Z0-9]+`)
+
+func compactText(s string) string {
+	return nonAlnum.ReplaceAllString(strings.ToUpper(s), "")
+}
+
+func tokenSet(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, t := range nonAlnum.Split(strings.ToUpper(s), -1) {
+		if t != "" {
+			set[t] = true
+		}
+	}
+	return set
+}
+
+// invoiceNoSignal is 1 when the full invoice number appears in the text
+// (ignoring separators) and 0.5 when only its running number does.
+func invoiceNoSignal(invoiceNo, compact string, tokens map[string]bool) float64 {
+	no := compactText(invoiceNo)
+	if len(no) >= 4 && strings.Contains(compact, no) {
+		return 1
+	}
+	parts := nonAlnum.Split(strings.ToUpper(invoiceNo), -1)
+	last := parts[len(parts)-1]
+	if len(last) >= 3 && tokens[last] {
+		return 0.5
+	}
+	return 0
+}
+
+var legalForms = map[string]bool{"PT": true, "CV": true, "UD": true, "TOKO": true, "TBK": true, "WARUNG": true}
+
+// customerSignal is the share of the customer's distinctive name tokens that
+// appear in the bank text.
+func customerSignal(name string, tokens map[string]bool) float64 {
+	total, found := 0, 0
+	for t := range tokenSet(name) {
+		if legalForms[t] || len(t) < 3 {
+			continue
+		}
+		total++
+		if tokens[t] {
+			found++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(found)/float64(total)*1000) / 1000
+}
+
+// dateSignal favours payments shortly after the invoice date and ignores
+// payments more than three days before it.
+func dateSignal(invoiceDate, paidDate time.Time) float64 {
+	days := paidDate.Sub(invoiceDate).Hours() / 24
+	switch {
+	case days < -3:
+		return 0
+	case days <= 0:
+		return 1
+	case days >= 90:
+		return 0
+	}
+	return math.Round((1-days/90)*1000) / 1000
+}
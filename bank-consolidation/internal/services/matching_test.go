@@ -0,0 +1,97 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"testing"
+	"time"
+)
+
+func TestMatchingSignals(t *testing.T) {
+	day := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	text := "TRF INV/2025/0001 PT Maju Jaya"
+	compact, tokens := compactText(text), tokenSet(text)
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"amount exact", amountSignal(1000, 1000), 1},
+		{"amount within a cent", amountSignal(999.995, 1000), 1},
+		{"amount short by 1%", amountSignal(990, 1000), 0.72},
+		{"amount short by 5%", amountSignal(950, 1000), 0},
+		{"amount nothing paid", amountSignal(0, 1000), 0},
+		{"invoice number in full", invoiceNoSignal("INV-2025-0001", compact, tokens), 1},
+		{"invoice running number only", invoiceNoSignal("SO-0001", compact, tokens), 0.5},
+		{"invoice number absent", invoiceNoSignal("INV-2025-0002", compact, tokens), 0},
+		{"customer all tokens", customerSignal("PT Maju Jaya", tokens), 1},
+		{"customer half the tokens", customerSignal("CV Maju Bersama", tokens), 0.5},
+		{"customer only legal form", customerSignal("PT", tokens), 0},
+		{"date same day", dateSignal(day, day), 1},
+		{"date paid shortly before", dateSignal(day, day.AddDate(0, 0, -2)), 1},
+		{"date paid long before", dateSignal(day, day.AddDate(0, 0, -4)), 0},
+		{"date paid after 45 days", dateSignal(day, day.AddDate(0, 0, 45)), 0.5},
+		{"date paid after 90 days", dateSignal(day, day.AddDate(0, 0, 90)), 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestSuggestInvoices(t *testing.T) {
+	day := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	entry := models.BankEntry{
+		CompanyCode:     "A",
+		Currency:        "IDR",
+		TransactionDate: day,
+		Description:     "TRF INV-2025-0001 PT MAJU JAYA",
+	}
+	candidates := []OpenInvoice{
+		// every signal: 0.40 + 0.35 + 0.15 + 0.10
+		{ID: "exact", InvoiceNo: "INV-2025-0001", InvoiceDate: day, CustomerName: "PT Maju Jaya", CompanyCode: "A", Currency: "IDR", Outstanding: 1000000},
+		// amount and a 40 day old date: 0.40 + 0.556 × 0.10
+		{ID: "amount", InvoiceNo: "INV-2025-0002", InvoiceDate: day.AddDate(0, 0, -40), CustomerName: "CV Lain", CompanyCode: "A", Currency: "IDR", Outstanding: 1000000},
+		// running number, customer and date: 0.5 × 0.35 + 0.15 + 0.10
+		{ID: "text", InvoiceNo: "X-0001", InvoiceDate: day, CustomerName: "Toko Maju", CompanyCode: "A", Currency: "IDR", Outstanding: 5000000},
+		{ID: "other-company", InvoiceNo: "INV-2025-0001", InvoiceDate: day, CustomerName: "PT Maju Jaya", CompanyCode: "B", Currency: "IDR", Outstanding: 1000000},
+		{ID: "other-currency", InvoiceNo: "INV-2025-0001", InvoiceDate: day, CustomerName: "PT Maju Jaya", CompanyCode: "A", Currency: "USD", Outstanding: 1000000},
+	}
+	tests := []struct {
+		name       string
+		minScore   float64
+		limit      int
+		wantIDs    []string
+		wantScores []float64
+	}{
+		{"ranked by score", 0, 0, []string{"exact", "amount", "text"}, []float64{1, 0.456, 0.425}},
+		{"below min score dropped", 0.43, 0, []string{"exact", "amount"}, []float64{1, 0.456}},
+		{"limited", 0, 1, []string{"exact"}, []float64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SuggestInvoices(entry, 1000000, candidates, tt.minScore, tt.limit)
+			if len(got) != len(tt.wantIDs) {
+				t.Fatalf("got %d suggestions %+v, want %v", len(got), got, tt.wantIDs)
+			}
+			for i, s := range got {
+				if s.ID != tt.wantIDs[i] || s.Score != tt.wantScores[i] {
+					t.Errorf("suggestion %d = %s %v, want %s %v", i, s.ID, s.Score, tt.wantIDs[i], tt.wantScores[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestInvoicesTieBreak(t *testing.T) {
+	day := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	entry := models.BankEntry{CompanyCode: "A", Currency: "IDR", TransactionDate: day.AddDate(0, 0, -10), Description: "SETORAN"}
+	candidates := []OpenInvoice{
+		{ID: "later", InvoiceNo: "INV-9", InvoiceDate: day, CompanyCode: "A", Currency: "IDR", Outstanding: 500},
+		{ID: "earlier", InvoiceNo: "INV-8", InvoiceDate: day.AddDate(0, 0, -1), CompanyCode: "A", Currency: "IDR", Outstanding: 500},
+	}
+	got := SuggestInvoices(entry, 500, candidates, 0, 0)
+	if len(got) != 2 || got[0].ID != "earlier" || got[0].Score != got[1].Score {
+		t.Errorf("suggestions = %+v, want equal scores with the earlier invoice first", got)
+	}
+}
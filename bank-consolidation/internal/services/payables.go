@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"math"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PurchasePaidSubquery sums what is already matched against
+// purchase_invoice_headers.purchase_invoice_header_id.
+const PurchasePaidSubquery = "(SELECT COALESCE(SUM(matched_amount), 0) FROM bank_entry_purchase_invoices WHERE purchase_invoice_header_id = purchase_invoice_headers.purchase_invoice_header_id)"
+
+// purchaseTransitions lists the manual status changes; PAID is only ever
+// derived from matched debit entries.
+var purchaseTransitions = map[models.PurchaseInvoiceStatusType][]models.PurchaseInvoiceStatusType{
+	models.PurchaseInvoiceStatusPending:     {models.PurchaseInvoiceStatusVerified, models.PurchaseInvoiceStatusVoid, models.RecreatedPurchaseInvoiceStatus},
+	models.PurchaseInvoiceStatusVerified:    {models.PurchaseInvoiceStatusPending, models.CollectiblePurchaseInvoiceStatus, models.PurchaseInvoiceStatusVoid},
+	models.CollectiblePurchaseInvoiceStatus: {models.PurchaseInvoiceStatusVerified, models.PurchaseInvoiceStatusVoid},
+}
+
+// CanTransitionPurchase reports whether a purchase invoice may be moved
+// manually from one status to another.
+func CanTransitionPurchase(from, to models.PurchaseInvoiceStatusType) bool {
+	for _, s := range purchaseTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+type PurchaseLine struct {
+	InvoiceID int64
+	Amount    float64
+}
+
+// ReconcilePurchaseEntry links a DB bank entry to purchase invoices, with the
+// same two-sided checks as ReconcileEntry does for sales invoices.
+func ReconcilePurchaseEntry(tx *gorm.DB, entryID string, lines []PurchaseLine, note string, replace bool, actor string) ([]models.BankEntryPurchaseInvoice, error) {
+	var entry models.BankEntry
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&entry, "id = ?", entryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, invalidf("bank entry %s not found", entryID)
+		}
+		return nil, err
+	}
+	if entry.AmountType != "DB" {
+		return nil, invalidf("bank entry %s is not a DB entry, purchase invoices are paid by debits", entryID)
+	}
+	if entry.Currency != BaseCurrency {
+		return nil, invalidf("bank entry %s is in %s, purchase invoices can only be paid from %s entries", entryID, entry.Currency, BaseCurrency)
+	}
+
+	var order []int64
+	amounts := map[int64]float64{}
+	for _, l := range lines {
+		if l.InvoiceID == 0 {
+			continue
+		}
+		if l.Amount <= 0 {
+			return nil, invalidf("amount for purchase invoice %d must be positive", l.InvoiceID)
+		}
+		if _, ok := amounts[l.InvoiceID]; !ok {
+			order = append(order, l.InvoiceID)
+		}
+		amounts[l.InvoiceID] = round2(amounts[l.InvoiceID] + l.Amount)
+	}
+
+	var existing []models.BankEntryPurchaseInvoice
+	if err := tx.Where("bank_entry_id = ?", entryID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	kept := 0.0
+	touched := append([]int64{}, order...)
+	for _, l := range existing {
+		if replace {
+			if _, ok := amounts[l.PurchaseInvoiceHeaderID]; !ok {
+				touched = append(touched, l.PurchaseInvoiceHeaderID)
+			}
+			continue
+		}
+		if _, dup := amounts[l.PurchaseInvoiceHeaderID]; dup {
+			return nil, invalidf("purchase invoice %d is already attached to bank entry %s, use replace mode to change it", l.PurchaseInvoiceHeaderID, entryID)
+		}
+		kept += l.MatchedAmount
+	}
+
+	requested := 0.0
+	for _, a := range amounts {
+		requested += a
+	}
+	if err := checkUnallocated(entry, kept, requested); err != nil {
+		return nil, err
+	}
+
+	// lock the purchase invoices before summing what is matched to them, in
+	// a fixed order, as ReconcileEntry does
+	if len(order) > 0 {
+		ids := append([]int64{}, order...)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		var locked []int64
+		if err := tx.Model(&models.PurchaseInvoiceHeader{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("purchase_invoice_header_id IN ?", ids).Order("purchase_invoice_header_id").
+			Pluck("purchase_invoice_header_id", &locked).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range order {
+		var inv models.PurchaseInvoiceHeader
+		if err := tx.First(&inv, "purchase_invoice_header_id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, invalidf("purchase invoice %d not found", id)
+			}
+			return nil, err
+		}
+		switch inv.PurchaseInvoiceStatus {
+		case models.PurchaseInvoiceStatusVoid, models.RecreatedPurchaseInvoiceStatus:
+			return nil, invalidf("purchase invoice %d is %s and cannot be paid", id, inv.PurchaseInvoiceStatus)
+		}
+		if inv.CompanyCode != entry.CompanyCode {
+			return nil, invalidf("purchase invoice %d belongs to company %s, bank entry %s to %s", id, inv.CompanyCode, entryID, entry.CompanyCode)
+		}
+		var matched float64
+		if err := tx.Model(&models.BankEntryPurchaseInvoice{}).
+			Select("COALESCE(SUM(matched_amount), 0)").
+			Where("purchase_invoice_header_id = ? AND bank_entry_id <> ?", id, entryID).
+			Scan(&matched).Error; err != nil {
+			return nil, err
+		}
+		if matched+amounts[id] > inv.TotalAmount+0.01 {
+			return nil, invalidf("purchase invoice %d is already fully paid or amount exceeds outstanding (Total: %.2f, Paid: %.2f, New: %.2f)", id, inv.TotalAmount, matched, amounts[id])
+		}
+	}
+
+	if replace {
+		if err := tx.Delete(&models.BankEntryPurchaseInvoice{}, "bank_entry_id = ?", entryID).Error; err != nil {
+			return nil, err
+		}
+	}
+	created := make([]models.BankEntryPurchaseInvoice, 0, len(order))
+	for _, id := range order {
+		created = append(created, models.BankEntryPurchaseInvoice{
+			BankEntryID:             entryID,
+			PurchaseInvoiceHeaderID: id,
+			MatchedAmount:           amounts[id],
+			Note:                    note,
+		})
+	}
+	if len(created) > 0 {
+		if err := tx.Create(&created).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range existing {
+		if !replace {
+			break
+		}
+		prev := l.MatchedAmount
+		next, kept := amounts[l.PurchaseInvoiceHeaderID]
+		if kept && next == prev {
+			continue
+		}
+		var nextp *float64
+		if kept {
+			nextp = &next
+		}
+		if err := auditLink(tx, entryID, "purchaseInvoiceId", l.PurchaseInvoiceHeaderID, &prev, nextp, actor); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range created {
+		if replace && linked(existing, l.PurchaseInvoiceHeaderID) {
+			continue
+		}
+		amount := l.MatchedAmount
+		if err := auditLink(tx, entryID, "purchaseInvoiceId", l.PurchaseInvoiceHeaderID, nil, &amount, actor); err != nil {
+			return nil, err
+		}
+	}
+	if err := RefreshPurchaseInvoiceStatus(tx, touched...); err != nil {
+		return nil, err
+	}
+	if err := PostBankEntry(tx, entryID, actor); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func linked(links []models.BankEntryPurchaseInvoice, id int64) bool {
+	for _, l := range links {
+		if l.PurchaseInvoiceHeaderID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RefreshPurchaseInvoiceStatus sets PAID on fully matched purchase invoices
+// and moves invoices that lost their payments from PAID back to VERIFIED.
+func RefreshPurchaseInvoiceStatus(tx *gorm.DB, ids ...int64) error {
+	for _, id := range ids {
+		var inv models.PurchaseInvoiceHeader
+		if err := tx.Select("purchase_invoice_headers.*, "+PurchasePaidSubquery+" AS paid_amount").
+			First(&inv, "purchase_invoice_header_id = ?", id).Error; err != nil {
+			return err
+		}
+		status := inv.PurchaseInvoiceStatus
+		fullyPaid := inv.PaidAmount >= inv.TotalAmount-0.005 && inv.TotalAmount > 0
+		switch {
+		case status == models.PurchaseInvoiceStatusVoid || status == models.RecreatedPurchaseInvoiceStatus:
+			continue
+		case fullyPaid:
+			status = models.PurchaseInvoiceStatusPaid
+		case status == models.PurchaseInvoiceStatusPaid:
+			status = models.PurchaseInvoiceStatusVerified
+		}
+		if status == inv.PurchaseInvoiceStatus {
+			continue
+		}
+		if err := tx.Model(&models.PurchaseInvoiceHeader{}).
+			Where("purchase_invoice_header_id = ?", id).
+			Update("purchase_invoice_status", status).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurchaseInvoiceTotal is the gross amount payable computed from the lines.
+func PurchaseInvoiceTotal(h models.PurchaseInvoiceHeader) float64 {
+	total := h.AdditionalCost + h.RoundValue
+	for _, d := range h.Details {
+		total += d.Amount + d.Ppn
+	}
+	return math.Round(total*100) / 100
+}
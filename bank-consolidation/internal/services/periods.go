@@ -0,0 +1,53 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PeriodOf is the accounting period ("YYYY-MM") a date falls in.
+func PeriodOf(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// PeriodKey names the period of a company, as in the audit log.
+func PeriodKey(company, period string) string {
+	return company + "/" + period
+}
+
+// LockedPeriods returns the periods of company that dates fall in and that
+// are soft-closed or closed, oldest first.
+func LockedPeriods(db *gorm.DB, company string, dates ...time.Time) ([]models.AccountingPeriod, error) {
+	seen := map[string]bool{}
+	periods := []string{}
+	for _, d := range dates {
+		if p := PeriodOf(d); !d.IsZero() && !seen[p] {
+			seen[p] = true
+			periods = append(periods, p)
+		}
+	}
+	if len(periods) == 0 {
+		return nil, nil
+	}
+	sort.Strings(periods)
+	var list []models.AccountingPeriod
+	err := db.Where("company_code = ? AND period IN ? AND status <> ?", company, periods, models.PeriodStatusOpen).
+		Order("period").Find(&list).Error
+	return list, err
+}
+
+// LockedPeriodSet returns the PeriodKey of every period that is not open.
+func LockedPeriodSet(db *gorm.DB) (map[string]bool, error) {
+	var list []models.AccountingPeriod
+	if err := db.Select("company_code", "period").Where("status <> ?", models.PeriodStatusOpen).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(list))
+	for _, p := range list {
+		set[PeriodKey(p.CompanyCode, p.Period)] = true
+	}
+	return set, nil
+}
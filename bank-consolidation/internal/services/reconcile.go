@@ -0,0 +1,537 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Auto-reconcile rules, applied in the order they are configured.
+const (
+	RuleInvoiceNo   = "invoice_no"
+	RuleExactAmount = "exact_amount"
+)
+
+var DefaultAutoRules = []string{RuleInvoiceNo, RuleExactAmount}
+
+// AutoOptions select the entries to reconcile: those of BankAccountID when
+// set, otherwise those of every account of BankCode, limited to Companies
+// unless that is nil.
+type AutoOptions struct {
+	BankAccountID string
+	BankCode      string
+	Companies     []string
+	From          time.Time
+	To            time.Time // exclusive
+	Rules         []string
+	DryRun        bool
+	Actor         string
+}
+
+type AutoMatch struct {
+	BankEntryID string  `json:"bankEntryId"`
+	InvoiceID   string  `json:"invoiceId"`
+	InvoiceNo   string  `json:"invoiceNo"`
+	Amount      float64 `json:"amount"`
+	Rule        string  `json:"rule"`
+}
+
+type AutoAmbiguous struct {
+	BankEntryID string   `json:"bankEntryId"`
+	Rule        string   `json:"rule"`
+	Candidates  []string `json:"candidates"`
+}
+
+// AutoFailure is a unique candidate that could not be linked, e.g. because
+// no exchange rate is known for its dates.
+type AutoFailure struct {
+	BankEntryID string `json:"bankEntryId"`
+	InvoiceID   string `json:"invoiceId"`
+	Error       string `json:"error"`
+}
+
+type AutoReport struct {
+	DryRun        bool            `json:"dryRun"`
+	BankAccountID string          `json:"bankAccountId,omitempty"`
+	BankCode      string          `json:"bankCode"`
+	Rules         []string        `json:"rules"`
+	Scanned       int             `json:"scanned"`
+	Matched       []AutoMatch     `json:"matched"`
+	Ambiguous     []AutoAmbiguous `json:"ambiguous"`
+	NoCandidate   []string        `json:"noCandidate"`
+	Failed        []AutoFailure   `json:"failed"`
+	// Locked are entries left alone because their accounting period is
+	// soft-closed or closed
+	Locked []string `json:"locked"`
+}
+
+// ValidateRules rejects unknown rule names.
+func ValidateRules(rules []string) error {
+	for _, r := range rules {
+		if r != RuleInvoiceNo && r != RuleExactAmount {
+			return fmt.Errorf("unknown rule %q", r)
+		}
+	}
+	return nil
+}
+
+// AutoReconcile links every unreconciled CR entry in the range to an open
+// invoice of the same company and currency when one of the rules yields exactly one
+// candidate; entries for which rules only yield several are reported as
+// ambiguous. Entries and invoices in soft-closed or closed accounting
+// periods are never touched. With DryRun the same report is produced
+// without writing anything.
+func AutoReconcile(db *gorm.DB, opts AutoOptions) (AutoReport, error) {
+	rules := opts.Rules
+	if len(rules) == 0 {
+		rules = DefaultAutoRules
+	}
+	rep := AutoReport{
+		DryRun:        opts.DryRun,
+		BankAccountID: opts.BankAccountID,
+		BankCode:      opts.BankCode,
+		Rules:         rules,
+		Matched:       []AutoMatch{},
+		Ambiguous:     []AutoAmbiguous{},
+		NoCandidate:   []string{},
+		Failed:        []AutoFailure{},
+		Locked:        []string{},
+	}
+
+	run := func(tx *gorm.DB) error {
+		scope := tx.Where("bank_code = ?", opts.BankCode)
+		if opts.BankAccountID != "" {
+			scope = tx.Where("bank_account_id = ?", opts.BankAccountID)
+		}
+		if opts.Companies != nil {
+			scope = scope.Where("company_code IN ?", opts.Companies)
+		}
+		var entries []models.BankEntry
+		if err := scope.Where("amount_type = ? AND transaction_date >= ? AND transaction_date < ?", "CR", opts.From, opts.To).
+			Where("NOT EXISTS (SELECT 1 FROM bank_entry_invoices bei WHERE bei.bank_entry_id = bank_entries.id)").
+			Order("transaction_date ASC, id ASC").
+			Find(&entries).Error; err != nil {
+			return err
+		}
+		rep.Scanned = len(entries)
+
+		locked, err := LockedPeriodSet(tx)
+		if err != nil {
+			return err
+		}
+		invoices, err := OpenInvoices(tx)
+		if err != nil {
+			return err
+		}
+		sort.Slice(invoices, func(i, j int) bool {
+			if !invoices[i].InvoiceDate.Equal(invoices[j].InvoiceDate) {
+				return invoices[i].InvoiceDate.Before(invoices[j].InvoiceDate)
+			}
+			return invoices[i].ID < invoices[j].ID
+		})
+
+		for _, e := range entries {
+			if locked[PeriodKey(e.CompanyCode, PeriodOf(e.TransactionDate))] {
+				rep.Locked = append(rep.Locked, e.ID)
+				continue
+			}
+			pick, rule, ambiguous := autoPick(e, invoices, locked, rules)
+			if pick < 0 {
+				if len(ambiguous) > 0 {
+					rep.Ambiguous = append(rep.Ambiguous, ambiguous...)
+				} else {
+					rep.NoCandidate = append(rep.NoCandidate, e.ID)
+				}
+				continue
+			}
+
+			inv := &invoices[pick]
+			amount := math.Min(e.Amount, inv.Outstanding)
+			st, err := Settle(tx, e, inv.Currency, inv.InvoiceDate, amount, 0, 0)
+			if err != nil {
+				var verr ValidationError
+				if !errors.As(err, &verr) {
+					return err
+				}
+				rep.Failed = append(rep.Failed, AutoFailure{BankEntryID: e.ID, InvoiceID: inv.ID, Error: verr.Msg})
+				continue
+			}
+			m := AutoMatch{BankEntryID: e.ID, InvoiceID: inv.ID, InvoiceNo: inv.InvoiceNo, Amount: amount, Rule: rule}
+			if !opts.DryRun {
+				link := models.BankEntryInvoice{
+					BankEntryID:     e.ID,
+					InvoiceHeaderID: inv.ID,
+					MatchedAmount:   amount,
+					BankAmount:      st.BankAmount,
+					Rate:            st.Rate,
+					BaseAmount:      st.BaseAmount,
+					FxGainLoss:      st.FxGainLoss,
+					Note:            "auto-reconcile: " + rule,
+				}
+				if err := tx.Create(&link).Error; err != nil {
+					return err
+				}
+				if err := recordLinkEvent(tx, link.BankEntryID, link.InvoiceHeaderID, models.LinkEventAttach, nil, &link.MatchedAmount, link.Note, opts.Actor); err != nil {
+					return err
+				}
+				if err := RefreshInvoiceStatus(tx, link.InvoiceHeaderID); err != nil {
+					return err
+				}
+				if err := PostBankEntry(tx, link.BankEntryID, opts.Actor); err != nil {
+					return err
+				}
+			}
+			// later entries in the same run must see the reduced balance
+			inv.Outstanding = round2(inv.Outstanding - amount)
+			rep.Matched = append(rep.Matched, m)
+		}
+		return nil
+	}
+
+	if opts.DryRun {
+		return rep, run(db)
+	}
+	return rep, db.Transaction(run)
+}
+
+// autoPick applies the rules to e in their configured order and returns the
+// index into invoices of the first unique candidate and the rule that found
+// it, or -1. A rule with several candidates does not stop later rules; its
+// candidates are returned so that they can be reported when no rule finds a
+// unique one.
+func autoPick(e models.BankEntry, invoices []OpenInvoice, locked map[string]bool, rules []string) (int, string, []AutoAmbiguous) {
+	text := compactText(e.Description + " " + e.RemittanceInfo + " " + e.CreditorReference)
+	var ambiguous []AutoAmbiguous
+	for _, rule := range rules {
+		var hits []int
+		for i, inv := range invoices {
+			if inv.Outstanding <= 0.005 || inv.CompanyCode != e.CompanyCode || inv.Currency != e.Currency || locked[PeriodKey(inv.CompanyCode, PeriodOf(inv.InvoiceDate))] {
+				continue
+			}
+			switch rule {
+			case RuleInvoiceNo:
+				if no := compactText(inv.InvoiceNo); len(no) >= 4 && strings.Contains(text, no) {
+					hits = append(hits, i)
+				}
+			case RuleExactAmount:
+				if math.Abs(inv.Outstanding-e.Amount) <= 0.01 {
+					hits = append(hits, i)
+				}
+			}
+		}
+		switch len(hits) {
+		case 0:
+			continue
+		case 1:
+			return hits[0], rule, nil
+		}
+		amb := AutoAmbiguous{BankEntryID: e.ID, Rule: rule}
+		for _, i := range hits {
+			amb.Candidates = append(amb.Candidates, invoices[i].ID)
+		}
+		ambiguous = append(ambiguous, amb)
+	}
+	return -1, "", ambiguous
+}
+
+// ValidationError is returned for reconcile requests that break a business
+// rule, as opposed to database failures.
+type ValidationError struct{ Msg string }
+
+func (e ValidationError) Error() string { return e.Msg }
+
+func invalidf(format string, args ...any) error {
+	return ValidationError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// ReconcileLine is one invoice of a reconcile request. Amount is in the
+// invoice's currency; BankAmount and Rate are optional and only matter when
+// the bank entry is in another currency (see Settle).
+type ReconcileLine struct {
+	InvoiceID  string
+	Amount     float64
+	BankAmount float64
+	Rate       float64
+}
+
+// ReconcileEntry links a bank entry to invoices of its own company. Both
+// sides are enforced: the entry's matched total (in its own currency) may not
+// exceed its amount, and no invoice may be matched beyond its total across
+// all bank entries. In replace mode the entry's existing links are dropped
+// first; otherwise they are kept and count towards the entry's allocation.
+// Every attach, detach and amount change is recorded in
+// bank_entry_invoice_events under actor.
+func ReconcileEntry(tx *gorm.DB, entryID string, lines []ReconcileLine, note string, replace bool, actor string) ([]models.BankEntryInvoice, error) {
+	var entry models.BankEntry
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&entry, "id = ?", entryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, invalidf("bank entry %s not found", entryID)
+		}
+		return nil, err
+	}
+	if entry.AmountType != "CR" {
+		return nil, invalidf("bank entry %s is not a CR entry, sales invoices are paid by credits", entryID)
+	}
+
+	// one invoice listed twice in a payload is one allocation
+	var order []string
+	amounts := map[string]float64{}
+	bankAmounts := map[string]float64{}
+	rates := map[string]float64{}
+	for _, l := range lines {
+		id := strings.TrimSpace(l.InvoiceID)
+		if id == "" {
+			continue
+		}
+		if l.Amount <= 0 {
+			return nil, invalidf("amount for invoice %s must be positive", id)
+		}
+		if _, ok := amounts[id]; !ok {
+			order = append(order, id)
+		}
+		amounts[id] = round2(amounts[id] + l.Amount)
+		bankAmounts[id] = round2(bankAmounts[id] + l.BankAmount)
+		if l.Rate != 0 {
+			rates[id] = l.Rate
+		}
+	}
+
+	var existing []models.BankEntryInvoice
+	if err := tx.Where("bank_entry_id = ?", entryID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	kept := 0.0
+	if !replace {
+		for _, l := range existing {
+			if _, dup := amounts[l.InvoiceHeaderID]; dup {
+				return nil, invalidf("invoice %s is already attached to bank entry %s, use replace mode to change it", l.InvoiceHeaderID, entryID)
+			}
+			kept += l.BankAmount
+		}
+	}
+
+	// lock the invoices before summing what is matched to them, so that
+	// reconciles against other entries cannot both pass the outstanding
+	// check; ids are sorted to lock in a fixed order
+	if len(order) > 0 {
+		ids := append([]string{}, order...)
+		sort.Strings(ids)
+		var locked []string
+		if err := tx.Model(&models.InvoiceHeader{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", ids).Order("id").Pluck("id", &locked).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	settled := map[string]Settlement{}
+	for _, id := range order {
+		var inv struct {
+			Status          string
+			CompanyCode     string
+			Currency        string
+			InvoiceDate     time.Time
+			TotalAmount     float64
+			Credited        float64
+			ExistingMatched float64
+		}
+		res := tx.Raw(`
+			SELECT
+				ih.status,
+				ih.company_code,
+				ih.currency,
+				ih.invoice_date,
+				ih.total_amount,
+				COALESCE((SELECT SUM(amount) FROM credit_notes WHERE invoice_header_id = ih.id AND deleted_at IS NULL), 0) AS credited,
+				COALESCE((SELECT SUM(matched_amount) FROM bank_entry_invoices WHERE invoice_header_id = ih.id AND bank_entry_id <> ?), 0) AS existing_matched
+			FROM invoice_headers ih
+			WHERE ih.id = ? AND ih.deleted_at IS NULL`, entryID, id).Scan(&inv)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, invalidf("invoice %s not found", id)
+		}
+		if inv.Status == models.InvoiceStatusVoid {
+			return nil, invalidf("invoice %s is void", id)
+		}
+		if inv.CompanyCode != entry.CompanyCode {
+			return nil, invalidf("invoice %s belongs to company %s, bank entry %s to %s", id, inv.CompanyCode, entryID, entry.CompanyCode)
+		}
+		if err := checkOutstanding(id, inv.TotalAmount, inv.ExistingMatched, inv.Credited, amounts[id]); err != nil {
+			return nil, err
+		}
+		st, err := Settle(tx, entry, inv.Currency, inv.InvoiceDate, amounts[id], bankAmounts[id], rates[id])
+		if err != nil {
+			var verr ValidationError
+			if errors.As(err, &verr) {
+				return nil, invalidf("invoice %s: %s", id, verr.Msg)
+			}
+			return nil, err
+		}
+		settled[id] = st
+	}
+
+	requested := 0.0
+	for _, st := range settled {
+		requested += st.BankAmount
+	}
+	if err := checkUnallocated(entry, kept, requested); err != nil {
+		return nil, err
+	}
+
+	previous := map[string]float64{}
+	if replace {
+		for _, l := range existing {
+			previous[l.InvoiceHeaderID] = l.MatchedAmount
+			if _, still := amounts[l.InvoiceHeaderID]; still {
+				continue
+			}
+			prev := l.MatchedAmount
+			if err := recordLinkEvent(tx, entryID, l.InvoiceHeaderID, models.LinkEventDetach, &prev, nil, note, actor); err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Delete(&models.BankEntryInvoice{}, "bank_entry_id = ?", entryID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	created := make([]models.BankEntryInvoice, 0, len(order))
+	for _, id := range order {
+		created = append(created, models.BankEntryInvoice{
+			BankEntryID:     entryID,
+			InvoiceHeaderID: id,
+			MatchedAmount:   amounts[id],
+			BankAmount:      settled[id].BankAmount,
+			Rate:            settled[id].Rate,
+			BaseAmount:      settled[id].BaseAmount,
+			FxGainLoss:      settled[id].FxGainLoss,
+			Note:            note,
+		})
+	}
+	if len(created) > 0 {
+		if err := tx.Create(&created).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range created {
+		amount := l.MatchedAmount
+		prev, had := previous[l.InvoiceHeaderID]
+		switch {
+		case !had:
+			if err := recordLinkEvent(tx, entryID, l.InvoiceHeaderID, models.LinkEventAttach, nil, &amount, note, actor); err != nil {
+				return nil, err
+			}
+		case math.Abs(prev-amount) > 0.005:
+			if err := recordLinkEvent(tx, entryID, l.InvoiceHeaderID, models.LinkEventAmountChange, &prev, &amount, note, actor); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// invoices dropped by replace mode need their status recomputed too
+	touched := append([]string{}, order...)
+	for id := range previous {
+		if _, ok := amounts[id]; !ok {
+			touched = append(touched, id)
+		}
+	}
+	if err := RefreshInvoiceStatus(tx, touched...); err != nil {
+		return nil, err
+	}
+	if err := PostBankEntry(tx, entryID, actor); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// checkOutstanding rejects matching amount to an invoice of total beyond
+// what payments from other bank entries and credit notes leave open.
+func checkOutstanding(id string, total, paid, credited, amount float64) error {
+	if paid+credited+amount > total+0.01 {
+		return invalidf("invoice %s is already fully paid or amount exceeds outstanding (Total: %.2f, Paid: %.2f, Credited: %.2f, New: %.2f)", id, total, paid, credited, amount)
+	}
+	return nil
+}
+
+// checkUnallocated rejects allocations of requested, in the entry's
+// currency, beyond the remainder the kept links leave of the entry.
+func checkUnallocated(entry models.BankEntry, kept, requested float64) error {
+	if unallocated := round2(entry.Amount - kept); requested > unallocated+0.01 {
+		return invalidf("allocations %.2f %s exceed the unallocated amount %.2f of bank entry %s (amount %.2f)", requested, entry.Currency, unallocated, entry.ID, entry.Amount)
+	}
+	return nil
+}
+
+// DetachInvoice removes a single bank entry/invoice link and records it.
+func DetachInvoice(tx *gorm.DB, entryID, invoiceID, note, actor string) error {
+	var link models.BankEntryInvoice
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&link, "bank_entry_id = ? AND invoice_header_id = ?", entryID, invoiceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return invalidf("invoice %s is not attached to bank entry %s", invoiceID, entryID)
+		}
+		return err
+	}
+	if err := tx.Delete(&models.BankEntryInvoice{}, "bank_entry_id = ? AND invoice_header_id = ?", entryID, invoiceID).Error; err != nil {
+		return err
+	}
+	prev := link.MatchedAmount
+	if err := recordLinkEvent(tx, entryID, invoiceID, models.LinkEventDetach, &prev, nil, note, actor); err != nil {
+		return err
+	}
+	if err := RefreshInvoiceStatus(tx, invoiceID); err != nil {
+		return err
+	}
+	return PostBankEntry(tx, entryID, actor)
+}
+
+func recordLinkEvent(tx *gorm.DB, entryID, invoiceID, action string, prev, next *float64, note, actor string) error {
+	if actor == "" {
+		actor = "system"
+	}
+	if err := tx.Create(&models.BankEntryInvoiceEvent{
+		BankEntryID:     entryID,
+		InvoiceHeaderID: invoiceID,
+		Action:          action,
+		PreviousAmount:  prev,
+		NewAmount:       next,
+		Note:            note,
+		Actor:           actor,
+	}).Error; err != nil {
+		return err
+	}
+	return auditLink(tx, entryID, "invoiceId", invoiceID, prev, next, actor)
+}
+
+// auditLink records a change to one invoice link of a bank entry in the
+// audit log; prev is nil for a new link and next for a removed one.
+func auditLink(tx *gorm.DB, entryID, key string, invoiceID any, prev, next *float64, actor string) error {
+	var company string
+	if err := tx.Unscoped().Model(&models.BankEntry{}).Select("company_code").Where("id = ?", entryID).Scan(&company).Error; err != nil {
+		return err
+	}
+	action := models.AuditActionReconcile
+	if next == nil {
+		action = models.AuditActionUnreconcile
+	}
+	var before, after any
+	if prev != nil {
+		before = map[string]any{key: invoiceID, "matchedAmount": *prev}
+	}
+	if next != nil {
+		after = map[string]any{key: invoiceID, "matchedAmount": *next}
+	}
+	return RecordAudit(tx, actor, models.AuditEntityBankEntry, entryID, company, action, before, after)
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAutoPick(t *testing.T) {
+	day := time.Date(2025, 3, 5, 0, 0, 0, 0, time.UTC)
+	open := func(id, no string, outstanding float64) OpenInvoice {
+		return OpenInvoice{ID: id, InvoiceNo: no, InvoiceDate: day, CompanyCode: "A", Currency: "IDR", Outstanding: outstanding}
+	}
+	invoices := []OpenInvoice{
+		open("i1", "INV-0001", 1000),
+		open("i2", "INV-0002", 2500),
+		open("i3", "INV-0003", 2500),
+		open("i4", "INV-0004", 0),
+		{ID: "i5", InvoiceNo: "INV-0005", InvoiceDate: day, CompanyCode: "B", Currency: "IDR", Outstanding: 700},
+		{ID: "i6", InvoiceNo: "INV-0006", InvoiceDate: day.AddDate(0, -1, 0), CompanyCode: "A", Currency: "IDR", Outstanding: 900},
+	}
+	locked := map[string]bool{PeriodKey("A", PeriodOf(day.AddDate(0, -1, 0))): true}
+	entry := func(desc string, amount float64) models.BankEntry {
+		return models.BankEntry{ID: "e", CompanyCode: "A", Currency: "IDR", Amount: amount, AmountType: "CR", Description: desc}
+	}
+	tests := []struct {
+		name          string
+		entry         models.BankEntry
+		rules         []string
+		wantID        string
+		wantRule      string
+		wantAmbiguous []AutoAmbiguous
+	}{
+		{
+			name:     "invoice number",
+			entry:    entry("PAY INV0001", 1000),
+			rules:    DefaultAutoRules,
+			wantID:   "i1",
+			wantRule: RuleInvoiceNo,
+		},
+		{
+			name:     "exact amount when no number is named",
+			entry:    entry("TRANSFER", 1000),
+			rules:    DefaultAutoRules,
+			wantID:   "i1",
+			wantRule: RuleExactAmount,
+		},
+		{
+			name:     "several numbers but one amount",
+			entry:    entry("INV-0001 INV-0002", 1000),
+			rules:    DefaultAutoRules,
+			wantID:   "i1",
+			wantRule: RuleExactAmount,
+		},
+		{
+			name:     "several amounts but one number",
+			entry:    entry("INV-0002", 2500),
+			rules:    []string{RuleExactAmount, RuleInvoiceNo},
+			wantID:   "i2",
+			wantRule: RuleInvoiceNo,
+		},
+		{
+			name:   "ambiguous under every rule",
+			entry:  entry("INV-0002 INV-0003", 2500),
+			rules:  DefaultAutoRules,
+			wantID: "",
+			wantAmbiguous: []AutoAmbiguous{
+				{BankEntryID: "e", Rule: RuleInvoiceNo, Candidates: []string{"i2", "i3"}},
+				{BankEntryID: "e", Rule: RuleExactAmount, Candidates: []string{"i2", "i3"}},
+			},
+		},
+		{
+			name:  "settled, other company and locked invoices are skipped",
+			entry: entry("INV-0004 INV-0005 INV-0006", 900),
+			rules: DefaultAutoRules,
+		},
+		{
+			name:  "only configured rules run",
+			entry: entry("TRANSFER", 1000),
+			rules: []string{RuleInvoiceNo},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, rule, ambiguous := autoPick(tt.entry, invoices, locked, tt.rules)
+			gotID := ""
+			if i >= 0 {
+				gotID = invoices[i].ID
+			}
+			if gotID != tt.wantID || rule != tt.wantRule {
+				t.Errorf("pick = %q by %q, want %q by %q", gotID, rule, tt.wantID, tt.wantRule)
+			}
+			if i >= 0 && ambiguous != nil {
+				t.Errorf("ambiguous = %+v alongside a unique pick", ambiguous)
+			}
+			if !reflect.DeepEqual(ambiguous, tt.wantAmbiguous) {
+				t.Errorf("ambiguous = %+v, want %+v", ambiguous, tt.wantAmbiguous)
+			}
+		})
+	}
+}
+
+func TestCheckUnallocated(t *testing.T) {
+	entry := models.BankEntry{ID: "e", Amount: 1000, Currency: "IDR"}
+	tests := []struct {
+		name            string
+		kept, requested float64
+		wantErr         bool
+	}{
+		{"whole entry", 0, 1000, false},
+		{"within a cent", 0, 1000.01, false},
+		{"over-allocated", 0, 1000.02, true},
+		{"rest after kept links", 600, 400, false},
+		{"over the rest after kept links", 600, 450, true},
+		{"fully allocated already", 1000, 0.5, true},
+	}
+	for _, tt := range tests {
+		err := checkUnallocated(entry, tt.kept, tt.requested)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if _, ok := err.(ValidationError); err != nil && !ok {
+			t.Errorf("%s: error = %T, want ValidationError", tt.name, err)
+		}
+	}
+}
+
+func TestCheckOutstanding(t *testing.T) {
+	tests := []struct {
+		name                          string
+		total, paid, credited, amount float64
+		wantErr                       bool
+	}{
+		{"open invoice paid in full", 1000, 0, 0, 1000, false},
+		{"split over entries", 1000, 600, 0, 400, false},
+		{"credit note leaves less open", 1000, 0, 300, 700, false},
+		{"beyond payments and credit", 1000, 600, 300, 200, true},
+		{"already paid", 1000, 1000, 0, 0.02, true},
+	}
+	for _, tt := range tests {
+		err := checkOutstanding("i1", tt.total, tt.paid, tt.credited, tt.amount)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
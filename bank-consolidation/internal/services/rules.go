@@ -0,0 +1,302 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CategoryRule is one entry of Category.BusinessRules, which holds a JSON
+// array of rules. All conditions that are set must hold; text comparisons
+// ignore case. A category matches when any of its rules does.
+type CategoryRule struct {
+	ID                  string   `json:"id"`
+	DescriptionRegex    string   `json:"descriptionRegex,omitempty"`
+	DescriptionContains []string `json:"descriptionContains,omitempty"`
+	MinAmount           *float64 `json:"minAmount,omitempty"`
+	MaxAmount           *float64 `json:"maxAmount,omitempty"`
+	AmountType          string   `json:"amountType,omitempty"`
+	BankCode            string   `json:"bankCode,omitempty"`
+	BankAccountID       string   `json:"bankAccountId,omitempty"`
+	Branch              string   `json:"branch,omitempty"`
+	Counterparty        string   `json:"counterparty,omitempty"`
+	Priority            int      `json:"priority,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// RuleSubject is what rules are evaluated against, built from a bank entry
+// or a transaction.
+type RuleSubject struct {
+	Kind          string  `json:"kind"`
+	ID            string  `json:"id"`
+	CompanyCode   string  `json:"companyCode"`
+	Description   string  `json:"description"`
+	Amount        float64 `json:"amount"`
+	AmountType    string  `json:"amountType"`
+	BankCode      string  `json:"bankCode"`
+	BankAccountID string  `json:"bankAccountId"`
+	Branch        string  `json:"branch"`
+	Counterparty  string  `json:"counterparty"`
+}
+
+const (
+	SubjectBankEntry   = "bank_entry"
+	SubjectTransaction = "transaction"
+)
+
+// ParseRules reads and validates a BusinessRules value. Empty and null
+// values mean no rules. Rules without an ID are numbered in order.
+func ParseRules(raw string) ([]CategoryRule, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "null" || raw == "{}" {
+		return nil, nil
+	}
+	var rules []CategoryRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("businessRules must be an array of rules: %w", err)
+	}
+	seen := map[string]bool{}
+	for i := range rules {
+		r := &rules[i]
+		if r.ID == "" {
+			r.ID = "rule-" + strconv.Itoa(i+1)
+		}
+		if seen[r.ID] {
+			return nil, fmt.Errorf("businessRules[%d]: duplicate id %q", i, r.ID)
+		}
+		seen[r.ID] = true
+		if r.DescriptionRegex != "" {
+			re, err := regexp.Compile("(?i)" + r.DescriptionRegex)
+			if err != nil {
+				return nil, fmt.Errorf("businessRules[%d].descriptionRegex: %v", i, err)
+			}
+			r.re = re
+		}
+		r.AmountType = strings.ToUpper(strings.TrimSpace(r.AmountType))
+		if r.AmountType != "" && r.AmountType != "CR" && r.AmountType != "DB" {
+			return nil, fmt.Errorf("businessRules[%d].amountType must be CR or DB", i)
+		}
+		if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+			return nil, fmt.Errorf("businessRules[%d]: minAmount is greater than maxAmount", i)
+		}
+		if r.re == nil && len(r.DescriptionContains) == 0 && r.MinAmount == nil && r.MaxAmount == nil &&
+			r.AmountType == "" && r.BankCode == "" && r.BankAccountID == "" && r.Branch == "" && r.Counterparty == "" {
+			return nil, fmt.Errorf("businessRules[%d] has no conditions", i)
+		}
+	}
+	return rules, nil
+}
+
+// Matches reports whether every condition of the rule holds for s.
+func (r CategoryRule) Matches(s RuleSubject) bool {
+	if r.re != nil && !r.re.MatchString(s.Description) {
+		return false
+	}
+	desc := strings.ToUpper(s.Description)
+	for _, part := range r.DescriptionContains {
+		if !strings.Contains(desc, strings.ToUpper(part)) {
+			return false
+		}
+	}
+	amount := math.Abs(s.Amount)
+	if r.MinAmount != nil && amount < *r.MinAmount {
+		return false
+	}
+	if r.MaxAmount != nil && amount > *r.MaxAmount {
+		return false
+	}
+	if r.AmountType != "" && r.AmountType != s.AmountType {
+		return false
+	}
+	if r.BankCode != "" && !strings.EqualFold(r.BankCode, s.BankCode) {
+		return false
+	}
+	if r.BankAccountID != "" && r.BankAccountID != s.BankAccountID {
+		return false
+	}
+	if r.Branch != "" && r.Branch != s.Branch {
+		return false
+	}
+	if r.Counterparty != "" && !strings.Contains(strings.ToUpper(s.Counterparty), strings.ToUpper(r.Counterparty)) {
+		return false
+	}
+	return true
+}
+
+// CategoryRules are the parsed rules of one category, ordered by priority.
+// With a CompanyCode they only apply to subjects of that company.
+type CategoryRules struct {
+	CategoryID  string
+	CompanyCode string
+	Rules       []CategoryRule
+}
+
+// RuleHit names the rule that assigned a category.
+type RuleHit struct {
+	CategoryID string `json:"categoryId"`
+	RuleID     string `json:"ruleId"`
+}
+
+type RuleSet []CategoryRules
+
+// NewCategoryRules orders rules so the highest priority fires first.
+func NewCategoryRules(categoryID, companyCode string, rules []CategoryRule) CategoryRules {
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority > rules[j].Priority })
+	return CategoryRules{CategoryID: categoryID, CompanyCode: companyCode, Rules: rules}
+}
+
+// LoadRuleSet parses the rules of all categories. Categories with invalid
+// rules are skipped so that one bad category does not stop imports.
+func LoadRuleSet(db *gorm.DB) (RuleSet, error) {
+	var cats []models.Category
+	if err := db.Select("id", "company_code", "business_rules").Where("business_rules IS NOT NULL AND business_rules <> ''").Order("id").Find(&cats).Error; err != nil {
+		return nil, err
+	}
+	var rs RuleSet
+	for _, c := range cats {
+		rules, err := ParseRules(c.BusinessRules)
+		if err != nil || len(rules) == 0 {
+			continue
+		}
+		rs = append(rs, NewCategoryRules(c.ID, c.CompanyCode, rules))
+	}
+	return rs, nil
+}
+
+// Match returns, per category, the first rule that fires for s.
+func (rs RuleSet) Match(s RuleSubject) []RuleHit {
+	var hits []RuleHit
+	for _, cr := range rs {
+		if r, ok := cr.First(s); ok {
+			hits = append(hits, RuleHit{CategoryID: cr.CategoryID, RuleID: r.ID})
+		}
+	}
+	return hits
+}
+
+// First returns the first rule of the category that fires for s.
+func (cr CategoryRules) First(s RuleSubject) (CategoryRule, bool) {
+	if cr.CompanyCode != "" && cr.CompanyCode != s.CompanyCode {
+		return CategoryRule{}, false
+	}
+	for _, r := range cr.Rules {
+		if r.Matches(s) {
+			return r, true
+		}
+	}
+	return CategoryRule{}, false
+}
+
+func BankEntrySubject(e models.BankEntry) RuleSubject {
+	return RuleSubject{
+		Kind:          SubjectBankEntry,
+		ID:            e.ID,
+		CompanyCode:   e.CompanyCode,
+		Description:   strings.TrimSpace(e.Description + " " + e.RemittanceInfo),
+		Amount:        e.Amount,
+		AmountType:    e.AmountType,
+		BankCode:      e.BankCode,
+		BankAccountID: e.BankAccountID,
+		Branch:        e.Branch,
+		Counterparty:  e.CounterpartyName,
+	}
+}
+
+// TransactionSubject summarises a transaction's valid rows (t.Rows must be
+// loaded): descriptions are joined, the amount is the absolute net and the
+// direction follows its sign.
+func TransactionSubject(t models.Transaction) RuleSubject {
+	s := RuleSubject{Kind: SubjectTransaction, ID: t.ID, CompanyCode: t.CompanyCode, AmountType: "CR"}
+	var desc []string
+	for _, r := range t.Rows {
+		if !r.Valid {
+			continue
+		}
+		desc = append(desc, r.Description)
+		if s.Branch == "" {
+			s.Branch = r.Branch
+		}
+	}
+	s.Description = strings.Join(desc, " | ")
+	net := netRows(t.Rows)
+	if net < 0 {
+		s.AmountType = "DB"
+	}
+	s.Amount = math.Abs(net)
+	return s
+}
+
+// transactionRowSubject is a single row of t on its own.
+func transactionRowSubject(t models.Transaction, r models.TransactionRow) RuleSubject {
+	s := RuleSubject{Kind: SubjectTransaction, ID: t.ID, CompanyCode: t.CompanyCode, AmountType: "CR",
+		Description: r.Description, Branch: r.Branch, Amount: r.Amount}
+	if r.AmountType == "DB" {
+		s.AmountType = "DB"
+	}
+	return s
+}
+
+// CategorizeBankEntry stores the categories whose rules fire for e. Existing
+// assignments, manual or not, are left alone.
+func CategorizeBankEntry(tx *gorm.DB, rs RuleSet, e models.BankEntry) ([]RuleHit, error) {
+	hits := rs.Match(BankEntrySubject(e))
+	for _, h := range hits {
+		link := models.BankEntryCategory{BankEntryID: e.ID, CategoryID: h.CategoryID, RuleID: h.RuleID, AssignedBy: models.AssignedByRule}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&link).Error; err != nil {
+			return nil, err
+		}
+	}
+	return hits, nil
+}
+
+// CategorizeTransaction stores the categories whose rules fire for t, whose
+// Rows must be loaded. When several fire, each gets the amount of the rows
+// it fires for on its own (see rowsAmount).
+func CategorizeTransaction(tx *gorm.DB, rs RuleSet, t models.Transaction) ([]RuleHit, error) {
+	hits := rs.Match(TransactionSubject(t))
+	for _, h := range hits {
+		link := models.TransactionCategory{TransactionID: t.ID, CategoryID: h.CategoryID, RuleID: h.RuleID, AssignedBy: models.AssignedByRule}
+		if len(hits) > 1 {
+			link.Amount = rs.rowsAmount(h.CategoryID, t)
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&link).Error; err != nil {
+			return nil, err
+		}
+	}
+	return hits, nil
+}
+
+// rowsAmount is the absolute net of the valid rows of t that the rules of
+// category fire for on their own, nil when none does. It is what the
+// category posts when several categories share a transaction.
+func (rs RuleSet) rowsAmount(category string, t models.Transaction) *float64 {
+	for _, cr := range rs {
+		if cr.CategoryID != category {
+			continue
+		}
+		var rows []models.TransactionRow
+		for _, r := range t.Rows {
+			if !r.Valid {
+				continue
+			}
+			if _, ok := cr.First(transactionRowSubject(t, r)); ok {
+				rows = append(rows, r)
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		amount := math.Abs(netRows(rows))
+		return &amount
+	}
+	return nil
+}
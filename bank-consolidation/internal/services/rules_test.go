@@ -0,0 +1,208 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantIDs []string
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"null", "null", nil, false},
+		{"empty object", "{}", nil, false},
+		{"numbered ids", `[{"descriptionContains":["GAJI"]},{"id":"fee","amountType":"db"}]`, []string{"rule-1", "fee"}, false},
+		{"not an array", `{"id":"x"}`, nil, true},
+		{"duplicate id", `[{"id":"a","branch":"01"},{"id":"a","branch":"02"}]`, nil, true},
+		{"bad regex", `[{"descriptionRegex":"("}]`, nil, true},
+		{"bad amount type", `[{"amountType":"XX"}]`, nil, true},
+		{"min above max", `[{"minAmount":10,"maxAmount":5}]`, nil, true},
+		{"no conditions", `[{"id":"empty","priority":3}]`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(rules) != len(tt.wantIDs) {
+				t.Fatalf("rules = %+v, want ids %v", rules, tt.wantIDs)
+			}
+			for i, id := range tt.wantIDs {
+				if rules[i].ID != id {
+					t.Errorf("rules[%d].ID = %q, want %q", i, rules[i].ID, id)
+				}
+			}
+		})
+	}
+}
+
+func TestCategoryRuleMatches(t *testing.T) {
+	subject := RuleSubject{
+		Description:   "TRSF E-BANKING CR GAJI Januari",
+		Amount:        -1500000,
+		AmountType:    "DB",
+		BankCode:      "bca",
+		BankAccountID: "acc-1",
+		Branch:        "0123",
+		Counterparty:  "PT Sumber Makmur",
+	}
+	tests := []struct {
+		name string
+		raw  string
+		want bool
+	}{
+		{"regex ignores case", `[{"descriptionRegex":"gaji\\s+jan"}]`, true},
+		{"regex misses", `[{"descriptionRegex":"^SETOR"}]`, false},
+		{"all contains parts", `[{"descriptionContains":["trsf","gaji"]}]`, true},
+		{"one contains part missing", `[{"descriptionContains":["trsf","bonus"]}]`, false},
+		{"amount range uses absolute value", `[{"minAmount":1000000,"maxAmount":2000000}]`, true},
+		{"below min", `[{"minAmount":2000000}]`, false},
+		{"above max", `[{"maxAmount":1000000}]`, false},
+		{"amount type", `[{"amountType":"db"}]`, true},
+		{"wrong amount type", `[{"amountType":"CR"}]`, false},
+		{"bank code ignores case", `[{"bankCode":"BCA"}]`, true},
+		{"bank account", `[{"bankAccountId":"acc-2"}]`, false},
+		{"branch", `[{"branch":"0123"}]`, true},
+		{"counterparty substring", `[{"counterparty":"sumber"}]`, true},
+		{"every condition must hold", `[{"branch":"0123","amountType":"CR"}]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rules[0].Matches(subject); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleSetMatch(t *testing.T) {
+	low, err := ParseRules(`[{"id":"low","descriptionContains":["ADM"]},{"id":"high","descriptionContains":["BIAYA ADM"],"priority":10}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped, err := ParseRules(`[{"id":"scoped","descriptionContains":["ADM"]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := RuleSet{
+		NewCategoryRules("fees", "", low),
+		NewCategoryRules("fees-b", "B", scoped),
+	}
+	tests := []struct {
+		name    string
+		subject RuleSubject
+		want    []RuleHit
+	}{
+		{"priority first, other company skipped", RuleSubject{CompanyCode: "A", Description: "BIAYA ADM BANK"}, []RuleHit{{"fees", "high"}}},
+		{"lower priority when higher misses", RuleSubject{CompanyCode: "A", Description: "ADM"}, []RuleHit{{"fees", "low"}}},
+		{"company scoped category applies", RuleSubject{CompanyCode: "B", Description: "ADM"}, []RuleHit{{"fees", "low"}, {"fees-b", "scoped"}}},
+		{"no match", RuleSubject{CompanyCode: "B", Description: "SETORAN"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rs.Match(tt.subject)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Match = %+v, want %+v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("hit %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionSubject(t *testing.T) {
+	tests := []struct {
+		name           string
+		rows           []models.TransactionRow
+		wantAmount     float64
+		wantAmountType string
+		wantDesc       string
+		wantBranch     string
+	}{
+		{
+			name: "credits net of debits",
+			rows: []models.TransactionRow{
+				{Description: "SETOR", Branch: "01", Amount: 1000, AmountType: "CR", Valid: true},
+				{Description: "FEE", Branch: "02", Amount: 250.25, AmountType: "DB", Valid: true},
+			},
+			wantAmount: 749.75, wantAmountType: "CR", wantDesc: "SETOR | FEE", wantBranch: "01",
+		},
+		{
+			name: "net debit",
+			rows: []models.TransactionRow{
+				{Description: "SETOR", Amount: 100, AmountType: "CR", Valid: true},
+				{Description: "TARIK", Branch: "03", Amount: 300, AmountType: "DB", Valid: true},
+			},
+			wantAmount: 200, wantAmountType: "DB", wantDesc: "SETOR | TARIK", wantBranch: "03",
+		},
+		{
+			name: "invalid rows skipped",
+			rows: []models.TransactionRow{
+				{Description: "BROKEN", Branch: "09", Amount: 5000, AmountType: "DB"},
+				{Description: "SETOR", Amount: 100, AmountType: "CR", Valid: true},
+			},
+			wantAmount: 100, wantAmountType: "CR", wantDesc: "SETOR",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := TransactionSubject(models.Transaction{ID: "t1", CompanyCode: "A", Rows: tt.rows})
+			if s.Kind != SubjectTransaction || s.ID != "t1" || s.CompanyCode != "A" {
+				t.Errorf("subject identity = %+v", s)
+			}
+			if s.Amount != tt.wantAmount || s.AmountType != tt.wantAmountType {
+				t.Errorf("amount = %v %s, want %v %s", s.Amount, s.AmountType, tt.wantAmount, tt.wantAmountType)
+			}
+			if s.Description != tt.wantDesc || s.Branch != tt.wantBranch {
+				t.Errorf("description/branch = %q/%q, want %q/%q", s.Description, s.Branch, tt.wantDesc, tt.wantBranch)
+			}
+		})
+	}
+}
+
+func TestRuleSetRowsAmount(t *testing.T) {
+	salary, err := ParseRules(`[{"id":"gaji","descriptionContains":["GAJI"]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fees, err := ParseRules(`[{"id":"adm","descriptionContains":["ADM"],"amountType":"DB"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := RuleSet{NewCategoryRules("salary", "", salary), NewCategoryRules("fees", "", fees)}
+	tx := models.Transaction{ID: "t1", CompanyCode: "A", Rows: []models.TransactionRow{
+		{Description: "GAJI JAN", Amount: 900, AmountType: "DB", Valid: true},
+		{Description: "GAJI FEB", Amount: 950, AmountType: "DB", Valid: true},
+		{Description: "BIAYA ADM", Amount: 7.5, AmountType: "DB", Valid: true},
+		{Description: "GAJI KOREKSI", Amount: 50, AmountType: "CR", Valid: true},
+		{Description: "GAJI BROKEN", Amount: 5000, AmountType: "DB"},
+	}}
+	tests := []struct {
+		category string
+		want     *float64
+	}{
+		{"salary", func() *float64 { v := 1800.0; return &v }()},
+		{"fees", func() *float64 { v := 7.5; return &v }()},
+		{"unknown", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.category, func(t *testing.T) {
+			got := rs.rowsAmount(tt.category, tx)
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("rowsAmount = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var termDigits = regexp.MustCompile(`\d+`)
+
+// ParseTermDays reads payment terms such as "30", "NET 30", "N30",
+// "30 hari" or "COD" as a number of days.
+func ParseTermDays(termin string) (int, error) {
+	t := strings.ToUpper(strings.TrimSpace(termin))
+	switch t {
+	case "COD", "CBD", "CASH", "TUNAI", "IMMEDIATE":
+		return 0, nil
+	}
+	m := termDigits.FindAllString(t, -1)
+	if len(m) != 1 {
+		return 0, fmt.Errorf("unrecognised termin %q", termin)
+	}
+	days, err := strconv.Atoi(m[0])
+	if err != nil || days > 3650 {
+		return 0, fmt.Errorf("unrecognised termin %q", termin)
+	}
+	return days, nil
+}
+
+// ApplyPaymentTerms fills whichever of DueDate and TermDays is missing from
+// the other (or from Termin) and checks they agree with the invoice date.
+func ApplyPaymentTerms(h *models.InvoiceHeader) error {
+	if h.TermDays == nil && strings.TrimSpace(h.Termin) != "" {
+		days, err := ParseTermDays(h.Termin)
+		if err != nil {
+			return err
+		}
+		h.TermDays = &days
+	}
+	if h.TermDays != nil && *h.TermDays < 0 {
+		return errors.New("termDays must not be negative")
+	}
+
+	invoiceDay := truncateDay(h.InvoiceDate)
+	switch {
+	case h.DueDate == nil && h.TermDays != nil:
+		due := invoiceDay.AddDate(0, 0, *h.TermDays)
+		h.DueDate = &due
+	case h.DueDate != nil && h.TermDays == nil && !h.InvoiceDate.IsZero():
+		days := int(math.Round(truncateDay(*h.DueDate).Sub(invoiceDay).Hours() / 24))
+		h.TermDays = &days
+	}
+	if h.DueDate != nil && !h.InvoiceDate.IsZero() && truncateDay(*h.DueDate).Before(invoiceDay) {
+		return errors.New("dueDate must not be before invoiceDate")
+	}
+	return nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
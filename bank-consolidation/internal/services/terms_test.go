@@ -0,0 +1,81 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"testing"
+	"time"
+)
+
+func TestParseTermDays(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"30", 30, false},
+		{"NET 30", 30, false},
+		{"n45", 45, false},
+		{"60 hari", 60, false},
+		{" cod ", 0, false},
+		{"Tunai", 0, false},
+		{"CBD", 0, false},
+		{"3650", 3650, false},
+		{"3651", 0, true},
+		{"30/60", 0, true},
+		{"", 0, true},
+		{"net", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTermDays(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTermDays(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTermDays(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPaymentTerms(t *testing.T) {
+	day := func(d int) *time.Time {
+		v := time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC)
+		return &v
+	}
+	days := func(n int) *int { return &n }
+	invoiceDate := time.Date(2025, 1, 1, 14, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		header       models.InvoiceHeader
+		wantDue      *time.Time
+		wantTermDays *int
+		wantErr      bool
+	}{
+		{"nothing set", models.InvoiceHeader{InvoiceDate: invoiceDate}, nil, nil, false},
+		{"due from termin", models.InvoiceHeader{InvoiceDate: invoiceDate, Termin: "NET 14"}, day(15), days(14), false},
+		{"due from term days", models.InvoiceHeader{InvoiceDate: invoiceDate, TermDays: days(30), Termin: "NET 14"}, day(31), days(30), false},
+		{"term days from due", models.InvoiceHeader{InvoiceDate: invoiceDate, DueDate: day(21)}, day(21), days(20), false},
+		{"both kept", models.InvoiceHeader{InvoiceDate: invoiceDate, DueDate: day(10), TermDays: days(30)}, day(10), days(30), false},
+		{"bad termin", models.InvoiceHeader{InvoiceDate: invoiceDate, Termin: "later"}, nil, nil, true},
+		{"negative term days", models.InvoiceHeader{InvoiceDate: invoiceDate, TermDays: days(-1)}, nil, nil, true},
+		{"due before invoice", models.InvoiceHeader{InvoiceDate: time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC), DueDate: day(4)}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.header
+			err := ApplyPaymentTerms(&h)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (h.DueDate == nil) != (tt.wantDue == nil) || h.DueDate != nil && !h.DueDate.Equal(*tt.wantDue) {
+				t.Errorf("DueDate = %v, want %v", h.DueDate, tt.wantDue)
+			}
+			if (h.TermDays == nil) != (tt.wantTermDays == nil) || h.TermDays != nil && *h.TermDays != *tt.wantTermDays {
+				t.Errorf("TermDays = %v, want %v", h.TermDays, tt.wantTermDays)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bank-consolidation/internal/statements"
+	"bank-consolidation/models"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// builtinTransactionSchema applies when neither the transaction's source nor
+// the "*" default has a stored schema.
+var builtinTransactionSchema = models.TransactionSchema{
+	ImportSource:      models.DefaultTransactionSchema,
+	Delimiter:         ",",
+	DateColumn:        "date",
+	DescriptionColumn: "description",
+	AmountColumn:      "amount",
+	CreditMarker:      "CR",
+	DebitMarker:       "DB",
+	DecimalSeparator:  ".",
+}
+
+// TransactionSchemaFor returns the schema for an import source, falling back
+// to the "*" schema and then to a date,description,amount layout.
+func TransactionSchemaFor(db *gorm.DB, source string) (models.TransactionSchema, error) {
+	var list []models.TransactionSchema
+	if err := db.Where("import_source IN ?", []string{source, models.DefaultTransactionSchema}).Find(&list).Error; err != nil {
+		return models.TransactionSchema{}, err
+	}
+	schema := builtinTransactionSchema
+	for _, s := range list {
+		if s.ImportSource == source {
+			return s, nil
+		}
+		schema = s
+	}
+	return schema, nil
+}
+
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type TransactionValidation struct {
+	TransactionID string     `json:"transactionId"`
+	Status        string     `json:"validationStatus"`
+	Schema        string     `json:"schema"`
+	Rows          int        `json:"rows"`
+	ValidRows     int        `json:"validRows"`
+	Error         string     `json:"error,omitempty"`
+	RowErrors     []RowError `json:"rowErrors"`
+	// PostingError is why the journal could not be brought in line, e.g. a
+	// category whose account is inactive. The transaction is kept unposted.
+	PostingError string `json:"postingError,omitempty"`
+}
+
+// ValidateTransaction parses a transaction's RawCSV against its schema,
+// replaces its transaction_rows and moves it to valid or invalid. A
+// transaction is valid when the file could be read, has at least one row
+// and no row has an error.
+func ValidateTransaction(tx *gorm.DB, id string) (TransactionValidation, error) {
+	res := TransactionValidation{TransactionID: id, RowErrors: []RowError{}}
+
+	var t models.Transaction
+	if err := tx.First(&t, "id = ?", id).Error; err != nil {
+		return res, err
+	}
+	schema, err := TransactionSchemaFor(tx, t.ImportSource)
+	if err != nil {
+		return res, err
+	}
+	res.Schema = schema.ImportSource
+
+	rows, fileErr := parseTransactionCSV(t, schema)
+	res.tally(rows, fileErr)
+
+	if err := tx.Where("transaction_id = ?", id).Delete(&models.TransactionRow{}).Error; err != nil {
+		return res, err
+	}
+	if len(rows) > 0 {
+		if err := tx.CreateInBatches(rows, 500).Error; err != nil {
+			return res, err
+		}
+	}
+	now := time.Now()
+	err = tx.Model(&models.Transaction{}).Where("id = ?", id).Updates(map[string]any{
+		"validation_status": res.Status,
+		"validation_error":  res.Error,
+		"row_count":         res.Rows,
+		"error_count":       len(res.RowErrors),
+		"validated_at":      now,
+	}).Error
+	return res, err
+}
+
+// tally counts the parsed rows into res and sets its status.
+func (res *TransactionValidation) tally(rows []models.TransactionRow, fileErr error) {
+	for _, r := range rows {
+		res.Rows++
+		if r.Valid {
+			res.ValidRows++
+		} else {
+			res.RowErrors = append(res.RowErrors, RowError{Line: r.Line, Error: r.Error})
+		}
+	}
+	switch {
+	case fileErr != nil:
+		res.Error = fileErr.Error()
+	case res.Rows == 0:
+		res.Error = "no data rows"
+	}
+	res.Status = models.TransactionStatusValid
+	if res.Error != "" || len(res.RowErrors) > 0 {
+		res.Status = models.TransactionStatusInvalid
+	}
+}
+
+func parseTransactionCSV(t models.Transaction, schema models.TransactionSchema) ([]models.TransactionRow, error) {
+	if strings.HasPrefix(strings.TrimSpace(t.RawCSV), "{") {
+		return nil, errors.New("rawCsv holds JSON, not CSV; resend the transaction with its CSV in rawCsv")
+	}
+	parsed, err := statements.ParseCSV(strings.NewReader(t.RawCSV), schema.Profile())
+	if err != nil {
+		return nil, err
+	}
+
+	cols := map[string]int{}
+	for i, h := range parsed.Header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	required := schema.Required()
+	for _, c := range required {
+		if _, ok := cols[strings.ToLower(c)]; !ok {
+			return nil, fmt.Errorf("required column %q not found in header", c)
+		}
+	}
+	field := func(rec []string, name string) string {
+		i, ok := cols[strings.ToLower(strings.TrimSpace(name))]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	rows := make([]models.TransactionRow, 0, len(parsed.Rows))
+	for _, p := range parsed.Rows {
+		row := models.TransactionRow{
+			TransactionID: t.ID,
+			Line:          p.Line,
+			Description:   p.Entry.Description,
+			Branch:        p.Entry.Branch,
+			Amount:        round2(p.Entry.Amount),
+			AmountType:    p.Entry.AmountType,
+		}
+		if schema.ReferenceColumn != "" {
+			row.Reference = field(p.Record, schema.ReferenceColumn)
+		}
+		if !p.Entry.TransactionDate.IsZero() {
+			d := p.Entry.TransactionDate
+			row.TransactionDate = &d
+		}
+
+		var missing []string
+		for _, c := range required {
+			if field(p.Record, c) == "" {
+				missing = append(missing, c)
+			}
+		}
+		switch {
+		case p.Err != nil:
+			row.Error = p.Err.Error()
+		case len(missing) > 0:
+			row.Error = "empty required column(s): " + strings.Join(missing, ", ")
+		case strings.TrimSpace(row.Description) == "":
+			row.Error = "description is empty"
+		default:
+			row.Valid = true
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// transactionFigures nets the valid rows of a validated transaction. The
+// amount is the absolute net and the date the latest row date. Transactions
+// that are not valid or net to zero are not posted.
+func transactionFigures(tx *gorm.DB, t models.Transaction) (amount float64, date time.Time, ok bool, err error) {
+	if t.ValidationStatus != models.TransactionStatusValid {
+		return 0, time.Time{}, false, nil
+	}
+	var rows []models.TransactionRow
+	if err := tx.Where("transaction_id = ? AND valid = ?", t.ID, true).Find(&rows).Error; err != nil {
+		return 0, time.Time{}, false, err
+	}
+	net := netRows(rows)
+	for _, r := range rows {
+		if r.TransactionDate != nil && r.TransactionDate.After(date) {
+			date = *r.TransactionDate
+		}
+	}
+	amount = round2(math.Abs(net))
+	return amount, date, amount != 0, nil
+}
+
+func netRows(rows []models.TransactionRow) float64 {
+	net := 0.0
+	for _, r := range rows {
+		if !r.Valid {
+			continue
+		}
+		if r.AmountType == "DB" {
+			net -= r.Amount
+		} else {
+			net += r.Amount
+		}
+	}
+	return round2(net)
+}
+
+// ProcessTransaction validates a stored transaction, categorizes it by rules
+// when it is valid and brings its journal in line with the result. A posting
+// that breaks a business rule is reported in PostingError rather than
+// failing, so one misconfigured category does not block ingest.
+func ProcessTransaction(tx *gorm.DB, rs RuleSet, id, actor string) (TransactionValidation, []RuleHit, error) {
+	res, err := ValidateTransaction(tx, id)
+	if err != nil {
+		return res, nil, err
+	}
+	var hits []RuleHit
+	if res.Status == models.TransactionStatusValid {
+		var t models.Transaction
+		if err := tx.Preload("Rows").First(&t, "id = ?", id).Error; err != nil {
+			return res, nil, err
+		}
+		if hits, err = CategorizeTransaction(tx, rs, t); err != nil {
+			return res, nil, err
+		}
+	}
+	if _, err = PostTransaction(tx, id, actor); err != nil {
+		var verr ValidationError
+		if !errors.As(err, &verr) {
+			return res, hits, err
+		}
+		res.PostingError = verr.Msg
+	}
+	return res, hits, nil
+}
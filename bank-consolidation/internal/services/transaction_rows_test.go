@@ -0,0 +1,193 @@
+package services
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseTransactionCSV(t *testing.T) {
+	creditDebit := builtinTransactionSchema
+	creditDebit.AmountColumn = ""
+	creditDebit.CreditColumn = "credit"
+	creditDebit.DebitColumn = "debit"
+	creditDebit.DecimalSeparator = ","
+	creditDebit.ThousandSeparator = "."
+	withRequired := builtinTransactionSchema
+	withRequired.ReferenceColumn = "ref"
+	withRequired.RequiredColumns = "ref"
+
+	tests := []struct {
+		name      string
+		schema    models.TransactionSchema
+		raw       string
+		wantErr   string
+		wantRows  []models.TransactionRow
+		rowErrors []string // per row, "" when the row is valid
+	}{
+		{
+			name:   "valid rows",
+			schema: builtinTransactionSchema,
+			raw:    "date,description,amount\n2024-01-31,SETOR TUNAI,1500.50\n2024-02-01,BIAYA ADM,-7.5\n",
+			wantRows: []models.TransactionRow{
+				{Line: 2, Description: "SETOR TUNAI", Amount: 1500.5, AmountType: "CR", Valid: true},
+				{Line: 3, Description: "BIAYA ADM", Amount: 7.5, AmountType: "DB", Valid: true},
+			},
+			rowErrors: []string{"", ""},
+		},
+		{
+			name:    "missing required column",
+			schema:  withRequired,
+			raw:     "date,description,amount\n2024-01-31,SETOR,100\n",
+			wantErr: `required column "ref" not found`,
+		},
+		{
+			name:    "missing amount column",
+			schema:  builtinTransactionSchema,
+			raw:     "date,description,nominal\n2024-01-31,SETOR,100\n",
+			wantErr: "amount column",
+		},
+		{
+			name:    "json body",
+			schema:  builtinTransactionSchema,
+			raw:     `{"id":"t1","amount":100}`,
+			wantErr: "rawCsv holds JSON",
+		},
+		{
+			name:      "non-numeric amount",
+			schema:    builtinTransactionSchema,
+			raw:       "date,description,amount\n2024-01-31,SETOR,seratus\n",
+			wantRows:  []models.TransactionRow{{Line: 2}},
+			rowErrors: []string{"amount:"},
+		},
+		{
+			name:      "bad date",
+			schema:    builtinTransactionSchema,
+			raw:       "date,description,amount\nkemarin,SETOR,100\n",
+			wantRows:  []models.TransactionRow{{Line: 2}},
+			rowErrors: []string{"date:"},
+		},
+		{
+			name:   "empty required value and description",
+			schema: withRequired,
+			raw:    "date,description,amount,ref\n2024-01-31,SETOR,100,\n2024-01-31,,100,R1\n",
+			wantRows: []models.TransactionRow{
+				{Line: 2, Description: "SETOR", Amount: 100, AmountType: "CR"},
+				{Line: 3, Amount: 100, AmountType: "CR", Reference: "R1"},
+			},
+			rowErrors: []string{"empty required column(s): ref", "description is empty"},
+		},
+		{
+			name:   "credit and debit columns",
+			schema: creditDebit,
+			raw:    "date,description,credit,debit\n2024-01-31,SETOR,\"1.500,25\",\n2024-01-31,TARIK,,200\n2024-01-31,BOTH,1,2\n",
+			wantRows: []models.TransactionRow{
+				{Line: 2, Description: "SETOR", Amount: 1500.25, AmountType: "CR", Valid: true},
+				{Line: 3, Description: "TARIK", Amount: 200, AmountType: "DB", Valid: true},
+				{Line: 4},
+			},
+			rowErrors: []string{"", "", "both credit and debit"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseTransactionCSV(models.Transaction{ID: "t1", RawCSV: tt.raw}, tt.schema)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(tt.wantRows) {
+				t.Fatalf("rows = %+v, want %d", rows, len(tt.wantRows))
+			}
+			for i, want := range tt.wantRows {
+				got := rows[i]
+				if got.TransactionID != "t1" || got.Line != want.Line {
+					t.Errorf("row %d = transaction %q line %d, want t1 line %d", i, got.TransactionID, got.Line, want.Line)
+				}
+				if !strings.HasPrefix(got.Error, tt.rowErrors[i]) || (tt.rowErrors[i] == "") != got.Valid {
+					t.Errorf("row %d valid %v error %q, want error %q", i, got.Valid, got.Error, tt.rowErrors[i])
+				}
+				if want.Description != "" || want.Amount != 0 {
+					if got.Description != want.Description || got.Amount != want.Amount || got.AmountType != want.AmountType || got.Reference != want.Reference {
+						t.Errorf("row %d = %q %v %s ref %q, want %q %v %s ref %q", i, got.Description, got.Amount, got.AmountType, got.Reference,
+							want.Description, want.Amount, want.AmountType, want.Reference)
+					}
+				}
+				if got.Valid && got.TransactionDate == nil {
+					t.Errorf("row %d has no date", i)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionValidationTally(t *testing.T) {
+	valid := models.TransactionRow{Line: 2, Valid: true}
+	tests := []struct {
+		name       string
+		rows       []models.TransactionRow
+		fileErr    error
+		wantStatus string
+		wantError  string
+		wantRowErr []RowError
+	}{
+		{"all rows valid", []models.TransactionRow{valid, {Line: 3, Valid: true}}, nil, models.TransactionStatusValid, "", []RowError{}},
+		{"a row error makes it invalid", []models.TransactionRow{valid, {Line: 3, Error: "amount: bad"}}, nil, models.TransactionStatusInvalid, "", []RowError{{Line: 3, Error: "amount: bad"}}},
+		{"no data rows", nil, nil, models.TransactionStatusInvalid, "no data rows", []RowError{}},
+		{"unreadable file", nil, errors.New(`required column "ref" not found in header`), models.TransactionStatusInvalid, `required column "ref" not found in header`, []RowError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := TransactionValidation{TransactionID: "t1", RowErrors: []RowError{}}
+			res.tally(tt.rows, tt.fileErr)
+			if res.Status != tt.wantStatus || res.Error != tt.wantError {
+				t.Errorf("status = %s (%q), want %s (%q)", res.Status, res.Error, tt.wantStatus, tt.wantError)
+			}
+			if res.Rows != len(tt.rows) || res.ValidRows+len(res.RowErrors) != res.Rows {
+				t.Errorf("rows = %d valid %d errors %d", res.Rows, res.ValidRows, len(res.RowErrors))
+			}
+			if len(res.RowErrors) != len(tt.wantRowErr) {
+				t.Fatalf("row errors = %+v, want %+v", res.RowErrors, tt.wantRowErr)
+			}
+			for i := range tt.wantRowErr {
+				if res.RowErrors[i] != tt.wantRowErr[i] {
+					t.Errorf("row error %d = %+v, want %+v", i, res.RowErrors[i], tt.wantRowErr[i])
+				}
+			}
+		})
+	}
+
+	// revalidating after the file is fixed moves an invalid transaction back
+	res := TransactionValidation{RowErrors: []RowError{}}
+	res.tally([]models.TransactionRow{{Line: 2, Error: "date: bad"}}, nil)
+	fixed := TransactionValidation{RowErrors: []RowError{}}
+	fixed.tally([]models.TransactionRow{valid}, nil)
+	if res.Status != models.TransactionStatusInvalid || fixed.Status != models.TransactionStatusValid {
+		t.Errorf("invalid -> valid: got %s -> %s", res.Status, fixed.Status)
+	}
+}
+
+func TestNetRows(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []models.TransactionRow
+		want float64
+	}{
+		{"empty", nil, 0},
+		{"credits", []models.TransactionRow{{Amount: 100.1, AmountType: "CR", Valid: true}, {Amount: 0.2, AmountType: "CR", Valid: true}}, 100.3},
+		{"debits subtract", []models.TransactionRow{{Amount: 100, AmountType: "CR", Valid: true}, {Amount: 250, AmountType: "DB", Valid: true}}, -150},
+		{"blank type is credit", []models.TransactionRow{{Amount: 75, Valid: true}}, 75},
+		{"invalid rows skipped", []models.TransactionRow{{Amount: 100, AmountType: "CR", Valid: true}, {Amount: 900, AmountType: "DB"}}, 100},
+	}
+	for _, tt := range tests {
+		if got := netRows(tt.rows); got != tt.want {
+			t.Errorf("%s: netRows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package statements
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+type camtDocument struct {
+	Statements    []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+	Notifications []camtStatement `xml:"BkToCstmrDbtCdtNtfctn>Ntfctn"`
+}
+
+type camtStatement struct {
+	ID       string        `xml:"Id"`
+	Account  camtAccount   `xml:"Acct"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtAccount struct {
+	IBAN  string `xml:"Id>IBAN"`
+	Other string `xml:"Id>Othr>Id"`
+}
+
+func (a camtAccount) String() string {
+	if a.IBAN != "" {
+		return a.IBAN
+	}
+	return a.Other
+}
+
+type camtAmount struct {
+	Value    float64 `xml:",chardata"`
+	Currency string  `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d camtDate) Time() (time.Time, error) {
+	if d.Date != "" {
+		return time.Parse("2006-01-02", strings.TrimSpace(d.Date))
+	}
+	if d.DateTime != "" {
+		s := strings.TrimSpace(d.DateTime)
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02T15:04:05", s)
+	}
+	return time.Time{}, errors.New("date is empty")
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	CreditDbt string     `xml:"CdtDbtInd"`
+	Date      camtDate   `xml:"Dt"`
+}
+
+type camtEntry struct {
+	Amount        camtAmount      `xml:"Amt"`
+	CreditDbt     string          `xml:"CdtDbtInd"`
+	Reversal      bool            `xml:"RvslInd"`
+	BookingDate   camtDate        `xml:"BookgDt"`
+	ValueDate     camtDate        `xml:"ValDt"`
+	ServicerRef   string          `xml:"AcctSvcrRef"`
+	Details       []camtTxDetails `xml:"NtryDtls>TxDtls"`
+	AdditionalInf string          `xml:"AddtlNtryInf"`
+}
+
+type camtParty struct {
+	Name    string `xml:"Nm"`
+	PtyName string `xml:"Pty>Nm"`
+}
+
+func (p camtParty) String() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.PtyName
+}
+
+type camtTxDetails struct {
+	EndToEndID    string      `xml:"Refs>EndToEndId"`
+	Debtor        camtParty   `xml:"RltdPties>Dbtr"`
+	DebtorAcct    camtAccount `xml:"RltdPties>DbtrAcct"`
+	Creditor      camtParty   `xml:"RltdPties>Cdtr"`
+	CreditorAcct  camtAccount `xml:"RltdPties>CdtrAcct"`
+	Unstructured  []string    `xml:"RmtInf>Ustrd"`
+	CreditorRefs  []string    `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	AdditionalInf string      `xml:"AddtlTxInf"`
+}
+
+// ParseCamt reads an ISO 20022 camt.053 statement or camt.054 notification.
+// Each Ntry becomes one BankEntry; remittance details of its TxDtls are kept
+// in the structured reference fields. Branch is left empty for the caller.
+func ParseCamt(r io.Reader) (Result, error) {
+	var res Result
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return res, fmt.Errorf("decode camt: %w", err)
+	}
+	stmts := append(doc.Statements, doc.Notifications...)
+	if len(stmts) == 0 {
+		return res, errors.New("no camt.053 Stmt or camt.054 Ntfctn found")
+	}
+
+	n := 0
+	for _, st := range stmts {
+		opening, haveOpening := camtFindBalance(st.Balances, "OPBD", "PRCD")
+		if !haveOpening && len(st.Entries) > 0 {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%s: no opening balance, entry balances left at 0", st.ID))
+		}
+		balance := opening
+
+		for _, ntry := range st.Entries {
+			n++
+			row := Row{Line: n}
+			if err := camtFillEntry(ntry, &row); err != nil {
+				row.Err = err
+				res.Rows = append(res.Rows, row)
+				continue
+			}
+			if haveOpening {
+				if row.Entry.AmountType == "CR" {
+					balance += row.Entry.Amount
+				} else {
+					balance -= row.Entry.Amount
+				}
+				row.Entry.Balance = math.Round(balance*100) / 100
+			}
+			res.Rows = append(res.Rows, row)
+		}
+
+		if closing, ok := camtFindBalance(st.Balances, "CLBD"); ok && haveOpening && math.Abs(closing-balance) > 0.005 {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%s (account %s): closing balance %.2f does not match computed %.2f", st.ID, st.Account, closing, balance))
+		}
+	}
+	return res, nil
+}
+
+func camtFindBalance(list []camtBalance, codes ...string) (float64, bool) {
+	for _, code := range codes {
+		for _, b := range list {
+			if b.Code != code {
+				continue
+			}
+			v := b.Amount.Value
+			if b.CreditDbt == "DBIT" {
+				v = -v
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func camtFillEntry(ntry camtEntry, row *Row) error {
+	dt, err := ntry.BookingDate.Time()
+	if err != nil {
+		if dt, err = ntry.ValueDate.Time(); err != nil {
+			return errors.New("entry has no booking or value date")
+		}
+	}
+	e := &row.Entry
+	e.TransactionDate = dt
+	e.Amount = math.Abs(ntry.Amount.Value)
+	e.Currency = strings.ToUpper(strings.TrimSpace(ntry.Amount.Currency))
+
+	// CdtDbtInd is the booked direction, also for reversals: a returned
+	// credit is booked as DBIT with RvslInd set
+	credit := ntry.CreditDbt == "CRDT"
+	if ntry.CreditDbt != "CRDT" && ntry.CreditDbt != "DBIT" {
+		return fmt.Errorf("unknown CdtDbtInd %q", ntry.CreditDbt)
+	}
+	e.AmountType = "DB"
+	if credit {
+		e.AmountType = "CR"
+	}
+
+	var remittance, refs, e2e []string
+	for _, d := range ntry.Details {
+		if d.EndToEndID != "" && d.EndToEndID != "NOTPROVIDED" {
+			e2e = append(e2e, d.EndToEndID)
+		}
+		refs = append(refs, d.CreditorRefs...)
+		remittance = append(remittance, d.Unstructured...)
+
+		// the counterparty is the other side of the original booking, which
+		// a reversal turns round
+		party, acct := d.Debtor, d.DebtorAcct
+		if credit == ntry.Reversal {
+			party, acct = d.Creditor, d.CreditorAcct
+		}
+		if e.CounterpartyName == "" {
+			e.CounterpartyName = strings.TrimSpace(party.String())
+		}
+		if e.CounterpartyAccount == "" {
+			e.CounterpartyAccount = strings.TrimSpace(acct.String())
+		}
+	}
+	e.EndToEndID = strings.Join(e2e, ";")
+	e.CreditorReference = strings.Join(refs, ";")
+	e.RemittanceInfo = strings.TrimSpace(strings.Join(remittance, " "))
+
+	desc := strings.TrimSpace(ntry.AdditionalInf)
+	if desc == "" && len(ntry.Details) > 0 {
+		desc = strings.TrimSpace(ntry.Details[0].AdditionalInf)
+	}
+	if desc == "" {
+		desc = e.RemittanceInfo
+	}
+	if desc == "" {
+		desc = e.CounterpartyName
+	}
+	if desc == "" {
+		desc = ntry.ServicerRef
+	}
+	if ntry.Reversal {
+		desc = strings.TrimSpace("Reversal " + desc)
+	}
+	e.Description = desc
+	return nil
+}
@@ -0,0 +1,141 @@
+package statements
+
+import (
+	"strings"
+	"testing"
+)
+
+func camtDoc(entries string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Id>STMT-1</Id>
+      <Acct><Id><IBAN>ID00BANK0001</IBAN></Id></Acct>
+      <Bal><Tp><CdOrPrtry><Cd>OPBD</Cd></CdOrPrtry></Tp><Amt Ccy="IDR">1000.00</Amt><CdtDbtInd>CRDT</CdtDbtInd></Bal>
+      ` + entries + `
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+}
+
+const camtCredit = `<Ntry>
+        <Amt Ccy="IDR">250.00</Amt><CdtDbtInd>CRDT</CdtDbtInd>
+        <BookgDt><Dt>2025-01-02</Dt></BookgDt>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>E2E-1</EndToEndId></Refs>
+          <RltdPties><Dbtr><Nm>PT Pembeli</Nm></Dbtr><DbtrAcct><Id><IBAN>ID00PAYER</IBAN></Id></DbtrAcct><Cdtr><Nm>PT Kami</Nm></Cdtr></RltdPties>
+          <RmtInf><Ustrd>INV-001</Ustrd><Strd><CdtrRefInf><Ref>RF18INV001</Ref></CdtrRefInf></Strd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>`
+
+// a credit returned to the payer: booked as a debit, flagged as a reversal
+const camtReturnedCredit = `<Ntry>
+        <Amt Ccy="IDR">250.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><RvslInd>true</RvslInd>
+        <BookgDt><Dt>2025-01-03</Dt></BookgDt>
+        <NtryDtls><TxDtls>
+          <RltdPties><Dbtr><Nm>PT Pembeli</Nm></Dbtr><Cdtr><Nm>PT Kami</Nm></Cdtr></RltdPties>
+          <RmtInf><Ustrd>RETURN INV-001</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>`
+
+const camtDebit = `<Ntry>
+        <Amt Ccy="IDR">100.00</Amt><CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><Dt>2025-01-04</Dt></BookgDt>
+        <NtryDtls><TxDtls>
+          <RltdPties><Dbtr><Nm>PT Kami</Nm></Dbtr><Cdtr><Nm>PT Pemasok</Nm></Cdtr></RltdPties>
+        </TxDtls></NtryDtls>
+      </Ntry>`
+
+func TestParseCamt(t *testing.T) {
+	type want struct {
+		amountType   string
+		balance      float64
+		counterparty string
+		description  string
+	}
+	tests := []struct {
+		name         string
+		entries      string
+		want         []want
+		wantWarnings int
+	}{
+		{
+			name:    "credit with structured remittance",
+			entries: camtCredit,
+			want:    []want{{"CR", 1250, "PT Pembeli", "INV-001"}},
+		},
+		{
+			name:    "reversal keeps its booked direction",
+			entries: camtCredit + camtReturnedCredit,
+			want: []want{
+				{"CR", 1250, "PT Pembeli", "INV-001"},
+				{"DB", 1000, "PT Pembeli", "Reversal RETURN INV-001"},
+			},
+		},
+		{
+			name:    "debit counterparty is the creditor",
+			entries: camtDebit,
+			want:    []want{{"DB", 900, "PT Pemasok", "PT Pemasok"}},
+		},
+		{
+			name:         "closing balance mismatch is a warning",
+			entries:      camtDebit + `<Bal><Tp><CdOrPrtry><Cd>CLBD</Cd></CdOrPrtry></Tp><Amt Ccy="IDR">1000.00</Amt><CdtDbtInd>CRDT</CdtDbtInd></Bal>`,
+			want:         []want{{"DB", 900, "PT Pemasok", "PT Pemasok"}},
+			wantWarnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseCamt(strings.NewReader(camtDoc(tt.entries)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Rows) != len(tt.want) {
+				t.Fatalf("got %d rows, want %d", len(res.Rows), len(tt.want))
+			}
+			for i, w := range tt.want {
+				row := res.Rows[i]
+				if row.Err != nil {
+					t.Fatalf("row %d: %v", i, row.Err)
+				}
+				e := row.Entry
+				if e.AmountType != w.amountType || e.Balance != w.balance || e.CounterpartyName != w.counterparty || e.Description != w.description {
+					t.Errorf("row %d = {%s %v %q %q}, want %+v", i, e.AmountType, e.Balance, e.CounterpartyName, e.Description, w)
+				}
+			}
+			if len(res.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %v, want %d", res.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestParseCamtReferences(t *testing.T) {
+	res, err := ParseCamt(strings.NewReader(camtDoc(camtCredit)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := res.Rows[0].Entry
+	if e.EndToEndID != "E2E-1" || e.CreditorReference != "RF18INV001" || e.RemittanceInfo != "INV-001" || e.CounterpartyAccount != "ID00PAYER" {
+		t.Errorf("references = {%q %q %q %q}", e.EndToEndID, e.CreditorReference, e.RemittanceInfo, e.CounterpartyAccount)
+	}
+	if e.Currency != "IDR" || e.Amount != 250 {
+		t.Errorf("amount = %v %s, want 250 IDR", e.Amount, e.Currency)
+	}
+}
+
+func TestParseCamtErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"not xml", "hello"},
+		{"no statement", `<Document><Other/></Document>`},
+	}
+	for _, tt := range tests {
+		if _, err := ParseCamt(strings.NewReader(tt.in)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package statements
+
+import (
+	"bank-consolidation/models"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseCSV reads a raw CSV/TSV bank export using the column mapping in p.
+// The first line after SkipRows must be the header row.
+func ParseCSV(r io.Reader, p models.BankImportProfile) (Result, error) {
+	var res Result
+
+	delim := p.Delimiter
+	if delim == "" {
+		delim = ","
+	}
+	if strings.EqualFold(delim, "tab") || delim == `\t` {
+		delim = "\t"
+	}
+	comma, _ := utf8.DecodeRuneInString(delim)
+
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	line := 0
+	for i := 0; i < p.SkipRows; i++ {
+		if _, err := cr.Read(); err != nil {
+			return res, fmt.Errorf("skip row %d: %w", i+1, err)
+		}
+		line++
+	}
+
+	header, err := cr.Read()
+	if err != nil {
+		return res, fmt.Errorf("read header: %w", err)
+	}
+	line++
+	res.Header = header
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	col := func(name string) int {
+		if name == "" {
+			return -1
+		}
+		if i, ok := cols[strings.ToLower(strings.TrimSpace(name))]; ok {
+			return i
+		}
+		return -1
+	}
+
+	dateCol := col(p.DateColumn)
+	descCol := col(p.DescriptionColumn)
+	branchCol := col(p.BranchColumn)
+	amountCol := col(p.AmountColumn)
+	typeCol := col(p.AmountTypeColumn)
+	creditCol := col(p.CreditColumn)
+	debitCol := col(p.DebitColumn)
+	balanceCol := col(p.BalanceColumn)
+
+	if dateCol < 0 {
+		return res, fmt.Errorf("date column %q not found in header", p.DateColumn)
+	}
+	if descCol < 0 {
+		return res, fmt.Errorf("description column %q not found in header", p.DescriptionColumn)
+	}
+	if amountCol < 0 && (creditCol < 0 || debitCol < 0) {
+		return res, errors.New("profile needs an amount column or both credit and debit columns present in header")
+	}
+
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			res.Rows = append(res.Rows, Row{Line: line, Err: err})
+			continue
+		}
+		if isBlank(rec) {
+			continue
+		}
+		row := csvRow(line, rec, p, dateCol, descCol, branchCol, amountCol, typeCol, creditCol, debitCol, balanceCol)
+		row.Record = rec
+		res.Rows = append(res.Rows, row)
+	}
+	return res, nil
+}
+
+func csvRow(line int, rec []string, p models.BankImportProfile, dateCol, descCol, branchCol, amountCol, typeCol, creditCol, debitCol, balanceCol int) Row {
+	row := Row{Line: line}
+	get := func(i int) string {
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	dt, err := ParseDate(get(dateCol), p.DateFormat)
+	if err != nil {
+		row.Err = fmt.Errorf("date: %w", err)
+		return row
+	}
+	row.Entry.TransactionDate = dt
+	row.Entry.Description = get(descCol)
+	row.Entry.Branch = get(branchCol)
+	if row.Entry.Branch == "" {
+		row.Entry.Branch = p.DefaultBranch
+	}
+	row.Entry.BankCode = p.BankCode
+
+	switch {
+	case amountCol >= 0:
+		amt, err := ParseAmount(get(amountCol), p.DecimalSeparator, p.ThousandSeparator)
+		if err != nil {
+			row.Err = fmt.Errorf("amount: %w", err)
+			return row
+		}
+		if typeCol >= 0 {
+			switch marker := get(typeCol); {
+			case strings.EqualFold(marker, p.CreditMarker):
+				row.Entry.AmountType = "CR"
+			case strings.EqualFold(marker, p.DebitMarker):
+				row.Entry.AmountType = "DB"
+			default:
+				row.Err = fmt.Errorf("unknown credit/debit marker %q", marker)
+				return row
+			}
+		} else if amt < 0 {
+			row.Entry.AmountType = "DB"
+		} else {
+			row.Entry.AmountType = "CR"
+		}
+		row.Entry.Amount = math.Abs(amt)
+	default:
+		credit, debit := 0.0, 0.0
+		if s := get(creditCol); s != "" {
+			if credit, err = ParseAmount(s, p.DecimalSeparator, p.ThousandSeparator); err != nil {
+				row.Err = fmt.Errorf("credit: %w", err)
+				return row
+			}
+		}
+		if s := get(debitCol); s != "" {
+			if debit, err = ParseAmount(s, p.DecimalSeparator, p.ThousandSeparator); err != nil {
+				row.Err = fmt.Errorf("debit: %w", err)
+				return row
+			}
+		}
+		switch {
+		case credit != 0 && debit != 0:
+			row.Err = errors.New("both credit and debit are filled")
+			return row
+		case debit != 0:
+			row.Entry.AmountType = "DB"
+			row.Entry.Amount = math.Abs(debit)
+		case credit != 0:
+			row.Entry.AmountType = "CR"
+			row.Entry.Amount = math.Abs(credit)
+		default:
+			row.Err = errors.New("credit and debit are both empty")
+			return row
+		}
+	}
+
+	if balanceCol >= 0 {
+		if s := get(balanceCol); s != "" {
+			bal, err := ParseAmount(s, p.DecimalSeparator, p.ThousandSeparator)
+			if err != nil {
+				row.Err = fmt.Errorf("balance: %w", err)
+				return row
+			}
+			row.Entry.Balance = bal
+		}
+	}
+	return row
+}
+
+func isBlank(rec []string) bool {
+	for _, f := range rec {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
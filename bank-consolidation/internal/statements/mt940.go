@@ -0,0 +1,186 @@
+package statements
+
+import (
+	"bank-consolidation/models"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	mt940Tag     = regexp.MustCompile(`^:(\d{2}[A-Z]?):(.*)$`)
+	mt940Balance = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})(\d+,\d*)$`)
+	mt940Line    = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d+,\d*)(.*)$`)
+)
+
+type mt940Field struct {
+	tag   string
+	value string
+	line  int
+}
+
+// ParseMT940 reads a SWIFT MT940 customer statement. Every :61: line (with
+// its following :86: information) becomes one BankEntry whose Balance is
+// carried forward from the :60F:/:60M: opening balance. Branch is left empty
+// for the caller to fill. The :25: account is returned in Result.Account; a
+// file with statements of more than one account is rejected.
+func ParseMT940(r io.Reader) (Result, error) {
+	var res Result
+
+	fields, err := mt940Fields(r)
+	if err != nil {
+		return res, err
+	}
+	if len(fields) == 0 {
+		return res, errors.New("no MT940 tags found")
+	}
+
+	var (
+		reference   string
+		account     string
+		currency    string
+		balance     float64
+		haveBalance bool
+		pending     *Row
+	)
+	flush := func() {
+		if pending != nil {
+			res.Rows = append(res.Rows, *pending)
+			pending = nil
+		}
+	}
+
+	for _, f := range fields {
+		switch f.tag {
+		case "20":
+			flush()
+			reference = f.value
+			haveBalance = false
+		case "25":
+			account = strings.TrimSpace(f.value)
+			if res.Account != "" && account != res.Account {
+				return res, fmt.Errorf("line %d :25: account %s differs from %s; upload one account per file", f.line, account, res.Account)
+			}
+			res.Account = account
+		case "60F", "60M":
+			flush()
+			v, err := mt940ParseBalance(f.value)
+			if err != nil {
+				return res, fmt.Errorf("line %d :%s: %w", f.line, f.tag, err)
+			}
+			balance = v
+			haveBalance = true
+			// D/C mark, YYMMDD, then the ISO currency code
+			if len(f.value) >= 10 {
+				currency = strings.ToUpper(f.value[7:10])
+			}
+		case "61":
+			flush()
+			row := Row{Line: f.line}
+			row.Entry.Currency = currency
+			if !haveBalance {
+				row.Err = errors.New("transaction before opening balance :60F:")
+			} else if err := mt940ParseLine(f.value, &row.Entry); err != nil {
+				row.Err = err
+			} else {
+				if row.Entry.AmountType == "CR" {
+					balance += row.Entry.Amount
+				} else {
+					balance -= row.Entry.Amount
+				}
+				row.Entry.Balance = math.Round(balance*100) / 100
+			}
+			pending = &row
+		case "86":
+			if pending != nil {
+				pending.Entry.Description = strings.TrimSpace(f.value)
+				flush()
+			}
+		case "62F", "62M":
+			flush()
+			v, err := mt940ParseBalance(f.value)
+			if err != nil {
+				return res, fmt.Errorf("line %d :%s: %w", f.line, f.tag, err)
+			}
+			if haveBalance && math.Abs(v-balance) > 0.005 {
+				res.Warnings = append(res.Warnings, fmt.Sprintf("statement %s (account %s): closing balance %.2f does not match computed %.2f", reference, account, v, balance))
+			}
+			// a following :62M:/:60M: pair continues the same account
+			balance = v
+		}
+	}
+	flush()
+	return res, nil
+}
+
+func mt940Fields(r io.Reader) ([]mt940Field, error) {
+	var fields []mt940Field
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimRight(sc.Text(), "\r")
+		if m := mt940Tag.FindStringSubmatch(line); m != nil {
+			fields = append(fields, mt940Field{tag: m[1], value: m[2], line: n})
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		// block delimiters of the SWIFT envelope
+		if trimmed == "" || trimmed == "-" || trimmed == "-}" || strings.HasPrefix(trimmed, "{") {
+			continue
+		}
+		if len(fields) > 0 {
+			last := &fields[len(fields)-1]
+			last.value += " " + trimmed
+		}
+	}
+	return fields, sc.Err()
+}
+
+func mt940ParseBalance(s string) (float64, error) {
+	m := mt940Balance.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid balance %q", s)
+	}
+	v, err := ParseAmount(m[4], ",", "")
+	if err != nil {
+		return 0, err
+	}
+	if m[1] == "D" {
+		v = -v
+	}
+	return v, nil
+}
+
+func mt940ParseLine(s string, e *models.BankEntry) error {
+	m := mt940Line.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return fmt.Errorf("invalid :61: line %q", s)
+	}
+	dt, err := time.Parse("060102", m[1])
+	if err != nil {
+		return fmt.Errorf("value date: %w", err)
+	}
+	amt, err := ParseAmount(m[5], ",", "")
+	if err != nil {
+		return err
+	}
+
+	e.TransactionDate = dt
+	e.Amount = amt
+	switch m[3] {
+	case "C", "RD":
+		e.AmountType = "CR"
+	default:
+		e.AmountType = "DB"
+	}
+	// transaction type code and references, used when no :86: follows
+	e.Description = strings.TrimSpace(strings.ReplaceAll(m[6], "//", " "))
+	return nil
+}
@@ -0,0 +1,106 @@
+package statements
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMT940(t *testing.T) {
+	const statement = `{1:F01BANKIDJAXXXX0000000000}{2:I940BANKIDJAXXXXN}{4:
+:20:STMT-001
+:25:1234567890
+:28C:1/1
+:60F:C250101IDR1000000,00
+:61:2501020102C250000,00NTRFINV-001//REF1
+:86:PAYMENT INV-001 PT CONTOH
+:61:2501030103D100000,50NCHGFEE
+:62F:C250103IDR1149999,50
+-}`
+
+	tests := []struct {
+		name         string
+		in           string
+		wantRows     int
+		wantTypes    []string
+		wantBalances []float64
+		wantDesc     []string
+		wantAccount  string
+		wantWarnings int
+		wantErr      bool
+	}{
+		{
+			name:         "credit and debit with closing balance",
+			in:           statement,
+			wantRows:     2,
+			wantTypes:    []string{"CR", "DB"},
+			wantBalances: []float64{1250000, 1149999.5},
+			wantDesc:     []string{"PAYMENT INV-001 PT CONTOH", "NCHGFEE"},
+			wantAccount:  "1234567890",
+		},
+		{
+			name:         "closing balance mismatch is a warning",
+			in:           strings.Replace(statement, ":62F:C250103IDR1149999,50", ":62F:C250103IDR1000000,00", 1),
+			wantRows:     2,
+			wantTypes:    []string{"CR", "DB"},
+			wantBalances: []float64{1250000, 1149999.5},
+			wantDesc:     []string{"PAYMENT INV-001 PT CONTOH", "NCHGFEE"},
+			wantAccount:  "1234567890",
+			wantWarnings: 1,
+		},
+		{
+			name:         "reversal of a debit is a credit",
+			in:           ":20:S\n:60F:C250101IDR100,00\n:61:250102RD10,00NTRFREV\n:62F:C250102IDR110,00\n",
+			wantRows:     1,
+			wantTypes:    []string{"CR"},
+			wantBalances: []float64{110},
+			wantDesc:     []string{"NTRFREV"},
+		},
+		{
+			name:    "statements of two accounts",
+			in:      statement + "\n:20:STMT-002\n:25:9999999999\n:60F:C250103IDR0,00\n:62F:C250103IDR0,00\n",
+			wantErr: true,
+		},
+		{
+			name:    "no tags",
+			in:      "hello\nworld\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseMT940(strings.NewReader(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(res.Rows) != tt.wantRows {
+				t.Fatalf("got %d rows, want %d", len(res.Rows), tt.wantRows)
+			}
+			for i, row := range res.Rows {
+				if row.Err != nil {
+					t.Fatalf("row %d: %v", i, row.Err)
+				}
+				if row.Entry.AmountType != tt.wantTypes[i] {
+					t.Errorf("row %d amountType = %s, want %s", i, row.Entry.AmountType, tt.wantTypes[i])
+				}
+				if row.Entry.Balance != tt.wantBalances[i] {
+					t.Errorf("row %d balance = %v, want %v", i, row.Entry.Balance, tt.wantBalances[i])
+				}
+				if row.Entry.Description != tt.wantDesc[i] {
+					t.Errorf("row %d description = %q, want %q", i, row.Entry.Description, tt.wantDesc[i])
+				}
+				if row.Entry.Currency != "IDR" {
+					t.Errorf("row %d currency = %q, want IDR", i, row.Entry.Currency)
+				}
+			}
+			if res.Account != tt.wantAccount {
+				t.Errorf("account = %q, want %q", res.Account, tt.wantAccount)
+			}
+			if len(res.Warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %v, want %d", res.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package statements
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ofxElement = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+type ofxTxn struct {
+	line   int
+	fields map[string]string
+}
+
+// ParseOFX reads an OFX 1.x (SGML) or 2.x (XML) bank or credit card
+// statement. FITID is kept as the entry's ExternalID; a FITID repeated in
+// the file, as in overlapping downloads, counts once towards the balances.
+// When openingBalance is given, balances run forward from it and the final
+// one is checked against LEDGERBAL; otherwise they are derived backwards from
+// LEDGERBAL.
+func ParseOFX(r io.Reader, openingBalance *float64) (Result, error) {
+	var res Result
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return res, err
+	}
+	// OFX 1.x starts with a colon separated header block before <OFX>
+	start := bytes.Index(bytes.ToUpper(raw), []byte("<OFX>"))
+	if start < 0 {
+		return res, errors.New("no <OFX> element found")
+	}
+	body := string(raw[start:])
+
+	var (
+		txns      []ofxTxn
+		cur       *ofxTxn
+		inLedger  bool
+		ledgerBal *float64
+		ledgerDt  string
+		currency  string
+		line      = bytes.Count(raw[:start], []byte("\n")) + 1
+		pos       int
+	)
+	for _, m := range ofxElement.FindAllStringSubmatchIndex(body, -1) {
+		closing := body[m[2]:m[3]] == "/"
+		name := strings.ToUpper(body[m[4]:m[5]])
+		value := strings.TrimSpace(body[m[6]:m[7]])
+		line += strings.Count(body[pos:m[0]], "\n")
+		pos = m[0]
+
+		switch {
+		case name == "STMTTRN" && !closing:
+			txns = append(txns, ofxTxn{line: line, fields: map[string]string{}})
+			cur = &txns[len(txns)-1]
+		case name == "STMTTRN" && closing:
+			cur = nil
+		case name == "LEDGERBAL":
+			inLedger = !closing
+		case name == "CURDEF" && !closing:
+			currency = strings.ToUpper(value)
+		case closing:
+			// SGML leaf elements are usually not closed; XML ones are
+		case cur != nil && value != "":
+			cur.fields[name] = value
+		case inLedger && name == "BALAMT" && value != "":
+			v, err := ParseAmount(value, ".", ",")
+			if err != nil {
+				return res, fmt.Errorf("LEDGERBAL: %w", err)
+			}
+			ledgerBal = &v
+		case inLedger && name == "DTASOF":
+			ledgerDt = value
+		}
+	}
+	if len(txns) == 0 {
+		return res, errors.New("no STMTTRN found")
+	}
+
+	total := 0.0
+	counted := map[string]bool{}
+	for _, t := range txns {
+		row := Row{Line: t.line}
+		ofxFillEntry(t.fields, &row)
+		row.Entry.Currency = currency
+		if row.Err == nil && counted[row.Entry.ExternalID] {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("line %d: FITID %s repeats an earlier transaction", t.line, row.Entry.ExternalID))
+		} else if row.Err == nil {
+			counted[row.Entry.ExternalID] = true
+			if row.Entry.AmountType == "CR" {
+				total += row.Entry.Amount
+			} else {
+				total -= row.Entry.Amount
+			}
+		}
+		res.Rows = append(res.Rows, row)
+	}
+
+	var balance float64
+	switch {
+	case openingBalance != nil:
+		balance = *openingBalance
+	case ledgerBal != nil:
+		balance = *ledgerBal - total
+		res.Warnings = append(res.Warnings, "no opening balance given, balances derived backwards from LEDGERBAL")
+	default:
+		res.Warnings = append(res.Warnings, "no opening balance and no LEDGERBAL, entry balances left at 0")
+	}
+	if openingBalance != nil || ledgerBal != nil {
+		seen := map[string]bool{}
+		for i := range res.Rows {
+			e := &res.Rows[i].Entry
+			if res.Rows[i].Err != nil {
+				continue
+			}
+			if seen[e.ExternalID] {
+				continue
+			}
+			seen[e.ExternalID] = true
+			if e.AmountType == "CR" {
+				balance += e.Amount
+			} else {
+				balance -= e.Amount
+			}
+			e.Balance = math.Round(balance*100) / 100
+		}
+	}
+	if openingBalance != nil && ledgerBal != nil && math.Abs(*ledgerBal-balance) > 0.005 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("LEDGERBAL %.2f as of %s does not match computed running balance %.2f", *ledgerBal, ledgerDt, balance))
+	}
+	return res, nil
+}
+
+func ofxFillEntry(f map[string]string, row *Row) {
+	e := &row.Entry
+	dt, err := ofxParseDate(f["DTPOSTED"])
+	if err != nil {
+		row.Err = fmt.Errorf("DTPOSTED: %w", err)
+		return
+	}
+	amt, err := ParseAmount(f["TRNAMT"], ".", ",")
+	if err != nil {
+		row.Err = fmt.Errorf("TRNAMT: %w", err)
+		return
+	}
+	e.TransactionDate = dt
+	e.Amount = math.Abs(amt)
+	e.AmountType = "CR"
+	if amt < 0 {
+		e.AmountType = "DB"
+	}
+	e.ExternalID = f["FITID"]
+	if e.ExternalID == "" {
+		row.Err = errors.New("FITID is missing")
+		return
+	}
+	e.CounterpartyName = f["NAME"]
+	e.CounterpartyAccount = f["ACCTID"]
+
+	parts := []string{}
+	for _, k := range []string{"NAME", "MEMO"} {
+		if v := f[k]; v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, f["TRNTYPE"])
+	}
+	e.Description = strings.Join(parts, " ")
+	if ref := f["CHECKNUM"]; ref != "" {
+		e.Description += " CHECK " + ref
+	}
+}
+
+// ofxParseDate handles YYYYMMDD[HHMMSS[.XXX]][[+-]TZ[:name]]
+func ofxParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "["); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "."); i >= 0 {
+		s = s[:i]
+	}
+	switch {
+	case len(s) >= 14:
+		return time.Parse("20060102150405", s[:14])
+	case len(s) >= 8:
+		return time.Parse("20060102", s[:8])
+	}
+	return time.Time{}, errors.New("invalid date " + s)
+}
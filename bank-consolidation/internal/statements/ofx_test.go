@@ -0,0 +1,118 @@
+package statements
+
+import (
+	"strings"
+	"testing"
+)
+
+func ofxDoc(txns string, ledger string) string {
+	return `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>IDR
+<BANKTRANLIST>
+` + txns + `
+</BANKTRANLIST>
+` + ledger + `
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`
+}
+
+const (
+	ofxCredit = "<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20250102<TRNAMT>250.00<FITID>F1<NAME>PT Pembeli<MEMO>INV-001</STMTTRN>"
+	ofxDebit  = "<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20250103120000.000[+7:WIB]<TRNAMT>-100.00<FITID>F2<NAME>PLN</STMTTRN>"
+)
+
+func ofxLedger(amount string) string {
+	return "<LEDGERBAL><BALAMT>" + amount + "<DTASOF>20250103</LEDGERBAL>"
+}
+
+func TestParseOFX(t *testing.T) {
+	opening := 1000.0
+	tests := []struct {
+		name         string
+		in           string
+		opening      *float64
+		wantTypes    []string
+		wantBalances []float64
+		wantWarnings []string
+	}{
+		{
+			name:         "running balance from the opening balance",
+			in:           ofxDoc(ofxCredit+ofxDebit, ofxLedger("1150.00")),
+			opening:      &opening,
+			wantTypes:    []string{"CR", "DB"},
+			wantBalances: []float64{1250, 1150},
+		},
+		{
+			name:         "LEDGERBAL mismatch",
+			in:           ofxDoc(ofxCredit+ofxDebit, ofxLedger("2000.00")),
+			opening:      &opening,
+			wantTypes:    []string{"CR", "DB"},
+			wantBalances: []float64{1250, 1150},
+			wantWarnings: []string{"does not match"},
+		},
+		{
+			name:         "balances derived backwards from LEDGERBAL",
+			in:           ofxDoc(ofxCredit+ofxDebit, ofxLedger("1150.00")),
+			wantTypes:    []string{"CR", "DB"},
+			wantBalances: []float64{1250, 1150},
+			wantWarnings: []string{"derived backwards"},
+		},
+		{
+			name:         "repeated FITID counts once",
+			in:           ofxDoc(ofxCredit+ofxCredit+ofxDebit, ofxLedger("1150.00")),
+			opening:      &opening,
+			wantTypes:    []string{"CR", "CR", "DB"},
+			wantBalances: []float64{1250, 0, 1150},
+			wantWarnings: []string{"FITID F1 repeats"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseOFX(strings.NewReader(tt.in), tt.opening)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Rows) != len(tt.wantTypes) {
+				t.Fatalf("got %d rows, want %d", len(res.Rows), len(tt.wantTypes))
+			}
+			for i, row := range res.Rows {
+				if row.Err != nil {
+					t.Fatalf("row %d: %v", i, row.Err)
+				}
+				if row.Entry.AmountType != tt.wantTypes[i] || row.Entry.Balance != tt.wantBalances[i] {
+					t.Errorf("row %d = %s %v, want %s %v", i, row.Entry.AmountType, row.Entry.Balance, tt.wantTypes[i], tt.wantBalances[i])
+				}
+			}
+			if len(res.Warnings) != len(tt.wantWarnings) {
+				t.Fatalf("warnings = %v, want %v", res.Warnings, tt.wantWarnings)
+			}
+			for i, w := range tt.wantWarnings {
+				if !strings.Contains(res.Warnings[i], w) {
+					t.Errorf("warning %q does not mention %q", res.Warnings[i], w)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOFXFields(t *testing.T) {
+	res, err := ParseOFX(strings.NewReader(ofxDoc(ofxCredit+"<STMTTRN><DTPOSTED>20250104<TRNAMT>5<NAME>X</STMTTRN>", "")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := res.Rows[0].Entry
+	if e.ExternalID != "F1" || e.Description != "PT Pembeli INV-001" || e.Currency != "IDR" || e.Amount != 250 {
+		t.Errorf("entry = {%q %q %q %v}", e.ExternalID, e.Description, e.Currency, e.Amount)
+	}
+	if res.Rows[1].Err == nil {
+		t.Error("a transaction without FITID must be rejected")
+	}
+	if _, err := ParseOFX(strings.NewReader("no ofx here"), nil); err == nil {
+		t.Error("expected an error without <OFX>")
+	}
+}
@@ -0,0 +1,124 @@
+package statements
+
+import (
+	"bank-consolidation/models"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Row is a single parsed statement line. Err is set when the line could not
+// be turned into a BankEntry; Entry is then only partially filled. Record
+// holds the raw fields for CSV input.
+type Row struct {
+	Line   int
+	Entry  models.BankEntry
+	Err    error
+	Record []string
+}
+
+// Result is what every statement parser returns. Header is only set for CSV
+// input. Account is the account identification the statement itself
+// carries (MT940 :25:), empty for formats without one.
+type Result struct {
+	Rows     []Row
+	Warnings []string
+	Header   []string
+	Account  string
+}
+
+// AccountMatches reports whether a statement's account identification names
+// the account number. Separators are ignored, a leading bank identifier
+// ("BIC/number") and a trailing ISO currency code are allowed.
+func AccountMatches(stated, number string) bool {
+	clean := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == ' ' || r == '-' || r == '.' {
+				return -1
+			}
+			return r
+		}, strings.ToUpper(strings.TrimSpace(s)))
+	}
+	stated, number = clean(stated), clean(number)
+	if number == "" {
+		return false
+	}
+	if i := strings.LastIndex(stated, "/"); i >= 0 {
+		stated = stated[i+1:]
+	}
+	if stated == number {
+		return true
+	}
+	n := len(stated)
+	if n > 3 && strings.Trim(stated[n-3:], "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == "" && stated[:n-3] == number {
+		return true
+	}
+	return false
+}
+
+var dateLayouts = []string{
+	"02/01/2006",
+	"2006-01-02",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"02-01-2006",
+	"02/01/06",
+}
+
+// ParseDate parses s with layout, or with the common bank layouts when layout
+// is empty.
+func ParseDate(s, layout string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, errors.New("date is empty")
+	}
+	if layout != "" {
+		return time.Parse(layout, s)
+	}
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unsupported date format")
+}
+
+// ParseAmount parses a bank formatted number such as "1.234.567,89" or
+// "(1,200.00)". Parentheses and a leading or trailing minus make it negative.
+func ParseAmount(s, decimalSep, thousandSep string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("amount is empty")
+	}
+	neg := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		neg = true
+		s = s[1 : len(s)-1]
+	}
+	if strings.HasSuffix(s, "-") {
+		neg = true
+		s = strings.TrimSuffix(s, "-")
+	}
+	if strings.HasPrefix(s, "-") {
+		neg = !neg
+		s = strings.TrimPrefix(s, "-")
+	}
+	s = strings.TrimPrefix(s, "+")
+	s = strings.TrimSpace(strings.TrimPrefix(strings.ToUpper(s), "RP"))
+	s = strings.ReplaceAll(s, " ", "")
+	if thousandSep != "" {
+		s = strings.ReplaceAll(s, thousandSep, "")
+	}
+	if decimalSep != "" && decimalSep != "." {
+		s = strings.ReplaceAll(s, decimalSep, ".")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.New("invalid amount " + strconv.Quote(s))
+	}
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
@@ -0,0 +1,82 @@
+package statements
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in       string
+		dec, tho string
+		want     float64
+		wantErr  bool
+	}{
+		{"1234.56", ".", ",", 1234.56, false},
+		{"1,234,567.89", ".", ",", 1234567.89, false},
+		{"1.234.567,89", ",", ".", 1234567.89, false},
+		{"(1,200.00)", ".", ",", -1200, false},
+		{"1200.00-", ".", ",", -1200, false},
+		{"-1200", ".", ",", -1200, false},
+		{"+50", ".", ",", 50, false},
+		{"Rp 15.000", ",", ".", 15000, false},
+		{"", ".", ",", 0, true},
+		{"abc", ".", ",", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in, tt.dec, tt.tho)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAmount(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in, layout string
+		want       time.Time
+		wantErr    bool
+	}{
+		{"31/01/2025", "", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), false},
+		{"2025-01-31", "", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), false},
+		{"2025-01-31 08:30:00", "", time.Date(2025, 1, 31, 8, 30, 0, 0, time.UTC), false},
+		{"01/31/2025", "01/02/2006", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), false},
+		{"", "", time.Time{}, true},
+		{"31.01.2025", "", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDate(tt.in, tt.layout)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDate(%q, %q) error = %v, wantErr %v", tt.in, tt.layout, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseDate(%q, %q) = %v, want %v", tt.in, tt.layout, got, tt.want)
+		}
+	}
+}
+
+func TestAccountMatches(t *testing.T) {
+	tests := []struct {
+		stated, number string
+		want           bool
+	}{
+		{"1234567890", "1234567890", true},
+		{"BBBAIDJA/1234567890", "1234567890", true},
+		{"1234567890IDR", "1234567890", true},
+		{"123-456-7890", "123.456.7890", true},
+		{"nl91abna0417164300", "NL91 ABNA 0417 1643 00", true},
+		{"1234567891", "1234567890", false},
+		{"BBBAIDJA/1234567890/2", "1234567890", false},
+		{"1234567890", "", false},
+	}
+	for _, tt := range tests {
+		if got := AccountMatches(tt.stated, tt.number); got != tt.want {
+			t.Errorf("AccountMatches(%q, %q) = %v, want %v", tt.stated, tt.number, got, tt.want)
+		}
+	}
+}
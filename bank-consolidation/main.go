@@ -3,15 +3,34 @@ package main
 import (
 	"bank-consolidation/internal/config"
 	"bank-consolidation/internal/routes"
+	"bank-consolidation/internal/services"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"net/http"
 	"os"
+	"time"
+
+	"gorm.io/gorm"
 )
 
 func main() {
 	cfg := config.New()
-	db := initDB(cfg.MySQLDSN())
-	engine := routes.Register(db)
+	if cfg.JWTSecret == "" {
+		if !cfg.SeedDev {
+			log.Fatal("JWT_SECRET is required")
+		}
+		// dev only: sessions do not survive a restart
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatalf("jwt secret: %v", err)
+		}
+		cfg.JWTSecret = hex.EncodeToString(b)
+		log.Print("JWT_SECRET not set, using a random secret")
+	}
+	db := initDB(cfg)
+	go sweepOverdueInvoices(db)
+	engine := routes.Register(db, cfg)
 	addr := cfg.Addr
 	if env := os.Getenv("ADDR"); env != "" {
 		addr = env
@@ -20,3 +39,16 @@ func main() {
 	log.Printf("listening on %s", addr)
 	log.Fatal(srv.ListenAndServe())
 }
+
+// sweepOverdueInvoices flags invoices whose due date has passed, at startup
+// and then hourly, since no payment event happens on the day they lapse.
+func sweepOverdueInvoices(db *gorm.DB) {
+	for {
+		if n, err := services.SweepOverdue(db); err != nil {
+			log.Printf("overdue sweep: %v", err)
+		} else if n > 0 {
+			log.Printf("overdue sweep: %d invoices marked overdue", n)
+		}
+		time.Sleep(time.Hour)
+	}
+}
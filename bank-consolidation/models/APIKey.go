@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// APIKey authenticates an integration job. The key itself is shown once on
+// creation; only its SHA-256 hash is stored, with Prefix kept so that a key
+// can be recognised in listings. Role and Companies restrict the key like
+// those of a User.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name" gorm:"type:varchar(64);not null;uniqueIndex"`
+	Prefix     string     `json:"prefix" gorm:"type:varchar(16);not null"`
+	KeyHash    string     `json:"-" gorm:"type:char(64);not null;uniqueIndex"`
+	Role       string     `json:"role" gorm:"type:varchar(20);not null;default:'staff'"`
+	Companies  string     `json:"companies" gorm:"type:varchar(512);not null;default:'*'"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedBy  string     `json:"createdBy" gorm:"type:varchar(64)"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
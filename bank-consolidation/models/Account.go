@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AccountTypeAsset     = "asset"
+	AccountTypeLiability = "liability"
+	AccountTypeEquity    = "equity"
+	AccountTypeRevenue   = "revenue"
+	AccountTypeExpense   = "expense"
+)
+
+// Control account roles used by automatic postings.
+const (
+	AccountRoleBank       = "bank"
+	AccountRoleReceivable = "receivable"
+	AccountRolePayable    = "payable"
+	AccountRoleFxGainLoss = "fx_gain_loss"
+	// contra revenue account that credit notes are booked against
+	AccountRoleSalesReturns = "sales_returns"
+)
+
+// Account is a chart-of-accounts entry. Role marks the control accounts that
+// journal postings look up; at most one account holds each role.
+type Account struct {
+	Code      string         `json:"code" gorm:"primaryKey;type:varchar(32)"`
+	Name      string         `json:"name" gorm:"type:varchar(255);not null"`
+	Type      string         `json:"type" gorm:"type:varchar(16);not null"`
+	Role      *string        `json:"role" gorm:"type:varchar(32);uniqueIndex"`
+	IsActive  bool           `json:"isActive" gorm:"not null;default:true"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
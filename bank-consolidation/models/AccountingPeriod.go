@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+const (
+	PeriodStatusOpen       = "open"
+	PeriodStatusSoftClosed = "soft_closed"
+	PeriodStatusClosed     = "closed"
+)
+
+// AccountingPeriod is the close state of one month ("YYYY-MM") of a
+// company. Months without a row are open. In a soft-closed period only
+// supervisors may change bank entries and invoices; in a closed one they
+// must also ask for an override with a reason. Both kinds of override are
+// recorded in the audit log.
+type AccountingPeriod struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CompanyCode string     `json:"companyCode" gorm:"type:varchar(64);not null;uniqueIndex:idx_accounting_periods_company_period,priority:1"`
+	Period      string     `json:"period" gorm:"type:char(7);not null;uniqueIndex:idx_accounting_periods_company_period,priority:2"`
+	Status      string     `json:"status" gorm:"type:varchar(16);not null;default:'open'"`
+	Note        string     `json:"note" gorm:"type:text"`
+	ClosedBy    string     `json:"closedBy" gorm:"type:varchar(128)"`
+	ClosedAt    *time.Time `json:"closedAt" gorm:"type:datetime"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
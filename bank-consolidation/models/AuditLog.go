@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditActionCreate      = "create"
+	AuditActionUpdate      = "update"
+	AuditActionDelete      = "delete"
+	AuditActionReconcile   = "reconcile"
+	AuditActionUnreconcile = "unreconcile"
+	// AuditActionOverride records a change made in a soft-closed or closed
+	// accounting period
+	AuditActionOverride = "period_override"
+)
+
+// Entity types recorded in the audit log.
+const (
+	AuditEntityAccount           = "account"
+	AuditEntityAccountingPeriod  = "accounting_period"
+	AuditEntityAPIKey            = "api_key"
+	AuditEntityBankAccount       = "bank_account"
+	AuditEntityBankEntry         = "bank_entry"
+	AuditEntityBankImportProfile = "bank_import_profile"
+	AuditEntityCategory          = "category"
+	AuditEntityCreditNote        = "credit_note"
+	AuditEntityExchangeRate      = "exchange_rate"
+	AuditEntityInvoice           = "invoice"
+	AuditEntityPurchaseInvoice   = "purchase_invoice"
+	AuditEntityTransaction       = "transaction"
+	AuditEntityTransactionSchema = "transaction_schema"
+	AuditEntityUser              = "user"
+)
+
+// AuditLog is an append-only record of a change made through the API: who
+// changed which entity, and its state before and after. Before is null for
+// creates and After for deletes; Changes holds only the top-level fields
+// that differ, as {"field": {"from": ..., "to": ...}}. CompanyCode is empty
+// for entities not owned by a company.
+type AuditLog struct {
+	ID          uint64          `json:"id" gorm:"primaryKey;autoIncrement"`
+	Actor       string          `json:"actor" gorm:"type:varchar(128);not null;index"`
+	EntityType  string          `json:"entityType" gorm:"type:varchar(32);not null;index:idx_audit_entity,priority:1"`
+	EntityID    string          `json:"entityId" gorm:"type:varchar(255);not null;index:idx_audit_entity,priority:2"`
+	CompanyCode string          `json:"companyCode" gorm:"type:varchar(64);not null;default:'';index"`
+	Action      string          `json:"action" gorm:"type:varchar(16);not null"`
+	Before      json.RawMessage `json:"before" gorm:"type:json"`
+	After       json.RawMessage `json:"after" gorm:"type:json"`
+	Changes     json.RawMessage `json:"changes" gorm:"type:json"`
+	CreatedAt   time.Time       `json:"createdAt" gorm:"type:datetime;not null;index"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BankAccount is one account held at a bank. Bank entries are booked to an
+// account; its GLAccount (falling back to the bank control account) receives
+// their journal postings and OpeningBalance is the balance on OpeningDate,
+// before the first entry.
+type BankAccount struct {
+	ID             string         `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	BankCode       string         `json:"bankCode" gorm:"type:varchar(20);not null;uniqueIndex:idx_bank_accounts_bank_number,priority:1"`
+	AccountNumber  string         `json:"accountNumber" gorm:"type:varchar(64);not null;uniqueIndex:idx_bank_accounts_bank_number,priority:2"`
+	AccountName    string         `json:"accountName" gorm:"type:varchar(255)"`
+	Branch         string         `json:"branch" gorm:"type:varchar(32)"`
+	Currency       string         `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
+	CompanyCode    string         `json:"companyCode" gorm:"type:varchar(64);not null;index"`
+	GLAccount      string         `json:"glAccount" gorm:"type:varchar(32)"`
+	OpeningBalance float64        `json:"openingBalance" gorm:"type:decimal(18,2);not null;default:0"`
+	OpeningDate    *time.Time     `json:"openingDate" gorm:"type:date"`
+	IsActive       bool           `json:"isActive" gorm:"not null;default:true"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (a *BankAccount) UnmarshalJSON(data []byte) error {
+	type Alias BankAccount
+	aux := &struct {
+		OpeningDate string `json:"openingDate"`
+		*Alias
+	}{
+		Alias: (*Alias)(a),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if s := strings.TrimSpace(aux.OpeningDate); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return errors.New("openingDate must be YYYY-MM-DD")
+		}
+		a.OpeningDate = &t
+	}
+	return nil
+}
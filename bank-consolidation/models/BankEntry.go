@@ -10,18 +10,28 @@ import (
 )
 
 type BankEntry struct {
-	ID              string         `json:"id" gorm:"primaryKey;type:varchar(64)"`
-	TransactionDate time.Time      `json:"transactionDate" gorm:"type:datetime;not null"`
-	Description     string         `json:"description" gorm:"type:text;not null"`
-	Branch          string         `json:"branch" gorm:"type:varchar(32);not null"`
-	Amount          float64        `json:"amount" gorm:"type:decimal(18,2);not null"`
-	AmountType      string         `json:"amountType" gorm:"type:varchar(2);not null"`
-	Balance         float64        `json:"balance" gorm:"type:decimal(18,2);not null"`
-	BankCode        string         `json:"bankCode" gorm:"type:varchar(20);not null;default:'UNKNOWN'"`
-	Fingerprint     string         `json:"fingerprint" gorm:"type:varchar(64);uniqueIndex"`
-	AttachedCount   int            `json:"attachedCount" gorm:"->;<-:false"`
-	MatchedTotal    float64        `json:"matchedTotal" gorm:"->;<-:false"`
-	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                  string         `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	TransactionDate     time.Time      `json:"transactionDate" gorm:"type:datetime;not null"`
+	Description         string         `json:"description" gorm:"type:text;not null"`
+	Branch              string         `json:"branch" gorm:"type:varchar(32);not null"`
+	Amount              float64        `json:"amount" gorm:"type:decimal(18,2);not null"`
+	Currency            string         `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
+	AmountType          string         `json:"amountType" gorm:"type:varchar(2);not null"`
+	Balance             float64        `json:"balance" gorm:"type:decimal(18,2);not null"`
+	BankAccountID       string         `json:"bankAccountId" gorm:"type:varchar(64);index:idx_bank_entries_account_external_id,priority:1"`
+	CompanyCode         string         `json:"companyCode" gorm:"type:varchar(64);not null;default:'';index"`
+	BankCode            string         `json:"bankCode" gorm:"type:varchar(20);not null;default:'UNKNOWN'"`
+	Fingerprint         string         `json:"fingerprint" gorm:"type:varchar(64);uniqueIndex"`
+	ExternalID          string         `json:"externalId" gorm:"type:varchar(255);index:idx_bank_entries_account_external_id,priority:2"`
+	EndToEndID          string         `json:"endToEndId" gorm:"type:varchar(255);index"`
+	CreditorReference   string         `json:"creditorReference" gorm:"type:varchar(255);index"`
+	CounterpartyName    string         `json:"counterpartyName" gorm:"type:varchar(255)"`
+	CounterpartyAccount string         `json:"counterpartyAccount" gorm:"type:varchar(64)"`
+	RemittanceInfo      string         `json:"remittanceInfo" gorm:"type:text"`
+	AttachedCount       int            `json:"attachedCount" gorm:"->;<-:false"`
+	MatchedTotal        float64        `json:"matchedTotal" gorm:"->;<-:false"`
+	UnallocatedAmount   float64        `json:"unallocatedAmount" gorm:"->;<-:false"`
+	DeletedAt           gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (b *BankEntry) UnmarshalJSON(data []byte) error {
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// BankEntryCategory assigns a category to a bank entry, either by hand or by
+// one of the category's business rules (RuleID).
+type BankEntryCategory struct {
+	BankEntryID string    `json:"bankEntryId" gorm:"primaryKey;type:varchar(64)"`
+	CategoryID  string    `json:"categoryId" gorm:"primaryKey;type:varchar(64);index"`
+	AssignedBy  string    `json:"assignedBy" gorm:"type:varchar(16);not null;default:'manual'"`
+	RuleID      string    `json:"ruleId" gorm:"type:varchar(64)"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
@@ -4,10 +4,19 @@ import (
 	"time"
 )
 
+// BankEntryInvoice links a bank entry to an invoice it pays. MatchedAmount is
+// in the invoice's currency and BankAmount in the entry's; Rate is the entry
+// currency paid per unit of invoice currency. BaseAmount is MatchedAmount in
+// IDR at the invoice date rate and FxGainLoss the IDR received at the entry
+// date rate minus BaseAmount.
 type BankEntryInvoice struct {
 	BankEntryID     string    `json:"bankEntryId" gorm:"primaryKey;type:varchar(64)"`
 	InvoiceHeaderID string    `json:"invoiceHeaderId" gorm:"primaryKey;type:varchar(64);index"`
 	MatchedAmount   float64   `json:"matchedAmount" gorm:"type:decimal(18,2)"`
+	BankAmount      float64   `json:"bankAmount" gorm:"type:decimal(18,2);not null;default:0"`
+	Rate            float64   `json:"rate" gorm:"type:decimal(18,8);not null;default:1"`
+	BaseAmount      float64   `json:"baseAmount" gorm:"type:decimal(18,2);not null;default:0"`
+	FxGainLoss      float64   `json:"fxGainLoss" gorm:"type:decimal(18,2);not null;default:0"`
 	Note            string    `json:"note" gorm:"type:text"`
 	CreatedAt       time.Time `json:"createdAt" gorm:"type:datetime;not null;default:NOW()"`
 }
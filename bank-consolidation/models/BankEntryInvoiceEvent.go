@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	LinkEventAttach       = "attach"
+	LinkEventDetach       = "detach"
+	LinkEventAmountChange = "amount_change"
+)
+
+// BankEntryInvoiceEvent is an append-only history of changes to
+// BankEntryInvoice links.
+type BankEntryInvoiceEvent struct {
+	ID              uint64    `json:"id" gorm:"primaryKey;autoIncrement"`
+	BankEntryID     string    `json:"bankEntryId" gorm:"type:varchar(64);not null;index"`
+	InvoiceHeaderID string    `json:"invoiceHeaderId" gorm:"type:varchar(64);not null;index"`
+	Action          string    `json:"action" gorm:"type:varchar(16);not null"`
+	PreviousAmount  *float64  `json:"previousAmount" gorm:"type:decimal(18,2)"`
+	NewAmount       *float64  `json:"newAmount" gorm:"type:decimal(18,2)"`
+	Note            string    `json:"note" gorm:"type:text"`
+	Actor           string    `json:"actor" gorm:"type:varchar(128);not null"`
+	CreatedAt       time.Time `json:"createdAt" gorm:"type:datetime;not null"`
+}
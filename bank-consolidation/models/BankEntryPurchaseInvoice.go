@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+type BankEntryPurchaseInvoice struct {
+	BankEntryID             string    `json:"bankEntryId" gorm:"primaryKey;type:varchar(64)"`
+	PurchaseInvoiceHeaderID int64     `json:"purchaseInvoiceHeaderId" gorm:"primaryKey;autoIncrement:false;index"`
+	MatchedAmount           float64   `json:"matchedAmount" gorm:"type:decimal(18,2)"`
+	Note                    string    `json:"note" gorm:"type:text"`
+	CreatedAt               time.Time `json:"createdAt" gorm:"type:datetime;not null;default:NOW()"`
+}
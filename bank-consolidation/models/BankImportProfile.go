@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// BankImportProfile describes how a bank's raw CSV/TSV export maps onto
+// BankEntry columns. There is one profile per BankCode.
+type BankImportProfile struct {
+	BankCode          string    `json:"bankCode" gorm:"primaryKey;type:varchar(20)"`
+	Delimiter         string    `json:"delimiter" gorm:"type:varchar(4);not null;default:','"`
+	SkipRows          int       `json:"skipRows" gorm:"not null;default:0"`
+	DateColumn        string    `json:"dateColumn" gorm:"type:varchar(64);not null"`
+	DateFormat        string    `json:"dateFormat" gorm:"type:varchar(32)"`
+	DescriptionColumn string    `json:"descriptionColumn" gorm:"type:varchar(64);not null"`
+	BranchColumn      string    `json:"branchColumn" gorm:"type:varchar(64)"`
+	DefaultBranch     string    `json:"defaultBranch" gorm:"type:varchar(32);not null;default:'0000'"`
+	AmountColumn      string    `json:"amountColumn" gorm:"type:varchar(64)"`
+	AmountTypeColumn  string    `json:"amountTypeColumn" gorm:"type:varchar(64)"`
+	CreditMarker      string    `json:"creditMarker" gorm:"type:varchar(16);not null;default:'CR'"`
+	DebitMarker       string    `json:"debitMarker" gorm:"type:varchar(16);not null;default:'DB'"`
+	CreditColumn      string    `json:"creditColumn" gorm:"type:varchar(64)"`
+	DebitColumn       string    `json:"debitColumn" gorm:"type:varchar(64)"`
+	BalanceColumn     string    `json:"balanceColumn" gorm:"type:varchar(64)"`
+	DecimalSeparator  string    `json:"decimalSeparator" gorm:"type:varchar(1);not null;default:'.'"`
+	ThousandSeparator string    `json:"thousandSeparator" gorm:"type:varchar(1)"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
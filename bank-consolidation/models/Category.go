@@ -2,10 +2,13 @@ package models
 
 import "gorm.io/gorm"
 
+// Category classifies bank entries and transactions. A category with a
+// CompanyCode belongs to that company; without one it is shared by all.
 type Category struct {
 	ID             string         `json:"id" gorm:"primaryKey;type:varchar(64)"`
 	Type           string         `json:"type" gorm:"type:varchar(32);not null"`
 	Name           string         `json:"name" gorm:"type:varchar(255);not null"`
+	CompanyCode    string         `json:"companyCode" gorm:"type:varchar(64);not null;default:'';index"`
 	DefaultAccount string         `json:"defaultAccount" gorm:"type:varchar(255)"`
 	BusinessRules  string         `json:"businessRules" gorm:"type:text"`
 	TaxRules       string         `json:"taxRules" gorm:"type:text"`
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CreditNote corrects an issued invoice. Its Amount (PPN included) reduces
+// the original invoice's outstanding balance like a payment would.
+type CreditNote struct {
+	ID              string         `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	CreditNoteNo    string         `json:"creditNoteNo" gorm:"type:varchar(64);not null;uniqueIndex"`
+	InvoiceHeaderID string         `json:"invoiceHeaderId" gorm:"type:varchar(64);not null;index"`
+	CreditDate      time.Time      `json:"creditDate" gorm:"type:date;not null"`
+	Amount          float64        `json:"amount" gorm:"type:decimal(15,2);not null"`
+	Reason          string         `json:"reason" gorm:"type:text"`
+	CompanyCode     string         `json:"companyCode" gorm:"type:varchar(64);not null"`
+	CreatedBy       string         `json:"createdBy" gorm:"type:varchar(128);not null"`
+	CreatedAt       time.Time      `json:"createdAt"`
+	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (cn *CreditNote) UnmarshalJSON(data []byte) error {
+	type Alias CreditNote
+	aux := &struct {
+		CreditDate string `json:"creditDate"`
+		*Alias
+	}{
+		Alias: (*Alias)(cn),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if s := strings.TrimSpace(aux.CreditDate); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return errors.New("creditDate must be YYYY-MM-DD")
+		}
+		cn.CreditDate = t
+	}
+	return nil
+}
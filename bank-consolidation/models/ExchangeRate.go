@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ExchangeRate is the IDR value of one unit of Currency from RateDate until
+// the next rate of the same currency.
+type ExchangeRate struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Currency  string    `json:"currency" gorm:"type:char(3);not null;uniqueIndex:idx_exchange_rates_currency_date,priority:1"`
+	RateDate  time.Time `json:"rateDate" gorm:"type:date;not null;uniqueIndex:idx_exchange_rates_currency_date,priority:2"`
+	Rate      float64   `json:"rate" gorm:"type:decimal(18,6);not null"`
+	Source    string    `json:"source" gorm:"type:varchar(64)"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (x *ExchangeRate) UnmarshalJSON(data []byte) error {
+	type Alias ExchangeRate
+	aux := &struct {
+		RateDate string `json:"rateDate"`
+		*Alias
+	}{
+		Alias: (*Alias)(x),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if s := strings.TrimSpace(aux.RateDate); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return errors.New("rateDate must be YYYY-MM-DD")
+		}
+		x.RateDate = t
+	}
+	return nil
+}
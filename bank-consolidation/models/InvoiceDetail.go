@@ -10,13 +10,13 @@ type InvoiceDetail struct {
 	UomID               string         `json:"uomId" gorm:"-"`
 	PackingName         string         `json:"packingName" gorm:"-"`
 	Qty                 float64        `json:"qty" gorm:"column:quantity;type:decimal(15,4);not null"`
-	Disc                float64        `json:"disc" gorm:"-"`
+	Disc                float64        `json:"disc" gorm:"type:decimal(5,2);not null;default:0"`
 	UnitPrice           float64        `json:"unitPrice" gorm:"column:unit_price;type:decimal(15,4);not null"`
 	Amount              float64        `json:"amount" gorm:"type:decimal(15,4);not null"`
 	Ppn                 float64        `json:"ppn" gorm:"column:tax_amount;type:decimal(15,4);not null"`
 	PpnPercent          float64        `json:"ppnPercent" gorm:"column:tax_rate;type:decimal(5,2);not null"`
-	ClaimAbleDisc       float64        `json:"claimAbleDisc" gorm:"-"`
-	ClaimAbleDiscAmount float64        `json:"claimAbleDiscAmount" gorm:"-"`
-	ManualDiscount      float64        `json:"manualDiscount" gorm:"-"`
+	ClaimAbleDisc       float64        `json:"claimAbleDisc" gorm:"type:decimal(5,2);not null;default:0"`
+	ClaimAbleDiscAmount float64        `json:"claimAbleDiscAmount" gorm:"type:decimal(15,4);not null;default:0"`
+	ManualDiscount      float64        `json:"manualDiscount" gorm:"type:decimal(15,4);not null;default:0"`
 	DeletedAt           gorm.DeletedAt `json:"-" gorm:"index"`
 }
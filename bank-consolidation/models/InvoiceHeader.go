@@ -9,31 +9,44 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	InvoiceStatusPending       = "pending"
+	InvoiceStatusPartiallyPaid = "partially_paid"
+	InvoiceStatusPaid          = "paid"
+	InvoiceStatusOverdue       = "overdue"
+	InvoiceStatusVoid          = "void"
+)
+
 type InvoiceHeader struct {
 	InvoiceHeaderID   string         `json:"invoiceHeaderId" gorm:"column:id;primaryKey;type:varchar(64)"`
 	InvoiceNo         string         `json:"invoiceNo" gorm:"type:varchar(64);not null"`
 	InvoiceSequenceNo string         `json:"invoiceSequenceNo" gorm:"-"`
 	InvoiceDate       time.Time      `json:"invoiceDate" gorm:"type:datetime;not null"`
 	TradeDate         string         `json:"tradeDate" gorm:"-"`
-	SalesOrderID      string         `json:"salesOrderId" gorm:"-"`
-	SalesOrderNo      string         `json:"salesOrderNo" gorm:"-"`
-	DeliveryOrderID   string         `json:"deliveryOrderId" gorm:"-"`
-	DeliveryOrderNo   string         `json:"deliveryOrderNo" gorm:"-"`
-	PurchaseOrderNo   string         `json:"purchaseOrderNo" gorm:"-"`
-	SalesID           string         `json:"salesId" gorm:"-"`
-	SalesName         string         `json:"salesName" gorm:"-"`
+	SalesOrderID      string         `json:"salesOrderId" gorm:"type:varchar(64)"`
+	SalesOrderNo      string         `json:"salesOrderNo" gorm:"type:varchar(64)"`
+	DeliveryOrderID   string         `json:"deliveryOrderId" gorm:"type:varchar(64)"`
+	DeliveryOrderNo   string         `json:"deliveryOrderNo" gorm:"type:varchar(64)"`
+	PurchaseOrderNo   string         `json:"purchaseOrderNo" gorm:"type:varchar(64)"`
+	SalesID           string         `json:"salesId" gorm:"type:varchar(64)"`
+	SalesName         string         `json:"salesName" gorm:"type:varchar(255)"`
 	CustomerID        string         `json:"customerId" gorm:"column:customer_id;type:varchar(64);not null"`
 	CustomerName      string         `json:"customerName" gorm:"column:customer_name;type:varchar(255);not null"`
-	DeliverTo         string         `json:"deliverTo" gorm:"-"`
+	DeliverTo         string         `json:"deliverTo" gorm:"type:text"`
 	Status            string         `json:"status" gorm:"type:varchar(32);not null;default:'pending'"`
+	Currency          string         `json:"currency" gorm:"type:char(3);not null;default:'IDR'"`
 	TotalAmount       float64        `json:"totalAmount" gorm:"type:decimal(15,2);not null"`
 	TotalTax          float64        `json:"totalTax" gorm:"type:decimal(15,2);not null"`
 	TotalProduct      int            `json:"totalProduct" gorm:"-"`
-	DueDate           string         `json:"dueDate" gorm:"-"`
-	Termin            string         `json:"termin" gorm:"-"`
-	Notes             string         `json:"notes" gorm:"-"`
+	DueDate           *time.Time     `json:"dueDate" gorm:"type:date;index"`
+	Termin            string         `json:"termin" gorm:"type:varchar(32)"`
+	TermDays          *int           `json:"termDays" gorm:"type:int"`
+	Notes             string         `json:"notes" gorm:"type:text"`
+	VoidedAt          *time.Time     `json:"voidedAt" gorm:"type:datetime"`
+	VoidedBy          string         `json:"voidedBy" gorm:"type:varchar(128)"`
+	VoidReason        string         `json:"voidReason" gorm:"type:text"`
 	CompanyCode       string         `json:"companyCode" gorm:"column:company_code;type:varchar(64);not null"`
-	OtherExpense      float64        `json:"otherExpense" gorm:"-"`
+	OtherExpense      float64        `json:"otherExpense" gorm:"type:decimal(15,2);not null;default:0"`
 	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
@@ -41,6 +54,7 @@ func (h *InvoiceHeader) UnmarshalJSON(data []byte) error {
 	type Alias InvoiceHeader
 	aux := &struct {
 		InvoiceDate string `json:"invoiceDate"`
+		DueDate     string `json:"dueDate"`
 		*Alias
 	}{
 		Alias: (*Alias)(h),
@@ -49,6 +63,16 @@ func (h *InvoiceHeader) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	if s := strings.TrimSpace(aux.DueDate); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, s); err != nil {
+				return errors.New("unsupported due date format")
+			}
+		}
+		h.DueDate = &t
+	}
+
 	if aux.InvoiceDate == "" {
 		return nil
 	}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Journal sources, i.e. what a journal entry was generated from.
+const (
+	JournalSourceBankReceipt = "bank_receipt"
+	JournalSourceBankPayment = "bank_payment"
+	JournalSourceTransaction = "transaction"
+	JournalSourceCreditNote  = "credit_note"
+)
+
+// JournalEntry is a balanced double-entry posting. Entries are never edited;
+// a change to the source document is posted as a further adjusting entry.
+type JournalEntry struct {
+	ID          uint64        `json:"id" gorm:"primaryKey;autoIncrement"`
+	EntryDate   time.Time     `json:"entryDate" gorm:"type:date;not null;index"`
+	Source      string        `json:"source" gorm:"type:varchar(32);not null;index:idx_journal_entries_source"`
+	SourceID    string        `json:"sourceId" gorm:"type:varchar(64);not null;index:idx_journal_entries_source"`
+	CompanyCode string        `json:"companyCode" gorm:"type:varchar(64);not null;default:'';index"`
+	Description string        `json:"description" gorm:"type:text"`
+	TotalDebit  float64       `json:"totalDebit" gorm:"type:decimal(18,2);not null"`
+	CreatedBy   string        `json:"createdBy" gorm:"type:varchar(128);not null"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	Lines       []JournalLine `json:"lines" gorm:"foreignKey:JournalEntryID"`
+}
+
+type JournalLine struct {
+	ID             uint64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	JournalEntryID uint64  `json:"journalEntryId" gorm:"not null;index"`
+	AccountCode    string  `json:"accountCode" gorm:"type:varchar(32);not null;index"`
+	Debit          float64 `json:"debit" gorm:"type:decimal(18,2);not null"`
+	Credit         float64 `json:"credit" gorm:"type:decimal(18,2);not null"`
+	Memo           string  `json:"memo" gorm:"type:varchar(255)"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "gorm.io/gorm"
+
 type PurchaseInvoiceStatusType string
 
 const (
@@ -19,33 +21,37 @@ const (
 )
 
 type PurchaseInvoiceHeader struct {
-	PurchaseInvoiceHeaderID  int64                     `json:"purchaseInvoiceHeaderId"`
-	PurchaseInvoiceNo        string                    `json:"purchaseInvoiceNo"`
-	PurchaseOrderGroupNo     string                    `json:"purchaseOrderGroupNo"`
-	PurchaseOrderNo          string                    `json:"purchaseOrderNo"`
-	PurchaseInvoiceDate      string                    `json:"purchaseInvoiceDate"`
-	SupplierId               string                    `json:"supplierId"`
-	SupplierName             string                    `json:"supplierName"`
-	SupplierAddress          string                    `json:"supplierAddress"`
-	PurchaseInvoiceStatus    PurchaseInvoiceStatusType `json:"purchaseInvoiceStatus"`
-	DueDate                  string                    `json:"dueDate"`
-	ReceiveDate              string                    `json:"receiveDate"`
-	TotalAmount              float64                   `json:"totalAmount"`
-	RoundValue               float64                   `json:"roundValue"`
-	TotalQty                 float64                   `json:"totalQty"`
-	TotalProduct             float64                   `json:"totalProduct"`
-	Ppn                      float64                   `json:"ppn"`
-	CreatedBy                string                    `json:"createdBy"`
-	CreatedDate              string                    `json:"createdDate"`
-	UpdatedBy                string                    `json:"updatedBy"`
-	UpdatedAt                string                    `json:"updatedAt"`
-	Details                  []PurchaseInvoiceDetail   `json:"details"`
-	Notes                    string                    `json:"notes"`
-	PurchaseInvoiceDisc      string                    `json:"purchaseInvoiceDisc"`
-	AdditionalCost           float64                   `json:"additionalCost"`
-	PaymentStatus            PaymentStatusType         `json:"paymentStatus"`
+	PurchaseInvoiceHeaderID  int64                     `json:"purchaseInvoiceHeaderId" gorm:"primaryKey;autoIncrement"`
+	PurchaseInvoiceNo        string                    `json:"purchaseInvoiceNo" gorm:"type:varchar(64);not null;index"`
+	PurchaseOrderGroupNo     string                    `json:"purchaseOrderGroupNo" gorm:"type:varchar(64)"`
+	PurchaseOrderNo          string                    `json:"purchaseOrderNo" gorm:"type:varchar(64)"`
+	CompanyCode              string                    `json:"companyCode" gorm:"type:varchar(64);not null;default:'';index"`
+	PurchaseInvoiceDate      string                    `json:"purchaseInvoiceDate" gorm:"type:varchar(32);not null"`
+	SupplierId               string                    `json:"supplierId" gorm:"type:varchar(64);not null;index"`
+	SupplierName             string                    `json:"supplierName" gorm:"type:varchar(255);not null"`
+	SupplierAddress          string                    `json:"supplierAddress" gorm:"type:text"`
+	PurchaseInvoiceStatus    PurchaseInvoiceStatusType `json:"purchaseInvoiceStatus" gorm:"type:varchar(16);not null;default:'PENDING';index"`
+	DueDate                  string                    `json:"dueDate" gorm:"type:varchar(32)"`
+	ReceiveDate              string                    `json:"receiveDate" gorm:"type:varchar(32)"`
+	TotalAmount              float64                   `json:"totalAmount" gorm:"type:decimal(18,2);not null"`
+	RoundValue               float64                   `json:"roundValue" gorm:"type:decimal(18,2)"`
+	TotalQty                 float64                   `json:"totalQty" gorm:"type:decimal(18,4)"`
+	TotalProduct             float64                   `json:"totalProduct" gorm:"type:decimal(18,4)"`
+	Ppn                      float64                   `json:"ppn" gorm:"type:decimal(18,2)"`
+	CreatedBy                string                    `json:"createdBy" gorm:"type:varchar(128)"`
+	CreatedDate              string                    `json:"createdDate" gorm:"type:varchar(32)"`
+	UpdatedBy                string                    `json:"updatedBy" gorm:"type:varchar(128)"`
+	UpdatedAt                string                    `json:"updatedAt" gorm:"type:varchar(32)"`
+	Details                  []PurchaseInvoiceDetail   `json:"details" gorm:"foreignKey:PurchaseInvoiceHeaderID"`
+	Taxes                    []PurchaseInvoiceTax      `json:"taxes" gorm:"foreignKey:PurchaseInvoiceHeaderID"`
+	Notes                    string                    `json:"notes" gorm:"type:text"`
+	PurchaseInvoiceDisc      string                    `json:"purchaseInvoiceDisc" gorm:"type:varchar(64)"`
+	AdditionalCost           float64                   `json:"additionalCost" gorm:"type:decimal(18,2)"`
+	PaymentStatus            PaymentStatusType         `json:"paymentStatus" gorm:"type:varchar(16)"`
 	PurchaseInvoiceToID      int64                     `json:"purchaseInvoiceToId"`
-	PurchaseInvoiceToName    string                    `json:"purchaseInvoiceToName"`
-	PurchaseInvoiceToAddress string                    `json:"purchaseInvoiceToAddress"`
+	PurchaseInvoiceToName    string                    `json:"purchaseInvoiceToName" gorm:"type:varchar(255)"`
+	PurchaseInvoiceToAddress string                    `json:"purchaseInvoiceToAddress" gorm:"type:text"`
 	FromPurchaseOrder        bool                      `json:"fromPurchaseOrder"`
+	PaidAmount               float64                   `json:"paidAmount" gorm:"->;<-:false"`
+	DeletedAt                gorm.DeletedAt            `json:"-" gorm:"index"`
 }
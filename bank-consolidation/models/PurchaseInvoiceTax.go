@@ -3,12 +3,13 @@ package models
 import "time"
 
 type PurchaseInvoiceTax struct {
-	PurchaseInvoiceTaxID  int64                 `json:"purchaseInvoiceTaxId"`
-	PurchaseInvoiceHeader PurchaseInvoiceHeader `json:"purchaseInvoiceHeader"`
-	InvoiceNoFromSupplier string                `json:"invoiceNoFromSupplier"`
-	TaxInvoiceNo          string                `json:"taxInvoiceNo"`
-	Dpp                   float64               `json:"dpp"`
-	TotalInvoice          float64               `json:"totalInvoice"`
-	TaxInvoiceDate        time.Time             `json:"taxInvoiceDate"`
-	PaymentDate           time.Time             `json:"paymentDate"`
+	PurchaseInvoiceTaxID    int64                  `json:"purchaseInvoiceTaxId" gorm:"primaryKey;autoIncrement"`
+	PurchaseInvoiceHeaderID int64                  `json:"-" gorm:"not null;index"`
+	PurchaseInvoiceHeader   *PurchaseInvoiceHeader `json:"purchaseInvoiceHeader,omitempty" gorm:"-"`
+	InvoiceNoFromSupplier   string                 `json:"invoiceNoFromSupplier" gorm:"type:varchar(64)"`
+	TaxInvoiceNo            string                 `json:"taxInvoiceNo" gorm:"type:varchar(64)"`
+	Dpp                     float64                `json:"dpp" gorm:"type:decimal(18,2)"`
+	TotalInvoice            float64                `json:"totalInvoice" gorm:"type:decimal(18,2)"`
+	TaxInvoiceDate          *time.Time             `json:"taxInvoiceDate" gorm:"type:date"`
+	PaymentDate             *time.Time             `json:"paymentDate" gorm:"type:date"`
 }
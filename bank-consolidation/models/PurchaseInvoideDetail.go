@@ -1,21 +1,22 @@
 package models
 
 type PurchaseInvoiceDetail struct {
-	PurchaseInvoiceDetailID int64                 `json:"purchaseInvoiceDetailId"`
-	PurchaseInvoiceHeader   PurchaseInvoiceHeader `json:"purchaseInvoiceHeader"`
-	ProductID               string                `json:"productId"`
-	ProductCode             string                `json:"productCode"`
-	ProductName             string                `json:"productName"`
-	UomPackingID            string                `json:"uomPackingId"`
-	UomPackingName          string                `json:"uomPackingName"`
-	Qty                     int64                 `json:"qty"`
-	ExpDate                 string                `json:"expDate"`
-	BatchCode               string                `json:"batchCode"`
-	ProductUnitPrice        float64               `json:"productUnitPrice"`
-	Discount                float64               `json:"discount"`
-	DiscProduct             string                `json:"discProduct"`
-	Amount                  float64               `json:"amount"`
-	Ppn                     float64               `json:"ppn"`
-	PpnInPercent            float64               `json:"ppnInPercent"`
-	Notes                   string                `json:"notes"`
+	PurchaseInvoiceDetailID int64                  `json:"purchaseInvoiceDetailId" gorm:"primaryKey;autoIncrement"`
+	PurchaseInvoiceHeaderID int64                  `json:"-" gorm:"not null;index"`
+	PurchaseInvoiceHeader   *PurchaseInvoiceHeader `json:"purchaseInvoiceHeader,omitempty" gorm:"-"`
+	ProductID               string                 `json:"productId" gorm:"type:varchar(64);not null"`
+	ProductCode             string                 `json:"productCode" gorm:"type:varchar(64)"`
+	ProductName             string                 `json:"productName" gorm:"type:varchar(255)"`
+	UomPackingID            string                 `json:"uomPackingId" gorm:"type:varchar(64)"`
+	UomPackingName          string                 `json:"uomPackingName" gorm:"type:varchar(64)"`
+	Qty                     int64                  `json:"qty"`
+	ExpDate                 string                 `json:"expDate" gorm:"type:varchar(32)"`
+	BatchCode               string                 `json:"batchCode" gorm:"type:varchar(64)"`
+	ProductUnitPrice        float64                `json:"productUnitPrice" gorm:"type:decimal(18,4)"`
+	Discount                float64                `json:"discount" gorm:"type:decimal(18,4)"`
+	DiscProduct             string                 `json:"discProduct" gorm:"type:varchar(64)"`
+	Amount                  float64                `json:"amount" gorm:"type:decimal(18,4)"`
+	Ppn                     float64                `json:"ppn" gorm:"type:decimal(18,4)"`
+	PpnInPercent            float64                `json:"ppnInPercent" gorm:"type:decimal(5,2)"`
+	Notes                   string                 `json:"notes" gorm:"type:text"`
 }
@@ -6,11 +6,24 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	TransactionStatusPending = "pending"
+	TransactionStatusValid   = "valid"
+	TransactionStatusInvalid = "invalid"
+)
+
 type Transaction struct {
-	ID               string         `json:"id" gorm:"primaryKey;type:varchar(64)"`
-	RawCSV           string         `json:"rawCsv" gorm:"column:raw_csv;type:longtext;not null"`
-	ImportSource     string         `json:"importSource" gorm:"type:varchar(255)"`
-	ValidationStatus string         `json:"validationStatus" gorm:"type:varchar(32);not null"`
-	ImportTimestamp  time.Time      `json:"importTimestamp" gorm:"type:timestamp;not null;default:CURRENT_TIMESTAMP"`
-	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
+	ID               string           `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	RawCSV           string           `json:"rawCsv" gorm:"column:raw_csv;type:longtext;not null"`
+	ImportSource     string           `json:"importSource" gorm:"type:varchar(255)"`
+	CompanyCode      string           `json:"companyCode" gorm:"type:varchar(64);not null;default:'';index"`
+	BankAccountID    string           `json:"bankAccountId" gorm:"type:varchar(64);not null;default:'';index"`
+	ValidationStatus string           `json:"validationStatus" gorm:"type:varchar(32);not null"`
+	ValidationError  string           `json:"validationError,omitempty" gorm:"type:text"`
+	RowCount         int              `json:"rowCount" gorm:"not null;default:0"`
+	ErrorCount       int              `json:"errorCount" gorm:"not null;default:0"`
+	ValidatedAt      *time.Time       `json:"validatedAt" gorm:"type:datetime"`
+	ImportTimestamp  time.Time        `json:"importTimestamp" gorm:"type:timestamp;not null;default:CURRENT_TIMESTAMP"`
+	Rows             []TransactionRow `json:"rows,omitempty" gorm:"foreignKey:TransactionID"`
+	DeletedAt        gorm.DeletedAt   `json:"-" gorm:"index"`
 }
@@ -1,6 +1,17 @@
 package models
 
+const (
+	AssignedByManual = "manual"
+	AssignedByRule   = "rule"
+)
+
+// TransactionCategory assigns a category to a transaction. Amount is the
+// part of the transaction the category covers; nil means whatever the other
+// categories leave.
 type TransactionCategory struct {
-	TransactionID string `json:"transactionId" gorm:"primaryKey;type:varchar(64)"`
-	CategoryID    string `json:"categoryId" gorm:"primaryKey;type:varchar(64)"`
+	TransactionID string   `json:"transactionId" gorm:"primaryKey;type:varchar(64)"`
+	CategoryID    string   `json:"categoryId" gorm:"primaryKey;type:varchar(64)"`
+	AssignedBy    string   `json:"assignedBy" gorm:"type:varchar(16);not null;default:'manual'"`
+	RuleID        string   `json:"ruleId" gorm:"type:varchar(64)"`
+	Amount        *float64 `json:"amount" gorm:"type:decimal(18,2)"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// TransactionRow is one line of a transaction's RawCSV after validation.
+// Rows that failed carry Error and only the fields that could be read.
+type TransactionRow struct {
+	ID              uint64     `json:"id" gorm:"primaryKey;autoIncrement"`
+	TransactionID   string     `json:"transactionId" gorm:"type:varchar(64);not null;index"`
+	Line            int        `json:"line" gorm:"not null"`
+	TransactionDate *time.Time `json:"transactionDate" gorm:"type:date;index"`
+	Description     string     `json:"description" gorm:"type:text"`
+	Branch          string     `json:"branch" gorm:"type:varchar(32)"`
+	Amount          float64    `json:"amount" gorm:"type:decimal(18,2);not null"`
+	AmountType      string     `json:"amountType" gorm:"type:varchar(2)"`
+	Reference       string     `json:"reference" gorm:"type:varchar(255);index"`
+	Valid           bool       `json:"valid" gorm:"not null;index"`
+	Error           string     `json:"error,omitempty" gorm:"type:text"`
+}
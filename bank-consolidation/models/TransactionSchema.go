@@ -0,0 +1,66 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// DefaultTransactionSchema is the ImportSource of the schema used for
+// sources without one of their own.
+const DefaultTransactionSchema = "*"
+
+// TransactionSchema describes the CSV layout of Transaction.RawCSV for one
+// ImportSource. Columns are header names; RequiredColumns lists further
+// comma-separated columns that must be present and filled on every row.
+type TransactionSchema struct {
+	ImportSource      string    `json:"importSource" gorm:"primaryKey;type:varchar(255)"`
+	Delimiter         string    `json:"delimiter" gorm:"type:varchar(4);not null;default:','"`
+	SkipRows          int       `json:"skipRows" gorm:"not null;default:0"`
+	DateColumn        string    `json:"dateColumn" gorm:"type:varchar(64);not null"`
+	DateFormat        string    `json:"dateFormat" gorm:"type:varchar(32)"`
+	DescriptionColumn string    `json:"descriptionColumn" gorm:"type:varchar(64);not null"`
+	BranchColumn      string    `json:"branchColumn" gorm:"type:varchar(64)"`
+	AmountColumn      string    `json:"amountColumn" gorm:"type:varchar(64)"`
+	AmountTypeColumn  string    `json:"amountTypeColumn" gorm:"type:varchar(64)"`
+	CreditMarker      string    `json:"creditMarker" gorm:"type:varchar(16);not null;default:'CR'"`
+	DebitMarker       string    `json:"debitMarker" gorm:"type:varchar(16);not null;default:'DB'"`
+	CreditColumn      string    `json:"creditColumn" gorm:"type:varchar(64)"`
+	DebitColumn       string    `json:"debitColumn" gorm:"type:varchar(64)"`
+	ReferenceColumn   string    `json:"referenceColumn" gorm:"type:varchar(64)"`
+	RequiredColumns   string    `json:"requiredColumns" gorm:"type:varchar(512)"`
+	DecimalSeparator  string    `json:"decimalSeparator" gorm:"type:varchar(1);not null;default:'.'"`
+	ThousandSeparator string    `json:"thousandSeparator" gorm:"type:varchar(1)"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// Profile maps the schema onto the bank CSV column mapping so the same
+// parser reads both.
+func (s TransactionSchema) Profile() BankImportProfile {
+	return BankImportProfile{
+		Delimiter:         s.Delimiter,
+		SkipRows:          s.SkipRows,
+		DateColumn:        s.DateColumn,
+		DateFormat:        s.DateFormat,
+		DescriptionColumn: s.DescriptionColumn,
+		BranchColumn:      s.BranchColumn,
+		AmountColumn:      s.AmountColumn,
+		AmountTypeColumn:  s.AmountTypeColumn,
+		CreditMarker:      s.CreditMarker,
+		DebitMarker:       s.DebitMarker,
+		CreditColumn:      s.CreditColumn,
+		DebitColumn:       s.DebitColumn,
+		DecimalSeparator:  s.DecimalSeparator,
+		ThousandSeparator: s.ThousandSeparator,
+	}
+}
+
+// Required returns the trimmed RequiredColumns.
+func (s TransactionSchema) Required() []string {
+	var out []string
+	for _, c := range strings.Split(s.RequiredColumns, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
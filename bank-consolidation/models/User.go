@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Roles, from least to most privileged; each includes the ones before it.
+const (
+	RoleViewer     = "viewer"
+	RoleStaff      = "staff"
+	RoleSupervisor = "supervisor"
+	RoleAdmin      = "admin"
+)
+
+// User is a person signing in to the UI. Only the bcrypt hash of the
+// password is stored. Companies is the comma-separated list of company codes
+// the user may see, "*" for all; admins always see all.
+type User struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	Username     string         `json:"username" gorm:"type:varchar(64);not null;uniqueIndex"`
+	DisplayName  string         `json:"displayName" gorm:"type:varchar(255)"`
+	PasswordHash string         `json:"-" gorm:"type:varchar(255);not null"`
+	Role         string         `json:"role" gorm:"type:varchar(20);not null;default:'viewer'"`
+	Companies    string         `json:"companies" gorm:"type:varchar(512);not null;default:'*'"`
+	IsActive     bool           `json:"isActive" gorm:"not null;default:true"`
+	LastLoginAt  *time.Time     `json:"lastLoginAt"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+}